@@ -0,0 +1,432 @@
+package gtm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminHandler is an http.Handler of a JSON API for the operators to inspect and operate the transactions:
+//
+//	GET  /transactions                      list the transactions, see the parameters below
+//	GET  /transactions/{id}                 the transaction with its partners, partner results and resolutions
+//	POST /transactions/{id}/retry           retry the transaction now, see Manager.Retry
+//	POST /transactions/{id}/force-success   see Manager.ForceSuccess
+//	POST /transactions/{id}/force-fail      see Manager.ForceFail
+//	GET  /backlog                           the number of the transactions due to retry
+//
+// The list is filtered by the parameters name, result (comma separated, "unfinished" for no result),
+// created_after and created_before (RFC 3339), and paged by after_id and count (100 by default).
+// The body of the POST requests is optional, such as {"operator": "alice", "reason": "refunded by hand"}.
+// The operator of the context, see WithOperator, takes precedence over the body,
+// so that it can be set by the authentication middleware. The handler does not authenticate.
+// The storage of the manager should implement QueryStorage, InspectStorage, ResolutionStorage and CountStorage.
+type AdminHandler struct {
+	manager *Manager
+}
+
+// NewAdminHandler returns the AdminHandler of the manager, or of the default manager if it is nil.
+// Serve it under a prefix with http.StripPrefix, e.g.
+//
+//	http.Handle("/gtm/", http.StripPrefix("/gtm", gtm.NewAdminHandler(nil)))
+func NewAdminHandler(m *Manager) *AdminHandler {
+	if m == nil {
+		m = defaultManager
+	}
+
+	return &AdminHandler{manager: m}
+}
+
+// TransactionView is a transaction in the JSON of the AdminHandler.
+// Partners, PartnerResults and Resolutions are only set for a single transaction.
+type TransactionView struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Result    Result    `json:"result"`
+	Times     int       `json:"times"`
+	RetryAt   time.Time `json:"retry_at"`
+	Timeout   string    `json:"timeout"`
+	Cost      string    `json:"cost,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Partners       []*PartnerView       `json:"partners,omitempty"`
+	PartnerResults []*PartnerResultView `json:"partner_results,omitempty"`
+	Resolutions    []*ResolutionView    `json:"resolutions,omitempty"`
+}
+
+// PartnerView is a partner of a transaction, Kind is normal, uncertain, certain or async.
+type PartnerView struct {
+	Kind      string      `json:"kind"`
+	Offset    int         `json:"offset"`
+	Type      string      `json:"type"`
+	DependsOn []int       `json:"depends_on,omitempty"`
+	Value     interface{} `json:"value"`
+}
+
+// PartnerResultView is a saved result of a partner.
+type PartnerResultView struct {
+	Phase     string     `json:"phase"`
+	Offset    int        `json:"offset"`
+	Result    Result     `json:"result"`
+	Cost      string     `json:"cost,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ResolutionView is a resolution of a transaction.
+type ResolutionView struct {
+	Action    string    `json:"action"`
+	Operator  string    `json:"operator"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewTransactionView returns the view of the transaction and its saved state, without the partners.
+func NewTransactionView(tx *Transaction, result Result, cost time.Duration) *TransactionView {
+	v := &TransactionView{
+		ID:        tx.ID,
+		Name:      tx.Name,
+		Result:    result,
+		Times:     tx.Times,
+		RetryAt:   tx.RetryAt,
+		Timeout:   tx.Timeout.String(),
+		CreatedAt: tx.CreatedAt,
+	}
+	if cost > 0 {
+		v.Cost = cost.String()
+	}
+
+	return v
+}
+
+// AddPartners adds the views of the partners of the transaction, named by their registered names.
+func (v *TransactionView) AddPartners(tx *Transaction) *TransactionView {
+	add := func(kind string, offset int, partner interface{}) {
+		name, err := registeredName(partner)
+		if err != nil {
+			name = fmt.Sprintf("%T", partner)
+		}
+		v.Partners = append(v.Partners, &PartnerView{Kind: kind, Offset: offset, Type: name, Value: partner})
+	}
+
+	for i, p := range tx.NormalPartners {
+		add("normal", i, p)
+		v.Partners[len(v.Partners)-1].DependsOn = tx.Dependencies[i]
+	}
+	if tx.UncertainPartner != nil {
+		add("uncertain", 0, tx.UncertainPartner)
+	}
+	for i, p := range tx.CertainPartners {
+		add("certain", i, p)
+	}
+	for i, p := range tx.AsyncPartners {
+		add("async", i, p)
+	}
+
+	return v
+}
+
+// AddPartnerResults adds the views of the saved results of the partners.
+func (v *TransactionView) AddPartnerResults(records []*PartnerResultRecord) *TransactionView {
+	for _, r := range records {
+		view := &PartnerResultView{Phase: r.Phase, Offset: r.Offset, Result: r.Result}
+		if r.Cost > 0 {
+			view.Cost = r.Cost.String()
+		}
+		if !r.UpdatedAt.IsZero() {
+			updatedAt := r.UpdatedAt
+			view.UpdatedAt = &updatedAt
+		}
+		v.PartnerResults = append(v.PartnerResults, view)
+	}
+
+	return v
+}
+
+// AddResolutions adds the views of the resolutions.
+func (v *TransactionView) AddResolutions(resolutions []*Resolution) *TransactionView {
+	for _, r := range resolutions {
+		v.Resolutions = append(v.Resolutions, &ResolutionView{Action: r.Action, Operator: r.Operator, Reason: r.Reason, CreatedAt: r.CreatedAt})
+	}
+
+	return v
+}
+
+// errAdminNotFound is returned by the handlers when the path or the transaction is not found.
+var errAdminNotFound = errors.New("not found")
+
+// adminError is an error of the AdminHandler with the HTTP status.
+type adminError struct {
+	status int
+	err    error
+}
+
+func (e *adminError) Error() string {
+	return e.err.Error()
+}
+
+func newAdminError(status int, format string, args ...interface{}) error {
+	return &adminError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var (
+		method = http.MethodGet
+		handle func() (interface{}, error)
+	)
+	switch {
+	case len(parts) == 1 && parts[0] == "transactions":
+		handle = func() (interface{}, error) { return h.list(r) }
+	case len(parts) == 1 && parts[0] == "backlog":
+		handle = h.backlog
+	case len(parts) == 2 && parts[0] == "transactions":
+		handle = func() (interface{}, error) { return h.show(parts[1]) }
+	case len(parts) == 3 && parts[0] == "transactions":
+		method = http.MethodPost
+		handle = func() (interface{}, error) { return h.operate(r, parts[1], parts[2]) }
+	default:
+		method = r.Method
+		handle = func() (interface{}, error) { return nil, errAdminNotFound }
+	}
+
+	if r.Method != method {
+		handle = func() (interface{}, error) {
+			return nil, newAdminError(http.StatusMethodNotAllowed, "method not allowed: %v", r.Method)
+		}
+	}
+
+	value, err := handle()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if e, ok := err.(*adminError); ok {
+			status = e.status
+		} else if err == errAdminNotFound {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// list returns the transactions matching the parameters, and the after_id of the next page if there may be more.
+func (h *AdminHandler) list(r *http.Request) (interface{}, error) {
+	s, ok := h.manager.getStorage().(InspectStorage)
+	if !ok {
+		return nil, newAdminError(http.StatusNotImplemented, "storage does not implement InspectStorage")
+	}
+
+	filter, err := ParseTransactionFilter(r.URL.Query())
+	if err != nil {
+		return nil, newAdminError(http.StatusBadRequest, "%v", err)
+	}
+
+	records, err := s.ListTransactions(filter)
+	if err != nil {
+		return nil, fmt.Errorf("list transactions err: %v", err)
+	}
+
+	views := make([]*TransactionView, 0, len(records))
+	for _, record := range records {
+		views = append(views, NewTransactionView(record.Transaction, record.Result, record.Cost))
+	}
+
+	page := struct {
+		Transactions []*TransactionView `json:"transactions"`
+		NextAfterID  string             `json:"next_after_id,omitempty"`
+	}{Transactions: views}
+	if len(views) == filter.Count && len(views) > 0 {
+		page.NextAfterID = views[len(views)-1].ID
+	}
+
+	return page, nil
+}
+
+// ParseTransactionFilter returns the filter of the parameters of the list of AdminHandler.
+func ParseTransactionFilter(values map[string][]string) (filter TransactionFilter, err error) {
+	get := func(key string) string {
+		if v := values[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	filter.Name = get("name")
+	filter.AfterID = get("after_id")
+
+	if results := get("result"); results != "" {
+		for _, result := range strings.Split(results, ",") {
+			if result == "unfinished" {
+				result = ""
+			}
+			filter.Results = append(filter.Results, Result(result))
+		}
+	}
+
+	for key, t := range map[string]*time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if v := get(key); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return filter, fmt.Errorf("invalid %v: %v", key, err)
+			}
+		}
+	}
+
+	filter.Count = 100
+	if v := get("count"); v != "" {
+		if filter.Count, err = strconv.Atoi(v); err != nil || filter.Count <= 0 {
+			return filter, fmt.Errorf("invalid count: %v", v)
+		}
+	}
+
+	return filter, nil
+}
+
+// show returns the transaction with its partners, the results of the partners and the resolutions.
+func (h *AdminHandler) show(id string) (interface{}, error) {
+	if _, ok := h.manager.getStorage().(QueryStorage); !ok {
+		return nil, newAdminError(http.StatusNotImplemented, "storage does not implement QueryStorage")
+	}
+
+	view, err := InspectTransaction(h.manager.getStorage(), id)
+	if err == ErrTransactionNotFound {
+		return nil, errAdminNotFound
+	}
+
+	return view, err
+}
+
+// InspectTransaction returns the view of the transaction in the storage with its partners,
+// the results of the partners if the storage implements InspectStorage,
+// and the resolutions if it implements ResolutionStorage.
+// It requires the storage to implement QueryStorage, and returns ErrTransactionNotFound if there is no such transaction.
+func InspectTransaction(s Storage, id string) (*TransactionView, error) {
+	q, ok := s.(QueryStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not implement QueryStorage")
+	}
+
+	tx, result, err := q.GetTransaction(id)
+	if err == ErrTransactionNotFound {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("get transaction err: %w", err)
+	}
+
+	view := NewTransactionView(tx, result, 0).AddPartners(tx)
+
+	if s, ok := s.(InspectStorage); ok {
+		records, err := s.GetPartnerResults(id)
+		if err != nil {
+			return nil, fmt.Errorf("get partner results err: %v", err)
+		}
+		view.AddPartnerResults(records)
+	}
+
+	if s, ok := s.(ResolutionStorage); ok {
+		resolutions, err := s.GetResolutions(id)
+		if err != nil {
+			return nil, fmt.Errorf("get resolutions err: %v", err)
+		}
+		view.AddResolutions(resolutions)
+	}
+
+	return view, nil
+}
+
+// operate executes the action on the transaction, and returns its result.
+// The errors of the retry are returned with its result instead of as errors, as the transaction is retried later.
+func (h *AdminHandler) operate(r *http.Request, id, action string) (interface{}, error) {
+	var body struct {
+		Operator string `json:"operator"`
+		Reason   string `json:"reason"`
+	}
+	// The empty body, including a chunked one without ContentLength, has no operator and reason.
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		return nil, newAdminError(http.StatusBadRequest, "invalid body: %v", err)
+	}
+
+	ctx := r.Context()
+	if OperatorFromContext(ctx) == "" {
+		ctx = WithOperator(ctx, body.Operator)
+	}
+
+	q, ok := h.manager.getStorage().(QueryStorage)
+	if !ok {
+		return nil, newAdminError(http.StatusNotImplemented, "storage does not implement QueryStorage")
+	}
+	_, current, err := q.GetTransaction(id)
+	if err == ErrTransactionNotFound {
+		return nil, errAdminNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("get transaction err: %w", err)
+	}
+
+	var result Result
+	switch action {
+	case "retry":
+		if current != "" && current != Dead {
+			return nil, newAdminError(http.StatusConflict, "transaction is finished: %v, result = %v", id, current)
+		}
+		return h.retry(ctx, id)
+	case ActionForceSuccess:
+		result, err = Success, h.manager.ForceSuccess(ctx, id, body.Reason)
+	case ActionForceFail:
+		result, err = Fail, h.manager.ForceFail(ctx, id, body.Reason)
+	default:
+		return nil, errAdminNotFound
+	}
+
+	if _, ok := err.(conflictError); ok {
+		return nil, newAdminError(http.StatusConflict, "%v", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return map[string]Result{"result": result}, nil
+}
+
+func (h *AdminHandler) retry(ctx context.Context, id string) (interface{}, error) {
+	result, err := h.manager.Retry(ctx, id)
+
+	response := struct {
+		Result Result `json:"result"`
+		Error  string `json:"error,omitempty"`
+	}{Result: result}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	return response, nil
+}
+
+// backlog returns the number of the transactions due to retry.
+func (h *AdminHandler) backlog() (interface{}, error) {
+	s, ok := h.manager.getStorage().(CountStorage)
+	if !ok {
+		return nil, newAdminError(http.StatusNotImplemented, "storage does not implement CountStorage")
+	}
+
+	count, err := s.CountTimeoutTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("count timeout transactions err: %v", err)
+	}
+
+	return map[string]int{"count": count}, nil
+}
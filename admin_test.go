@@ -0,0 +1,176 @@
+package gtm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// adminRequest serves the request with the handler, and decodes the JSON response into the value.
+func adminRequest(t *testing.T, h http.Handler, method, target, body string, value interface{}) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	if value != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), value); err != nil {
+			t.Fatalf("%v %v: decode %q err = %v", method, target, w.Body.String(), err)
+		}
+	}
+
+	return w.Code
+}
+
+type transactionPage struct {
+	Transactions []*gtm.TransactionView `json:"transactions"`
+	NextAfterID  string                 `json:"next_after_id"`
+}
+
+func TestAdminHandlerList(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+	for _, name := range []string{"order", "refund", "order"} {
+		m.New(name).AddUncertain(&Counter{Result: gtm.Uncertain}).Execute()
+	}
+	h := gtm.NewAdminHandler(m)
+
+	var page transactionPage
+	if code := adminRequest(t, h, http.MethodGet, "/transactions?name=order&result=unfinished&count=1", "", &page); code != http.StatusOK {
+		t.Fatalf("list status = %v, want 200", code)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != "1" || page.NextAfterID != "1" {
+		t.Fatalf("list = %+v, want transaction 1 and next after 1", page)
+	}
+
+	page = transactionPage{}
+	adminRequest(t, h, http.MethodGet, "/transactions?name=order&result=unfinished&count=1&after_id=1", "", &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != "3" {
+		t.Fatalf("next page = %+v, want transaction 3", page)
+	}
+
+	page = transactionPage{}
+	adminRequest(t, h, http.MethodGet, "/transactions?result=success,fail", "", &page)
+	if len(page.Transactions) != 0 || page.NextAfterID != "" {
+		t.Errorf("finished = %+v, want none", page)
+	}
+
+	if code := adminRequest(t, h, http.MethodGet, "/transactions?created_after=yesterday", "", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid created_after status = %v, want 400", code)
+	}
+
+	var backlog map[string]int
+	if code := adminRequest(t, h, http.MethodGet, "/backlog", "", &backlog); code != http.StatusOK || backlog["count"] != 0 {
+		t.Errorf("backlog = %v, %v, want 0 as the retries are not due", code, backlog)
+	}
+}
+
+func TestAdminHandlerShowAndRetry(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+	normal, uncertain := &Counter{Result: gtm.Success}, &Counter{Result: gtm.Uncertain}
+	tx := m.New("order").AddNormal(normal).AddUncertain(uncertain)
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	h := gtm.NewAdminHandler(m)
+
+	var view gtm.TransactionView
+	if code := adminRequest(t, h, http.MethodGet, "/transactions/"+tx.ID, "", &view); code != http.StatusOK {
+		t.Fatalf("show status = %v, want 200", code)
+	}
+	if view.ID != tx.ID || view.Name != "order" || view.Result != "" || view.Times != 1 {
+		t.Errorf("show = %+v, want the unfinished transaction", view)
+	}
+	if len(view.Partners) != 2 || view.Partners[0].Kind != "normal" || view.Partners[1].Kind != "uncertain" {
+		t.Errorf("partners = %+v, want normal and uncertain", view.Partners)
+	}
+	if len(view.PartnerResults) != 1 || view.PartnerResults[0].Phase != gtm.PhaseDoNormal || view.PartnerResults[0].Result != gtm.Success {
+		t.Errorf("partner results = %+v, want do-normal success only", view.PartnerResults)
+	}
+
+	if code := adminRequest(t, h, http.MethodGet, "/transactions/"+tx.ID+"/retry", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET retry status = %v, want 405", code)
+	}
+	if do, _, _ := uncertain.Calls(); do != 1 {
+		t.Errorf("uncertain partner Do() called %v times after GET retry, want 1", do)
+	}
+
+	uncertain.Result = gtm.Success
+	var retried map[string]string
+	if code := adminRequest(t, h, http.MethodPost, "/transactions/"+tx.ID+"/retry", "", &retried); code != http.StatusOK || retried["result"] != string(gtm.Success) {
+		t.Fatalf("retry = %v, %v, want success", code, retried)
+	}
+	if _, doNext, _ := normal.Calls(); doNext != 1 {
+		t.Errorf("normal partner DoNext() called %v times, want 1", doNext)
+	}
+
+	if code := adminRequest(t, h, http.MethodPost, "/transactions/"+tx.ID+"/retry", "", nil); code != http.StatusConflict {
+		t.Errorf("retry of a successful transaction status = %v, want 409", code)
+	}
+	if code := adminRequest(t, h, http.MethodGet, "/transactions/100", "", nil); code != http.StatusNotFound {
+		t.Errorf("show of an unknown transaction status = %v, want 404", code)
+	}
+	if code := adminRequest(t, h, http.MethodGet, "/unknown", "", nil); code != http.StatusNotFound {
+		t.Errorf("unknown path status = %v, want 404", code)
+	}
+}
+
+func TestAdminHandlerForceFail(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+	tx, normal, _, _ := suspended(t, m, context.Background())
+	h := gtm.NewAdminHandler(m)
+
+	var response map[string]string
+	body := `{"operator": "alice", "reason": "the order is not created"}`
+	if code := adminRequest(t, h, http.MethodPost, "/transactions/"+tx.ID+"/force-fail", body, &response); code != http.StatusOK || response["result"] != string(gtm.Fail) {
+		t.Fatalf("force-fail = %v, %v, want fail", code, response)
+	}
+	if _, _, undo := normal.Calls(); undo != 1 {
+		t.Errorf("normal partner Undo() called %v times, want 1", undo)
+	}
+
+	var view gtm.TransactionView
+	adminRequest(t, h, http.MethodGet, "/transactions/"+tx.ID, "", &view)
+	if view.Result != gtm.Fail || len(view.Resolutions) != 2 {
+		t.Fatalf("show = %+v, want fail with 2 resolutions", view)
+	}
+	if r := view.Resolutions[1]; r.Action != gtm.ActionForceFail || r.Operator != "alice" || r.Reason != "the order is not created" {
+		t.Errorf("resolution = %+v, want force-fail by alice", r)
+	}
+
+	if code := adminRequest(t, h, http.MethodPost, "/transactions/"+tx.ID+"/force-success", "", nil); code != http.StatusConflict {
+		t.Errorf("force-success of a failed transaction status = %v, want 409", code)
+	}
+}
+
+func TestAdminHandlerOperateErrors(t *testing.T) {
+	s := gtm.NewMemoryStorage()
+	m := gtm.NewManager(s)
+	tx, _, _, _ := suspended(t, m, context.Background())
+
+	// The empty body without ContentLength, e.g. a chunked one, has no operator and reason.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/transactions/"+tx.ID+"/force-fail", strings.NewReader(""))
+	r.ContentLength = -1
+	gtm.NewAdminHandler(m).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("force-fail with a chunked empty body status = %v, %v, want 200", w.Code, w.Body)
+	}
+
+	// The errors of the storage are not conflicts.
+	tx = m.New("test-admin-storage-error").AddUncertain(&Counter{Result: gtm.Uncertain})
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	if err := s.SaveTransactionResult(tx, 0, gtm.Dead); err != nil {
+		t.Fatalf("SaveTransactionResult() err = %v", err)
+	}
+	h := gtm.NewAdminHandler(gtm.NewManager(BrokenResolutionStorage{s}))
+	if code := adminRequest(t, h, http.MethodPost, "/transactions/"+tx.ID+"/force-fail", "", nil); code != http.StatusInternalServerError {
+		t.Errorf("force-fail with a broken storage status = %v, want 500", code)
+	}
+}
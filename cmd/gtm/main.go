@@ -0,0 +1,17 @@
+// Command gtm inspects and operates the transactions in a storage, see package gtmcli for the usage.
+// It is built with the drivers of MySQL, PostgreSQL and SQLite, and without any partner registered,
+// so build your own command with gtmcli to decode the partners of your services.
+package main
+
+import (
+	"os"
+
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/quanhengzhuang/gtm/gtmcli"
+)
+
+func main() {
+	os.Exit(gtmcli.Main(os.Args[1:]))
+}
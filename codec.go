@@ -0,0 +1,736 @@
+package gtm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Codec encodes the persisted content of transactions.
+// The content must be text, binary encodings should be base64-encoded, so that it can be saved in a text column.
+// The storages save the name of the codec with the content, and decode it with the codec registered by the name.
+type Codec interface {
+	// Name returns the unique name of the codec, at most 20 characters.
+	Name() string
+
+	Marshal(tx *Transaction) (string, error)
+	Unmarshal(content string) (*Transaction, error)
+}
+
+var (
+	_ Codec = GobCodec{}
+	_ Codec = &JSONCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: make(map[string]Codec)}
+
+func init() {
+	RegisterCodec(GobCodec{})
+	RegisterCodec(&JSONCodec{})
+}
+
+// RegisterCodec records a codec by its name, so that the content encoded by it can be decoded.
+// GobCodec and JSONCodec are registered.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	codecs.m[c.Name()] = c
+}
+
+// CodecByName returns the codec registered by the name.
+// The empty name is the content saved before codecs were introduced, which is encoded by GobCodec.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		name = GobCodec{}.Name()
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %q", name)
+	}
+
+	return c, nil
+}
+
+// encodeTransaction encodes the transaction with the codec, GobCodec if it is nil.
+// Returns the name of the codec to be saved with the content.
+func encodeTransaction(c Codec, tx *Transaction) (name, content string, err error) {
+	if c == nil {
+		c = GobCodec{}
+	}
+
+	if content, err = c.Marshal(tx); err != nil {
+		return "", "", fmt.Errorf("%v encode err: %v", c.Name(), err)
+	}
+
+	return c.Name(), content, nil
+}
+
+// decodeTransaction decodes the content saved with the name of codec.
+// The codec c is used if it has the name, otherwise the registered one.
+func decodeTransaction(c Codec, name, content string) (*Transaction, error) {
+	if c == nil || c.Name() != name {
+		var err error
+		if c, err = CodecByName(name); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := c.Unmarshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("%v decode err: %w", c.Name(), err)
+	}
+
+	return tx, nil
+}
+
+// GobCodec encodes transactions with gob and base64, it is the default codec.
+// The types of partners and timers must be registered with Register or RegisterPartner,
+// the partners and the timer are encoded with their registered names, which are decoded with the aliases too.
+// The content saved by the previous versions, which is the Transaction encoded by gob, is still decoded.
+type GobCodec struct{}
+
+// gobTransaction is the gob document of a transaction.
+type gobTransaction struct {
+	ID               string
+	Name             string
+	Times            int
+	RetryAt          time.Time
+	Timeout          time.Duration
+	MaxAttempts      int
+	MaxAge           time.Duration
+	CreatedAt        time.Time
+	RequeuedAt       time.Time
+	Timer            *gobValue
+	NormalPartners   []gobValue
+	UncertainPartner *gobValue
+	CertainPartners  []gobValue
+	AsyncPartners    []gobValue
+	Dependencies     map[int][]int
+	Versions         map[string]int
+}
+
+type gobValue struct {
+	Type string
+	Data []byte
+}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (c GobCodec) Marshal(tx *Transaction) (string, error) {
+	doc := gobTransaction{
+		ID:           tx.ID,
+		Name:         tx.Name,
+		Times:        tx.Times,
+		RetryAt:      tx.RetryAt,
+		Timeout:      tx.Timeout,
+		MaxAttempts:  tx.MaxAttempts,
+		MaxAge:       tx.MaxAge,
+		CreatedAt:    tx.CreatedAt,
+		RequeuedAt:   tx.RequeuedAt,
+		Dependencies: tx.Dependencies,
+		Versions:     tx.Versions,
+	}
+
+	var err error
+	if tx.Timer != nil {
+		if doc.Timer, err = c.marshalValue(tx.Timer); err != nil {
+			return "", err
+		}
+	}
+	for _, partner := range tx.NormalPartners {
+		value, err := c.marshalValue(partner)
+		if err != nil {
+			return "", err
+		}
+		doc.NormalPartners = append(doc.NormalPartners, *value)
+	}
+	if tx.UncertainPartner != nil {
+		if doc.UncertainPartner, err = c.marshalValue(tx.UncertainPartner); err != nil {
+			return "", err
+		}
+	}
+	for _, partner := range tx.CertainPartners {
+		value, err := c.marshalValue(partner)
+		if err != nil {
+			return "", err
+		}
+		doc.CertainPartners = append(doc.CertainPartners, *value)
+	}
+	for _, partner := range tx.AsyncPartners {
+		value, err := c.marshalValue(partner)
+		if err != nil {
+			return "", err
+		}
+		doc.AsyncPartners = append(doc.AsyncPartners, *value)
+	}
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(&doc); err != nil {
+		return "", fmt.Errorf("gob encode err: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+}
+
+func (c GobCodec) Unmarshal(content string) (*Transaction, error) {
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode err: %v", err)
+	}
+
+	// The Transaction saved by the previous versions has the interface fields, which do not match the document.
+	var doc gobTransaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return c.unmarshalTransaction(data)
+	}
+
+	tx := &Transaction{
+		ID:           doc.ID,
+		Name:         doc.Name,
+		Times:        doc.Times,
+		RetryAt:      doc.RetryAt,
+		Timeout:      doc.Timeout,
+		MaxAttempts:  doc.MaxAttempts,
+		MaxAge:       doc.MaxAge,
+		CreatedAt:    doc.CreatedAt,
+		RequeuedAt:   doc.RequeuedAt,
+		Dependencies: doc.Dependencies,
+		Versions:     doc.Versions,
+	}
+
+	if doc.Timer != nil {
+		value, err := c.unmarshalValue(doc.Timer, doc.Versions)
+		if err != nil {
+			return nil, err
+		}
+		timer, ok := value.(Timer)
+		if !ok {
+			return nil, fmt.Errorf("type is not a Timer: %v", doc.Timer.Type)
+		}
+		tx.Timer = timer
+	}
+	for _, v := range doc.NormalPartners {
+		value, err := c.unmarshalValue(&v, doc.Versions)
+		if err != nil {
+			return nil, err
+		}
+		partner, ok := value.(NormalPartner)
+		if !ok {
+			return nil, fmt.Errorf("type is not a NormalPartner: %v", v.Type)
+		}
+		tx.NormalPartners = append(tx.NormalPartners, partner)
+	}
+	if doc.UncertainPartner != nil {
+		value, err := c.unmarshalValue(doc.UncertainPartner, doc.Versions)
+		if err != nil {
+			return nil, err
+		}
+		partner, ok := value.(UncertainPartner)
+		if !ok {
+			return nil, fmt.Errorf("type is not an UncertainPartner: %v", doc.UncertainPartner.Type)
+		}
+		tx.UncertainPartner = partner
+	}
+	if tx.CertainPartners, err = c.unmarshalCertainPartners(doc.CertainPartners, doc.Versions); err != nil {
+		return nil, err
+	}
+	if tx.AsyncPartners, err = c.unmarshalCertainPartners(doc.AsyncPartners, doc.Versions); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// unmarshalTransaction decodes the Transaction encoded by gob, whose interface values are named by gob.
+func (GobCodec) unmarshalTransaction(data []byte) (*Transaction, error) {
+	var tx Transaction
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil {
+		if name := gobNotRegistered(err); name != "" {
+			return nil, notRegisteredError(name)
+		}
+		return nil, fmt.Errorf("gob decode err: %v", err)
+	}
+
+	return &tx, nil
+}
+
+func (c GobCodec) unmarshalCertainPartners(values []gobValue, versions map[string]int) (partners []CertainPartner, err error) {
+	for _, v := range values {
+		value, err := c.unmarshalValue(&v, versions)
+		if err != nil {
+			return nil, err
+		}
+		partner, ok := value.(CertainPartner)
+		if !ok {
+			return nil, fmt.Errorf("type is not a CertainPartner: %v", v.Type)
+		}
+		partners = append(partners, partner)
+	}
+
+	return partners, nil
+}
+
+func (GobCodec) marshalValue(v interface{}) (*gobValue, error) {
+	typeName, err := registeredName(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, fmt.Errorf("gob encode err: %v, %v", typeName, err)
+	}
+
+	return &gobValue{Type: typeName, Data: buffer.Bytes()}, nil
+}
+
+// unmarshalValue decodes the value, into the type of the saved version if it is a previous version. See RegisterUpgrade.
+func (GobCodec) unmarshalValue(v *gobValue, versions map[string]int) (interface{}, error) {
+	ptr, err := newRegistered(decodeName(v.Type, versions))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(v.Data)).Decode(ptr.Interface()); err != nil {
+		if name := gobNotRegistered(err); name != "" {
+			return nil, notRegisteredError(name)
+		}
+		return nil, fmt.Errorf("gob decode err: %v, %v", v.Type, err)
+	}
+
+	return ptr.Elem().Interface(), nil
+}
+
+// ValueCodec encodes the values of the interface fields of transactions for JSONCodec,
+// which are the partners and the timer.
+type ValueCodec interface {
+	// MarshalValue returns the type name of the value and its JSON.
+	MarshalValue(v interface{}) (typeName string, data json.RawMessage, err error)
+
+	// UnmarshalValue returns a value of the type decoded from the JSON.
+	UnmarshalValue(typeName string, data json.RawMessage) (interface{}, error)
+}
+
+// JSONCodec encodes transactions to JSON, which is readable by humans and other languages.
+// The partners and the timer are encoded as {"type": name, "data": value} by Values.
+type JSONCodec struct {
+	// Values encodes the partners and the timer, JSONValueCodec if it is nil.
+	Values ValueCodec
+}
+
+// jsonTransaction is the JSON document of a transaction.
+type jsonTransaction struct {
+	Name             string         `json:"name"`
+	Times            int            `json:"times"`
+	RetryAt          time.Time      `json:"retry_at"`
+	Timeout          time.Duration  `json:"timeout"`
+	MaxAttempts      int            `json:"max_attempts,omitempty"`
+	MaxAge           time.Duration  `json:"max_age,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	RequeuedAt       time.Time      `json:"requeued_at"`
+	Timer            *jsonValue     `json:"timer,omitempty"`
+	NormalPartners   []jsonValue    `json:"normal_partners,omitempty"`
+	UncertainPartner *jsonValue     `json:"uncertain_partner,omitempty"`
+	CertainPartners  []jsonValue    `json:"certain_partners,omitempty"`
+	AsyncPartners    []jsonValue    `json:"async_partners,omitempty"`
+	Dependencies     map[int][]int  `json:"dependencies,omitempty"`
+	Versions         map[string]int `json:"versions,omitempty"`
+}
+
+type jsonValue struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (c *JSONCodec) Name() string {
+	return "json"
+}
+
+func (c *JSONCodec) Marshal(tx *Transaction) (string, error) {
+	doc := jsonTransaction{
+		Name:         tx.Name,
+		Times:        tx.Times,
+		RetryAt:      tx.RetryAt,
+		Timeout:      tx.Timeout,
+		MaxAttempts:  tx.MaxAttempts,
+		MaxAge:       tx.MaxAge,
+		CreatedAt:    tx.CreatedAt,
+		RequeuedAt:   tx.RequeuedAt,
+		Dependencies: tx.Dependencies,
+		Versions:     tx.Versions,
+	}
+
+	var err error
+	if tx.Timer != nil {
+		if doc.Timer, err = c.marshalValue(tx.Timer); err != nil {
+			return "", err
+		}
+	}
+	for _, partner := range tx.NormalPartners {
+		value, err := c.marshalValue(partner)
+		if err != nil {
+			return "", err
+		}
+		doc.NormalPartners = append(doc.NormalPartners, *value)
+	}
+	if tx.UncertainPartner != nil {
+		if doc.UncertainPartner, err = c.marshalValue(tx.UncertainPartner); err != nil {
+			return "", err
+		}
+	}
+	for _, partner := range tx.CertainPartners {
+		value, err := c.marshalValue(partner)
+		if err != nil {
+			return "", err
+		}
+		doc.CertainPartners = append(doc.CertainPartners, *value)
+	}
+	for _, partner := range tx.AsyncPartners {
+		value, err := c.marshalValue(partner)
+		if err != nil {
+			return "", err
+		}
+		doc.AsyncPartners = append(doc.AsyncPartners, *value)
+	}
+
+	content, err := json.Marshal(&doc)
+	if err != nil {
+		return "", fmt.Errorf("json encode err: %v", err)
+	}
+
+	return string(content), nil
+}
+
+func (c *JSONCodec) Unmarshal(content string) (*Transaction, error) {
+	var doc jsonTransaction
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, fmt.Errorf("json decode err: %v", err)
+	}
+
+	tx := &Transaction{
+		Name:         doc.Name,
+		Times:        doc.Times,
+		RetryAt:      doc.RetryAt,
+		Timeout:      doc.Timeout,
+		MaxAttempts:  doc.MaxAttempts,
+		MaxAge:       doc.MaxAge,
+		CreatedAt:    doc.CreatedAt,
+		RequeuedAt:   doc.RequeuedAt,
+		Dependencies: doc.Dependencies,
+		Versions:     doc.Versions,
+	}
+
+	if doc.Timer != nil {
+		value, err := c.unmarshalValue(doc.Timer, doc.Versions)
+		if err != nil {
+			return nil, err
+		}
+		timer, ok := value.(Timer)
+		if !ok {
+			return nil, fmt.Errorf("type is not a Timer: %v", doc.Timer.Type)
+		}
+		tx.Timer = timer
+	}
+	for _, v := range doc.NormalPartners {
+		value, err := c.unmarshalValue(&v, doc.Versions)
+		if err != nil {
+			return nil, err
+		}
+		partner, ok := value.(NormalPartner)
+		if !ok {
+			return nil, fmt.Errorf("type is not a NormalPartner: %v", v.Type)
+		}
+		tx.NormalPartners = append(tx.NormalPartners, partner)
+	}
+	if doc.UncertainPartner != nil {
+		value, err := c.unmarshalValue(doc.UncertainPartner, doc.Versions)
+		if err != nil {
+			return nil, err
+		}
+		partner, ok := value.(UncertainPartner)
+		if !ok {
+			return nil, fmt.Errorf("type is not an UncertainPartner: %v", doc.UncertainPartner.Type)
+		}
+		tx.UncertainPartner = partner
+	}
+	var err error
+	if tx.CertainPartners, err = c.unmarshalCertainPartners(doc.CertainPartners, doc.Versions); err != nil {
+		return nil, err
+	}
+	if tx.AsyncPartners, err = c.unmarshalCertainPartners(doc.AsyncPartners, doc.Versions); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func (c *JSONCodec) unmarshalCertainPartners(values []jsonValue, versions map[string]int) (partners []CertainPartner, err error) {
+	for _, v := range values {
+		value, err := c.unmarshalValue(&v, versions)
+		if err != nil {
+			return nil, err
+		}
+		partner, ok := value.(CertainPartner)
+		if !ok {
+			return nil, fmt.Errorf("type is not a CertainPartner: %v", v.Type)
+		}
+		partners = append(partners, partner)
+	}
+
+	return partners, nil
+}
+
+func (c *JSONCodec) values() ValueCodec {
+	if c.Values != nil {
+		return c.Values
+	}
+	return JSONValueCodec{}
+}
+
+func (c *JSONCodec) marshalValue(v interface{}) (*jsonValue, error) {
+	typeName, data, err := c.values().MarshalValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonValue{Type: typeName, Data: data}, nil
+}
+
+// unmarshalValue decodes the value, into the type of the saved version if it is a previous version. See RegisterUpgrade.
+func (c *JSONCodec) unmarshalValue(v *jsonValue, versions map[string]int) (interface{}, error) {
+	return c.values().UnmarshalValue(decodeName(v.Type, versions), v.Data)
+}
+
+// JSONValueCodec encodes the values with encoding/json, and names their types by Register.
+type JSONValueCodec struct{}
+
+func (JSONValueCodec) MarshalValue(v interface{}) (typeName string, data json.RawMessage, err error) {
+	if typeName, err = registeredName(v); err != nil {
+		return "", nil, err
+	}
+
+	if data, err = json.Marshal(v); err != nil {
+		return "", nil, fmt.Errorf("json encode err: %v, %v", typeName, err)
+	}
+
+	return typeName, data, nil
+}
+
+func (JSONValueCodec) UnmarshalValue(typeName string, data json.RawMessage) (interface{}, error) {
+	ptr, err := newRegistered(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, fmt.Errorf("json decode err: %v, %v", typeName, err)
+	}
+
+	return ptr.Elem().Interface(), nil
+}
+
+// types records the types of partners and timers by names.
+var types = struct {
+	sync.RWMutex
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+}{
+	names: make(map[reflect.Type]string),
+	types: make(map[string]reflect.Type),
+}
+
+// Register records the types of partners and timers for decoding, by their Go type names.
+// The values are registered to gob too, as gob.Register.
+// The persisted content depends on the names, so prefer RegisterPartner with stable names for partners.
+// The types already registered by RegisterPartner are skipped.
+func Register(values ...interface{}) {
+	for _, value := range values {
+		if _, err := registeredName(value); err == nil {
+			continue
+		}
+
+		registerGob(value)
+		registerType(typeName(reflect.TypeOf(value)), value)
+	}
+}
+
+// RegisterPartner records the type of a partner by a stable name, e.g. RegisterPartner("payer.v1", &Payer{}).
+// The persisted transactions keep decodable after the type is renamed or moved to another package.
+//
+// The aliases are the previous names of the type, the content encoded with them is decoded to the type.
+// The Go type name of a type registered by Register before is kept as an alias,
+// so the content saved before it is registered by RegisterPartner is still decoded.
+func RegisterPartner(name string, value interface{}, aliases ...string) {
+	if name == "" {
+		panic("gtm: RegisterPartner with an empty name")
+	}
+
+	registerGob(value)
+	registerType(name, value, aliases...)
+}
+
+// registerGob registers the value to gob, as gob.Register, for the content saved by the previous versions
+// and the interface fields of partners. The type registered to gob by another name before is skipped,
+// but it panics if the name of gob is registered by another type.
+func registerGob(value interface{}) {
+	defer func() {
+		if r := recover(); r != nil && !strings.HasPrefix(fmt.Sprint(r), "gob: registering duplicate names for") {
+			panic(fmt.Sprintf("gtm: register %T to gob: %v", value, r))
+		}
+	}()
+
+	gob.Register(value)
+}
+
+// registerType records the type by the name and the aliases.
+// It panics if the name or an alias is registered by another type.
+func registerType(name string, value interface{}, aliases ...string) {
+	types.Lock()
+	defer types.Unlock()
+
+	t := reflect.TypeOf(value)
+	for _, n := range append([]string{name}, aliases...) {
+		if registered, ok := types.types[n]; ok && registered != t {
+			panic(fmt.Sprintf("gtm: name %q of %v is registered by %v", n, t, registered))
+		}
+	}
+
+	types.names[t] = name
+	types.types[name] = t
+	for _, alias := range aliases {
+		types.types[alias] = t
+	}
+}
+
+// typeName returns the Go type name of t with the full package path, e.g. "*github.com/quanhengzhuang/gtm.DoubleTimer".
+// It differs from the name of gob, which names the unnamed pointer types by rt.String(), e.g. "*gtm.DoubleTimer".
+func typeName(t reflect.Type) string {
+	star := ""
+	if t.Name() == "" && t.Kind() == reflect.Ptr {
+		star = "*"
+		t = t.Elem()
+	}
+
+	if t.PkgPath() == "" {
+		return star + t.String()
+	}
+	return star + t.PkgPath() + "." + t.Name()
+}
+
+// registeredName returns the registered name of the type of v.
+func registeredName(v interface{}) (string, error) {
+	types.RLock()
+	defer types.RUnlock()
+
+	name, ok := types.names[reflect.TypeOf(v)]
+	if !ok {
+		return "", fmt.Errorf("type not registered: %T, register it with RegisterPartner or Register", v)
+	}
+
+	return name, nil
+}
+
+// newRegistered returns a pointer to a new value of the type registered by the name.
+func newRegistered(name string) (reflect.Value, error) {
+	types.RLock()
+	defer types.RUnlock()
+
+	t, ok := types.types[name]
+	if !ok {
+		return reflect.Value{}, notRegisteredError(name)
+	}
+
+	if t.Kind() == reflect.Ptr {
+		ptr := reflect.New(reflect.PtrTo(t.Elem()))
+		ptr.Elem().Set(reflect.New(t.Elem()))
+		return ptr, nil
+	}
+
+	return reflect.New(t), nil
+}
+
+// NotRegisteredError is returned when the content has a type not registered, mostly a partner
+// renamed or removed since the transaction was saved.
+type NotRegisteredError struct {
+	// Name is the name of the type in the content.
+	Name string
+}
+
+func (e *NotRegisteredError) Error() string {
+	return fmt.Sprintf("type not registered: %q, register it with RegisterPartner or Register", e.Name)
+}
+
+func notRegisteredError(name string) error {
+	return &NotRegisteredError{Name: name}
+}
+
+// DecodeError is returned by the storages when the content of a saved transaction can not be decoded.
+// The storages returning several transactions skip the ones which can not be decoded and log the DecodeError,
+// so that the others are still retried.
+type DecodeError struct {
+	ID string
+
+	// Partner is the name of the type not registered, "" if the content can not be decoded for another reason.
+	Partner string
+
+	Err error
+}
+
+// NewDecodeError returns the DecodeError of the transaction, used by the storages.
+func NewDecodeError(id string, err error) *DecodeError {
+	e := &DecodeError{ID: id, Err: err}
+
+	var notRegistered *NotRegisteredError
+	if errors.As(err, &notRegistered) {
+		e.Partner = notRegistered.Name
+	}
+
+	return e
+}
+
+func (e *DecodeError) Error() string {
+	if e.Partner != "" {
+		return fmt.Sprintf("tx decode err: %v, partner %v, %v", e.ID, e.Partner, e.Err)
+	}
+	return fmt.Sprintf("tx decode err: %v, %v", e.ID, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// gobNotRegistered returns the name in the error of gob decoding an unregistered interface value,
+// or "" if it is another error.
+func gobNotRegistered(err error) string {
+	const prefix = "gob: name not registered for interface: "
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, prefix) {
+		return ""
+	}
+
+	name, unquoteErr := strconv.Unquote(msg[len(prefix):])
+	if unquoteErr != nil {
+		return ""
+	}
+
+	return name
+}
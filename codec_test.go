@@ -0,0 +1,215 @@
+package gtm_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func init() {
+	gtm.Register(&Payer{}, &OrderCreator{}, &Notifier{})
+}
+
+func TestCodecs(t *testing.T) {
+	now := time.Now().UTC().Round(0)
+	tx := &gtm.Transaction{
+		Name:        "test-codec",
+		Times:       2,
+		RetryAt:     now,
+		Timeout:     time.Minute,
+		MaxAttempts: 5,
+		CreatedAt:   now,
+		RequeuedAt:  now.Add(time.Hour),
+		Timer:       gtm.NewBackoffTimer(time.Second, time.Hour, gtm.FullJitter),
+	}
+	tx.AddNormal(&Payer{OrderID: "100001", UserID: 20001, Amount: 99})
+	tx.AddNormalAfter(&Payer{OrderID: "100002", UserID: 20001, Amount: 1}, tx.NormalPartners[0])
+	tx.AddUncertain(&OrderCreator{OrderID: "100001", UserID: 20001, ProductID: 31, Amount: 99})
+	tx.AddCertain(&Notifier{OrderID: "100001"})
+	tx.AddAsync(&Notifier{OrderID: "100002"})
+
+	for _, codec := range []gtm.Codec{gtm.GobCodec{}, &gtm.JSONCodec{}} {
+		content, err := codec.Marshal(tx)
+		if err != nil {
+			t.Fatalf("%v Marshal() err = %v", codec.Name(), err)
+		}
+
+		got, err := codec.Unmarshal(content)
+		if err != nil {
+			t.Fatalf("%v Unmarshal() err = %v", codec.Name(), err)
+		}
+		if !reflect.DeepEqual(got, tx) {
+			t.Errorf("%v Unmarshal() = %+v, want %+v", codec.Name(), got, tx)
+		}
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	tx := gtm.New("test-json").AddNormal(&Payer{OrderID: "100001"})
+
+	content, err := (&gtm.JSONCodec{}).Marshal(tx)
+	if err != nil {
+		t.Fatalf("Marshal() err = %v", err)
+	}
+	if !strings.Contains(content, `"data":{"OrderID":"100001"`) {
+		t.Errorf("Marshal() = %v, want the partner in JSON", content)
+	}
+
+	content = strings.Replace(content, "gtm_test.Payer", "gtm_test.Unknown", 1)
+	if _, err := (&gtm.JSONCodec{}).Unmarshal(content); err == nil || !strings.Contains(err.Error(), "gtm_test.Unknown") {
+		t.Errorf("Unmarshal() of unknown type err = %v, want the type named", err)
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	if c, err := gtm.CodecByName(""); err != nil || c.Name() != "gob" {
+		t.Errorf("CodecByName(\"\") = %v, %v, want gob for the legacy content", c, err)
+	}
+	if c, err := gtm.CodecByName("json"); err != nil || c.Name() != "json" {
+		t.Errorf("CodecByName(json) = %v, %v, want json", c, err)
+	}
+	if _, err := gtm.CodecByName("xml"); err == nil {
+		t.Errorf("CodecByName(xml) returns no error")
+	}
+}
+
+type Refunder struct {
+	OrderID string
+}
+
+func (r *Refunder) DoNext() error {
+	return nil
+}
+
+func init() {
+	gtm.RegisterPartner("test.refunder.v2", &Refunder{}, "test.refunder.v1")
+}
+
+func TestRegisterPartner(t *testing.T) {
+	tx := gtm.New("test-register-partner").AddCertain(&Refunder{OrderID: "100001"})
+
+	content, err := (&gtm.JSONCodec{}).Marshal(tx)
+	if err != nil {
+		t.Fatalf("Marshal() err = %v", err)
+	}
+	if !strings.Contains(content, `"type":"test.refunder.v2"`) {
+		t.Errorf("Marshal() = %v, want the registered name", content)
+	}
+
+	// The content saved with the previous name is decoded by the alias.
+	content = strings.Replace(content, "test.refunder.v2", "test.refunder.v1", 1)
+	got, err := (&gtm.JSONCodec{}).Unmarshal(content)
+	if err != nil {
+		t.Fatalf("Unmarshal() of alias err = %v", err)
+	}
+	if r := got.CertainPartners[0].(*Refunder); r.OrderID != "100001" {
+		t.Errorf("Unmarshal() of alias = %+v, want the order id 100001", r)
+	}
+
+	for _, codec := range []gtm.Codec{gtm.GobCodec{}, &gtm.JSONCodec{}} {
+		content, err := codec.Marshal(tx)
+		if err != nil {
+			t.Fatalf("%v Marshal() err = %v", codec.Name(), err)
+		}
+
+		// The names have the same length, so that the gob content is still valid.
+		alias := replaceContent(t, codec, content, "test.refunder.v2", "test.refunder.v1")
+		if got, err := codec.Unmarshal(alias); err != nil || got.CertainPartners[0].(*Refunder).OrderID != "100001" {
+			t.Errorf("%v Unmarshal() of alias = %+v, %v, want the order id 100001", codec.Name(), got, err)
+		}
+
+		content = replaceContent(t, codec, content, "test.refunder.v2", "test.refunder.v9")
+		var notRegistered *gtm.NotRegisteredError
+		if _, err := codec.Unmarshal(content); !errors.As(err, &notRegistered) || notRegistered.Name != "test.refunder.v9" {
+			t.Errorf("%v Unmarshal() of unknown partner err = %v, want the partner named", codec.Name(), err)
+		}
+	}
+}
+
+type Wrapper struct {
+	OrderID string
+}
+
+func (w *Wrapper) DoNext() error {
+	return nil
+}
+
+func TestRegisterPartnerConflict(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), `"test.refunder.v1"`) {
+			t.Errorf("RegisterPartner() of a registered alias panics with %v, want the alias named", r)
+		}
+	}()
+
+	gtm.RegisterPartner("test.wrapper.v1", &Wrapper{}, "test.refunder.v1")
+}
+
+type Packer struct {
+	OrderID string
+}
+
+func (p *Packer) DoNext() error {
+	return nil
+}
+
+func TestRegisterPartnerAfterRegister(t *testing.T) {
+	gtm.Register(&Packer{})
+	tx := gtm.New("test-register-partner-after-register").AddCertain(&Packer{OrderID: "100001"})
+
+	// The content saved by Register, and by the versions encoding the Transaction with gob.
+	contents := make(map[gtm.Codec]string)
+	for _, codec := range []gtm.Codec{gtm.GobCodec{}, &gtm.JSONCodec{}} {
+		content, err := codec.Marshal(tx)
+		if err != nil {
+			t.Fatalf("%v Marshal() err = %v", codec.Name(), err)
+		}
+		contents[codec] = content
+	}
+	var legacy bytes.Buffer
+	if err := gob.NewEncoder(&legacy).Encode(tx); err != nil {
+		t.Fatalf("gob encode err: %v", err)
+	}
+
+	gtm.RegisterPartner("test.packer.v1", &Packer{})
+	gtm.Register(&Packer{})
+	gtm.RegisterPartner("test.packer.v1", &Packer{})
+
+	for codec, content := range contents {
+		if got, err := codec.Unmarshal(content); err != nil || got.CertainPartners[0].(*Packer).OrderID != "100001" {
+			t.Errorf("%v Unmarshal() = %+v, %v, want the order id 100001", codec.Name(), got, err)
+		}
+	}
+	content := base64.StdEncoding.EncodeToString(legacy.Bytes())
+	if got, err := (gtm.GobCodec{}).Unmarshal(content); err != nil || got.CertainPartners[0].(*Packer).OrderID != "100001" {
+		t.Errorf("Unmarshal() of legacy gob = %+v, %v, want the order id 100001", got, err)
+	}
+
+	content, err := (gtm.GobCodec{}).Marshal(tx)
+	if err != nil {
+		t.Fatalf("Marshal() err = %v", err)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(content); !bytes.Contains(data, []byte("test.packer.v1")) {
+		t.Errorf("Marshal() = %q, want the registered name", data)
+	}
+}
+
+func replaceContent(t *testing.T, codec gtm.Codec, content, old, new string) string {
+	if codec.Name() != "gob" {
+		return strings.Replace(content, old, new, 1)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		t.Fatalf("base64 decode err: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(bytes.Replace(data, []byte(old), []byte(new), 1))
+}
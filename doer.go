@@ -16,18 +16,34 @@ var (
 	_ Doer = &SequenceDoer{}
 )
 
+// Phases under which the partner results are saved, and which are passed to the Listener.
+// The offset of a partner is its position in the phase.
+const (
+	PhaseDoNormal    = "do-normal"
+	PhaseDoUncertain = "do-uncertain"
+	PhaseDoNext      = "doNext"
+	PhaseUndo        = "undo"
+
+	// phaseCancel marks a transaction canceled with a Fail result at offset 0, it is not a phase of partners.
+	phaseCancel = "cancel"
+)
+
 // SequenceDoer is an sequentially executor.
 // All methods of partner will be executed in the order of registration.
 type SequenceDoer struct{}
 
 func (*SequenceDoer) DoNormal(tx *Transaction) (result Result, undoOffset int, err error) {
-	phase := "do-normal"
+	phase := PhaseDoNormal
 
 	for i, partner := range tx.NormalPartners {
 		if result = tx.getPartnerResult(phase, i); result == "" {
+			if err := tx.Context().Err(); err != nil {
+				return Uncertain, i - 1, fmt.Errorf("context done before do: %v, %v, %v", phase, i, err)
+			}
+
 			begin := time.Now()
-			result, err = partner.Do()
-			if err := tx.storage().SavePartnerResult(tx, phase, i, time.Since(begin), result); err != nil {
+			result, err = partnerDo(tx, phase, i, partner)
+			if err := tx.savePartnerResult(phase, i, time.Since(begin), result); err != nil {
 				return Uncertain, i, fmt.Errorf("save partner result failed: %v, %v, %v, %v", phase, i, result, err)
 			}
 		}
@@ -48,17 +64,26 @@ func (*SequenceDoer) DoNormal(tx *Transaction) (result Result, undoOffset int, e
 }
 
 func (*SequenceDoer) DoUncertain(tx *Transaction) (result Result, undoOffset int, err error) {
+	return doUncertain(tx)
+}
+
+// doUncertain executes the UncertainPartner, it is shared by all doers.
+func doUncertain(tx *Transaction) (result Result, undoOffset int, err error) {
 	if tx.UncertainPartner == nil {
 		return Success, 0, nil
 	}
 
-	phase := "do-uncertain"
+	phase := PhaseDoUncertain
 
 	if result = tx.getPartnerResult(phase, 0); result == "" {
+		if err := tx.Context().Err(); err != nil {
+			return Uncertain, 0, fmt.Errorf("context done before do: %v, %v", phase, err)
+		}
+
 		begin := time.Now()
-		result, err = tx.UncertainPartner.Do()
+		result, err = partnerDo(tx, phase, 0, tx.UncertainPartner)
 		if result == Success || result == Fail {
-			if err := tx.storage().SavePartnerResult(tx, phase, 0, time.Since(begin), result); err != nil {
+			if err := tx.savePartnerResult(phase, 0, time.Since(begin), result); err != nil {
 				return Uncertain, 0, fmt.Errorf("save partner result failed: %v, %v, %v", phase, result, err)
 			}
 		}
@@ -77,28 +102,21 @@ func (*SequenceDoer) DoUncertain(tx *Transaction) (result Result, undoOffset int
 }
 
 func (*SequenceDoer) DoNext(tx *Transaction) (done bool, err error) {
-	var partners []CertainPartner
-	for _, v := range tx.NormalPartners {
-		partners = append(partners, v)
-	}
-
-	partners = append(partners, tx.CertainPartners...)
-
-	if tx.Times > 1 {
-		partners = append(partners, tx.AsyncPartners...)
-		done = true
-	}
-
-	phase := "doNext"
+	partners, done := nextPartners(tx)
+	phase := PhaseDoNext
 
 	for i, v := range partners {
 		if result := tx.getPartnerResult(phase, i); result != Success {
+			if err := tx.Context().Err(); err != nil {
+				return done, fmt.Errorf("context done before doNext: %v, %v, %v", phase, i, err)
+			}
+
 			begin := time.Now()
-			if err = v.DoNext(); err != nil {
+			if err = partnerDoNext(tx, phase, i, v); err != nil {
 				return done, fmt.Errorf("partner return err: %v, %v, %v", phase, i, err)
 			}
 
-			if err := tx.storage().SavePartnerResult(tx, phase, i, time.Since(begin), Success); err != nil {
+			if err := tx.savePartnerResult(phase, i, time.Since(begin), Success); err != nil {
 				return done, fmt.Errorf("save partner result failed: %v, %v, %v", phase, i, err)
 			}
 		}
@@ -108,16 +126,20 @@ func (*SequenceDoer) DoNext(tx *Transaction) (done bool, err error) {
 }
 
 func (*SequenceDoer) Undo(tx *Transaction, undoOffset int) (err error) {
-	phase := "undo"
+	phase := PhaseUndo
 
 	for i := undoOffset; i >= 0; i-- {
 		if result := tx.getPartnerResult(phase, i); result != Success {
+			if err := tx.Context().Err(); err != nil {
+				return fmt.Errorf("context done before undo: %v, %v, %v", phase, i, err)
+			}
+
 			begin := time.Now()
-			if err := tx.NormalPartners[i].Undo(); err != nil {
+			if err := partnerUndo(tx, phase, i, tx.NormalPartners[i]); err != nil {
 				return fmt.Errorf("partner return err: %v, %v, %v", phase, i, err)
 			}
 
-			if err := tx.storage().SavePartnerResult(tx, phase, i, time.Since(begin), Success); err != nil {
+			if err := tx.savePartnerResult(phase, i, time.Since(begin), Success); err != nil {
 				return fmt.Errorf("save partner result failed: %v, %v, %v", phase, i, err)
 			}
 		}
@@ -125,3 +147,20 @@ func (*SequenceDoer) Undo(tx *Transaction, undoOffset int) (err error) {
 
 	return nil
 }
+
+// nextPartners returns the partners of the doNext phase in the order of their offsets.
+// The async partners are included since the second execution, and then the phase is done.
+func nextPartners(tx *Transaction) (partners []CertainPartner, done bool) {
+	for _, v := range tx.NormalPartners {
+		partners = append(partners, v)
+	}
+
+	partners = append(partners, tx.CertainPartners...)
+
+	if tx.Times > 1 {
+		partners = append(partners, tx.AsyncPartners...)
+		done = true
+	}
+
+	return partners, done
+}
@@ -0,0 +1,266 @@
+package gtm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	_ Doer = &GraphDoer{}
+)
+
+// GraphDoer executes the partners as a dependency graph with maximal parallelism.
+// The dependencies are declared by tx.AddNormalAfter() and saved with the transaction,
+// so a retry resumes the graph from the saved partner results.
+// The Do and DoNext of a NormalPartner are executed after all its dependencies succeeded,
+// its Undo is executed after all partners depending on it are undone.
+// CertainPartners and AsyncPartners have no dependencies and are executed concurrently.
+type GraphDoer struct {
+	// Concurrency limits the partners executed at the same time.
+	// Zero means no limit.
+	Concurrency int
+}
+
+// NewGraphDoer returns a GraphDoer executing at most concurrency partners at the same time.
+func NewGraphDoer(concurrency int) *GraphDoer {
+	return &GraphDoer{Concurrency: concurrency}
+}
+
+// DoNormal executes the Do of NormalPartners in the dependency graph.
+// A partner whose dependency did not succeed is not executed.
+// If any partner fails or is uncertain, Fail is returned and
+// Undo will rollback the ones that succeeded or were uncertain.
+// If a result can not be saved, Uncertain is returned and the transaction will be retried.
+func (d *GraphDoer) DoNormal(tx *Transaction) (result Result, undoOffset int, err error) {
+	phase := PhaseDoNormal
+	results := make([]Result, len(tx.NormalPartners))
+	errs := make([]error, len(tx.NormalPartners))
+
+	deps, err := dependencies(tx, len(tx.NormalPartners))
+	if err != nil {
+		return Uncertain, 0, err
+	}
+
+	d.walk(deps, func(i int) bool {
+		if results[i] = tx.getPartnerResult(phase, i); results[i] == "" {
+			if err := tx.Context().Err(); err != nil {
+				errs[i] = fmt.Errorf("context done before do: %v, %v, %v", phase, i, err)
+				return false
+			}
+
+			begin := time.Now()
+			result, err := partnerDo(tx, phase, i, tx.NormalPartners[i])
+			if err := tx.savePartnerResult(phase, i, time.Since(begin), result); err != nil {
+				errs[i] = fmt.Errorf("save partner result failed: %v, %v, %v, %v", phase, i, result, err)
+				return false
+			}
+
+			results[i], errs[i] = result, err
+		}
+
+		switch results[i] {
+		case Success:
+			return true
+		case Fail, Uncertain:
+			errs[i] = fmt.Errorf("do's %v: %v, %v", results[i], i, errs[i])
+			return false
+		default:
+			panic("unexpect result value: " + results[i])
+		}
+	})
+
+	result = Success
+	for i, v := range results {
+		if v == Fail || v == Uncertain {
+			if result == Success {
+				result = Fail
+			}
+		} else if v == "" && errs[i] != nil {
+			result = Uncertain
+		}
+	}
+
+	switch result {
+	case Success:
+		return Success, 0, nil
+	case Fail:
+		return Fail, len(tx.NormalPartners) - 1, fmt.Errorf("do's failed: %v", joinErrors(errs))
+	default:
+		return Uncertain, 0, fmt.Errorf("do's unsaved: %v", joinErrors(errs))
+	}
+}
+
+func (*GraphDoer) DoUncertain(tx *Transaction) (result Result, undoOffset int, err error) {
+	return doUncertain(tx)
+}
+
+// DoNext executes the DoNext of all partners in the dependency graph.
+func (d *GraphDoer) DoNext(tx *Transaction) (done bool, err error) {
+	partners, done := nextPartners(tx)
+	phase := PhaseDoNext
+	errs := make([]error, len(partners))
+
+	deps, err := dependencies(tx, len(partners))
+	if err != nil {
+		return false, err
+	}
+
+	d.walk(deps, func(i int) bool {
+		if result := tx.getPartnerResult(phase, i); result == Success {
+			return true
+		}
+
+		if err := tx.Context().Err(); err != nil {
+			errs[i] = fmt.Errorf("context done before doNext: %v, %v, %v", phase, i, err)
+			return false
+		}
+
+		begin := time.Now()
+		if err := partnerDoNext(tx, phase, i, partners[i]); err != nil {
+			errs[i] = fmt.Errorf("partner return err: %v, %v, %v", phase, i, err)
+			return false
+		}
+
+		if err := tx.savePartnerResult(phase, i, time.Since(begin), Success); err != nil {
+			errs[i] = fmt.Errorf("save partner result failed: %v, %v, %v", phase, i, err)
+			return false
+		}
+
+		return true
+	})
+
+	return done, joinErrors(errs)
+}
+
+// Undo executes the Undo of NormalPartners up to undoOffset in reverse dependency order.
+// Partners whose Do failed, or which were not executed because of their dependencies, are skipped.
+func (d *GraphDoer) Undo(tx *Transaction, undoOffset int) (err error) {
+	phase := PhaseUndo
+	deps, err := dependencies(tx, undoOffset+1)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, undoOffset+1)
+
+	// Reverse the graph: a partner is undone after its dependents.
+	dependents := make([][]int, len(deps))
+	for i, v := range deps {
+		for _, dep := range v {
+			dependents[dep] = append(dependents[dep], i)
+		}
+	}
+
+	d.walk(dependents, func(i int) bool {
+		if !d.needUndo(tx, deps, i) {
+			return true
+		}
+
+		if result := tx.getPartnerResult(phase, i); result == Success {
+			return true
+		}
+
+		if err := tx.Context().Err(); err != nil {
+			errs[i] = fmt.Errorf("context done before undo: %v, %v, %v", phase, i, err)
+			return false
+		}
+
+		begin := time.Now()
+		if err := partnerUndo(tx, phase, i, tx.NormalPartners[i]); err != nil {
+			errs[i] = fmt.Errorf("partner return err: %v, %v, %v", phase, i, err)
+			return false
+		}
+
+		if err := tx.savePartnerResult(phase, i, time.Since(begin), Success); err != nil {
+			errs[i] = fmt.Errorf("save partner result failed: %v, %v, %v", phase, i, err)
+			return false
+		}
+
+		return true
+	})
+
+	for i := range errs {
+		if errs[i] == nil && d.needUndo(tx, deps, i) && tx.getPartnerResult(phase, i) != Success {
+			errs[i] = fmt.Errorf("undo blocked by dependents: %v, %v", phase, i)
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// needUndo reports whether the Do of the partner may have taken effect.
+// A partner without result is undone only if all its dependencies succeeded,
+// because it may have been executed with its result lost.
+func (d *GraphDoer) needUndo(tx *Transaction, deps [][]int, i int) bool {
+	switch tx.getPartnerResult(PhaseDoNormal, i) {
+	case Fail:
+		return false
+	case "":
+		for _, dep := range deps[i] {
+			if tx.getPartnerResult(PhaseDoNormal, dep) != Success {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// walk calls fn for the nodes 0 to n-1 concurrently.
+// A node is called after all its dependencies returned true, and skipped if any of them did not.
+func (d *GraphDoer) walk(deps [][]int, fn func(i int) bool) {
+	n := len(deps)
+	ok := make([]bool, n)
+	finished := make([]chan struct{}, n)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+
+	limit := d.Concurrency
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			defer close(finished[i])
+
+			for _, dep := range deps[i] {
+				<-finished[dep]
+				if !ok[dep] {
+					return
+				}
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ok[i] = fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// dependencies returns the dependencies of the first n partners of the transaction.
+// Only NormalPartners have dependencies, the offsets of others are out of tx.Dependencies.
+// The dependencies are loaded from the storage, so an invalid one is returned as an error.
+func dependencies(tx *Transaction, n int) ([][]int, error) {
+	deps := make([][]int, n)
+
+	for i := range deps {
+		for _, dep := range tx.Dependencies[i] {
+			if dep < 0 || dep >= i {
+				return nil, fmt.Errorf("invalid dependency of partner %v: %v", i, dep)
+			}
+			deps[i] = append(deps[i], dep)
+		}
+	}
+
+	return deps, nil
+}
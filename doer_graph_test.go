@@ -0,0 +1,133 @@
+package gtm_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// steps records the calls of Step partners in order.
+type steps struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *steps) add(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+// Step is a normal partner recording its calls.
+type Step struct {
+	Name   string
+	Result gtm.Result
+
+	steps *steps
+}
+
+func (s *Step) Do() (gtm.Result, error) {
+	s.steps.add("do-" + s.Name)
+	if s.Result != gtm.Success {
+		return s.Result, fmt.Errorf("step %v", s.Result)
+	}
+	return gtm.Success, nil
+}
+
+func (s *Step) DoNext() error {
+	s.steps.add("next-" + s.Name)
+	return nil
+}
+
+func (s *Step) Undo() error {
+	s.steps.add("undo-" + s.Name)
+	return nil
+}
+
+func TestGraphDoerSuccess(t *testing.T) {
+	m := gtm.NewManager(gtm.DefaultManager().Storage()).SetDoer(gtm.NewGraphDoer(0))
+	s := &steps{}
+
+	a := &Step{Name: "a", Result: gtm.Success, steps: s}
+	b := &Step{Name: "b", Result: gtm.Success, steps: s}
+	c := &Step{Name: "c", Result: gtm.Success, steps: s}
+
+	tx := m.New("test-graph-success")
+	tx.AddNormal(a)
+	tx.AddNormalAfter(b, a)
+	tx.AddNormalAfter(c, b)
+
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	want := []string{"do-a", "do-b", "do-c", "next-a", "next-b", "next-c"}
+	if !reflect.DeepEqual(s.calls, want) {
+		t.Errorf("calls = %v, want %v", s.calls, want)
+	}
+}
+
+func TestGraphDoerFail(t *testing.T) {
+	m := gtm.NewManager(gtm.DefaultManager().Storage()).SetDoer(gtm.NewGraphDoer(0))
+	s := &steps{}
+
+	a := &Step{Name: "a", Result: gtm.Success, steps: s}
+	b := &Step{Name: "b", Result: gtm.Success, steps: s}
+	c := &Step{Name: "c", Result: gtm.Fail, steps: s}
+	d := &Step{Name: "d", Result: gtm.Success, steps: s}
+
+	tx := m.New("test-graph-fail")
+	tx.AddNormal(a)
+	tx.AddNormalAfter(b, a)
+	tx.AddNormalAfter(c, b)
+	tx.AddNormalAfter(d, c)
+
+	if result, err := tx.Execute(); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+
+	want := []string{"do-a", "do-b", "do-c", "undo-b", "undo-a"}
+	if !reflect.DeepEqual(s.calls, want) {
+		t.Errorf("calls = %v, want %v", s.calls, want)
+	}
+}
+
+// Batch is a normal partner of an uncomparable type.
+type Batch struct {
+	IDs []int
+}
+
+func (b Batch) Do() (gtm.Result, error) { return gtm.Success, nil }
+func (b Batch) DoNext() error           { return nil }
+func (b Batch) Undo() error             { return nil }
+
+func TestAddNormalAfterUncomparable(t *testing.T) {
+	tx := gtm.NewManager(gtm.NewMemoryStorage()).New("test-graph-uncomparable")
+	tx.AddNormal(Batch{IDs: []int{1}})
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "not comparable") {
+			t.Errorf("AddNormalAfter() panics with %v, want not comparable", r)
+		}
+	}()
+	tx.AddNormalAfter(Batch{IDs: []int{2}}, Batch{IDs: []int{1}})
+}
+
+func TestGraphDoerInvalidDependency(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetDoer(gtm.NewGraphDoer(0))
+	s := &steps{}
+
+	tx := m.New("test-graph-invalid").AddNormal(&Step{Name: "a", Result: gtm.Success, steps: s}, &Step{Name: "b", Result: gtm.Success, steps: s})
+	tx.Dependencies = map[int][]int{1: {5}}
+
+	if result, err := tx.Execute(); result != gtm.Uncertain || err == nil || !strings.Contains(err.Error(), "invalid dependency") {
+		t.Fatalf("result = %v, err = %v, want uncertain with invalid dependency", result, err)
+	}
+	if len(s.calls) != 0 {
+		t.Errorf("calls = %v, want none", s.calls)
+	}
+}
@@ -0,0 +1,197 @@
+package gtm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ Doer = &ParallelDoer{}
+)
+
+// ParallelDoer is a concurrent executor.
+// The Do of NormalPartners is executed concurrently, and so are DoNext and Undo.
+// The UncertainPartner is still executed after all NormalPartners succeed.
+// Partners must not depend on each other's order.
+type ParallelDoer struct {
+	// Concurrency limits the partners executed at the same time.
+	// Zero means no limit.
+	Concurrency int
+}
+
+// NewParallelDoer returns a ParallelDoer executing at most concurrency partners at the same time.
+func NewParallelDoer(concurrency int) *ParallelDoer {
+	return &ParallelDoer{Concurrency: concurrency}
+}
+
+// DoNormal executes all NormalPartners concurrently.
+// If any of them fails or is uncertain, Fail is returned and
+// Undo will rollback the ones that succeeded or were uncertain.
+// If a result can not be saved, Uncertain is returned and the transaction will be retried.
+func (d *ParallelDoer) DoNormal(tx *Transaction) (result Result, undoOffset int, err error) {
+	phase := PhaseDoNormal
+	results := make([]Result, len(tx.NormalPartners))
+	errs := make([]error, len(tx.NormalPartners))
+
+	d.run(len(tx.NormalPartners), func(i int) {
+		if results[i] = tx.getPartnerResult(phase, i); results[i] != "" {
+			return
+		}
+
+		if err := tx.Context().Err(); err != nil {
+			errs[i] = fmt.Errorf("context done before do: %v, %v, %v", phase, i, err)
+			return
+		}
+
+		begin := time.Now()
+		result, err := partnerDo(tx, phase, i, tx.NormalPartners[i])
+		if err := tx.savePartnerResult(phase, i, time.Since(begin), result); err != nil {
+			errs[i] = fmt.Errorf("save partner result failed: %v, %v, %v, %v", phase, i, result, err)
+			return
+		}
+
+		results[i], errs[i] = result, err
+	})
+
+	result = Success
+	for i, v := range results {
+		switch v {
+		case Success:
+			// continue
+		case Fail, Uncertain:
+			if result == Success {
+				result = Fail
+			}
+		case "":
+			result = Uncertain
+		default:
+			panic("unexpect result value: " + v)
+		}
+
+		if v != Success && errs[i] != nil {
+			errs[i] = fmt.Errorf("do's %v: %v, %v", v, i, errs[i])
+		}
+	}
+
+	switch result {
+	case Success:
+		return Success, 0, nil
+	case Fail:
+		return Fail, len(tx.NormalPartners) - 1, fmt.Errorf("do's failed: %v", joinErrors(errs))
+	default:
+		return Uncertain, 0, fmt.Errorf("do's unsaved: %v", joinErrors(errs))
+	}
+}
+
+func (*ParallelDoer) DoUncertain(tx *Transaction) (result Result, undoOffset int, err error) {
+	return doUncertain(tx)
+}
+
+// DoNext executes DoNext of all partners concurrently.
+func (d *ParallelDoer) DoNext(tx *Transaction) (done bool, err error) {
+	partners, done := nextPartners(tx)
+	phase := PhaseDoNext
+	errs := make([]error, len(partners))
+
+	d.run(len(partners), func(i int) {
+		if result := tx.getPartnerResult(phase, i); result == Success {
+			return
+		}
+
+		if err := tx.Context().Err(); err != nil {
+			errs[i] = fmt.Errorf("context done before doNext: %v, %v, %v", phase, i, err)
+			return
+		}
+
+		begin := time.Now()
+		if err := partnerDoNext(tx, phase, i, partners[i]); err != nil {
+			errs[i] = fmt.Errorf("partner return err: %v, %v, %v", phase, i, err)
+			return
+		}
+
+		if err := tx.savePartnerResult(phase, i, time.Since(begin), Success); err != nil {
+			errs[i] = fmt.Errorf("save partner result failed: %v, %v, %v", phase, i, err)
+		}
+	})
+
+	return done, joinErrors(errs)
+}
+
+// Undo executes Undo of the NormalPartners up to undoOffset concurrently.
+// Partners whose Do failed are skipped, because gtm thinks there is no impact.
+func (d *ParallelDoer) Undo(tx *Transaction, undoOffset int) (err error) {
+	phase := PhaseUndo
+	errs := make([]error, undoOffset+1)
+
+	d.run(undoOffset+1, func(i int) {
+		if result := tx.getPartnerResult(PhaseDoNormal, i); result == Fail {
+			return
+		}
+
+		if result := tx.getPartnerResult(phase, i); result == Success {
+			return
+		}
+
+		if err := tx.Context().Err(); err != nil {
+			errs[i] = fmt.Errorf("context done before undo: %v, %v, %v", phase, i, err)
+			return
+		}
+
+		begin := time.Now()
+		if err := partnerUndo(tx, phase, i, tx.NormalPartners[i]); err != nil {
+			errs[i] = fmt.Errorf("partner return err: %v, %v, %v", phase, i, err)
+			return
+		}
+
+		if err := tx.savePartnerResult(phase, i, time.Since(begin), Success); err != nil {
+			errs[i] = fmt.Errorf("save partner result failed: %v, %v, %v", phase, i, err)
+		}
+	})
+
+	return joinErrors(errs)
+}
+
+// run calls fn for 0 to n-1 concurrently, and waits for all of them.
+func (d *ParallelDoer) run(n int, fn func(i int)) {
+	limit := d.Concurrency
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, limit)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// joinErrors returns an error combining all non-nil errors, or nil if there is none.
+func joinErrors(errs []error) error {
+	var messages []string
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%v", strings.Join(messages, "; "))
+}
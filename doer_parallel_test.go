@@ -0,0 +1,48 @@
+package gtm_test
+
+import (
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func TestParallelDoerSuccess(t *testing.T) {
+	m := gtm.NewManager(gtm.DefaultManager().Storage()).SetDoer(gtm.NewParallelDoer(2))
+
+	partners := []*Counter{{Result: gtm.Success}, {Result: gtm.Success}, {Result: gtm.Success}}
+	tx := m.New("test-parallel-success")
+	for _, p := range partners {
+		tx.AddNormal(p)
+	}
+
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	for i, p := range partners {
+		if do, doNext, undo := p.Calls(); do != 1 || doNext != 1 || undo != 0 {
+			t.Errorf("partner %v calls = %v, %v, %v, want 1, 1, 0", i, do, doNext, undo)
+		}
+	}
+}
+
+func TestParallelDoerFail(t *testing.T) {
+	m := gtm.NewManager(gtm.DefaultManager().Storage()).SetDoer(gtm.NewParallelDoer(0))
+
+	partners := []*Counter{{Result: gtm.Success}, {Result: gtm.Fail}, {Result: gtm.Uncertain}, {Result: gtm.Success}}
+	tx := m.New("test-parallel-fail")
+	for _, p := range partners {
+		tx.AddNormal(p)
+	}
+
+	if result, err := tx.Execute(); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+
+	wantUndo := []int{1, 0, 1, 1}
+	for i, p := range partners {
+		if do, doNext, undo := p.Calls(); do != 1 || doNext != 0 || undo != wantUndo[i] {
+			t.Errorf("partner %v calls = %v, %v, %v, want 1, 0, %v", i, do, doNext, undo, wantUndo[i])
+		}
+	}
+}
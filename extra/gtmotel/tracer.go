@@ -0,0 +1,79 @@
+// Package gtmotel provides a gtm.Tracer starting the spans by OpenTelemetry.
+//
+//	gtm.SetTracer(gtmotel.NewTracer(otel.GetTracerProvider()))
+//
+// The spans of the partners are children of the span of the execution,
+// and their contexts are passed to the context-aware partners,
+// so that the spans started by the partners are children of them.
+package gtmotel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quanhengzhuang/gtm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer got from the provider.
+const InstrumentationName = "github.com/quanhengzhuang/gtm"
+
+// Tracer is a gtm.Tracer using an OpenTelemetry tracer.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ gtm.Tracer = &Tracer{}
+
+// NewTracer returns a Tracer using the tracer of the provider.
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: provider.Tracer(InstrumentationName)}
+}
+
+// Start starts a span as a child of the span in the context.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...gtm.Attribute) (context.Context, gtm.Span) {
+	ctx, s := t.tracer.Start(ctx, name, trace.WithAttributes(convert(attrs)...))
+	return ctx, &span{span: s}
+}
+
+// span is a gtm.Span of OpenTelemetry.
+type span struct {
+	span trace.Span
+}
+
+func (s *span) SetAttributes(attrs ...gtm.Attribute) {
+	s.span.SetAttributes(convert(attrs)...)
+}
+
+// End records the error and sets the status to Error if err is not nil.
+func (s *span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// convert returns the OpenTelemetry attributes, the values of unknown types are formatted as strings.
+func convert(attrs []gtm.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(a.Key, v))
+		case int:
+			kvs = append(kvs, attribute.Int(a.Key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(a.Key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(a.Key, v))
+		case float64:
+			kvs = append(kvs, attribute.Float64(a.Key, v))
+		default:
+			kvs = append(kvs, attribute.String(a.Key, fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
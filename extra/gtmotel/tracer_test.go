@@ -0,0 +1,82 @@
+package gtmotel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/extra/gtmotel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Payer is a context-aware partner starting a span of its own.
+type Payer struct {
+	tracer trace.Tracer
+	fail   bool
+}
+
+func (p *Payer) Do() (gtm.Result, error) { return p.DoContext(context.Background()) }
+func (p *Payer) DoNext() error           { return nil }
+func (p *Payer) Undo() error             { return nil }
+
+func (p *Payer) DoContext(ctx context.Context) (gtm.Result, error) {
+	_, span := p.tracer.Start(ctx, "pay")
+	defer span.End()
+
+	if p.fail {
+		return gtm.Fail, errors.New("insufficient balance")
+	}
+	return gtm.Success, nil
+}
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetTracer(gtmotel.NewTracer(provider))
+
+	tx := m.New("test-otel").AddNormal(&Payer{tracer: provider.Tracer("test"), fail: true})
+	if result, err := tx.Execute(); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+
+	execute, normal, pay := spans["gtm.Execute"], spans["gtm.do-normal"], spans["pay"]
+	if execute == nil || normal == nil || pay == nil {
+		t.Fatalf("ended spans = %v, want gtm.Execute, gtm.do-normal and pay", spans)
+	}
+	if normal.Parent().SpanID() != execute.SpanContext().SpanID() {
+		t.Errorf("parent of gtm.do-normal is not gtm.Execute")
+	}
+	if pay.Parent().SpanID() != normal.SpanContext().SpanID() {
+		t.Errorf("parent of the span of the partner is not gtm.do-normal")
+	}
+
+	want := map[attribute.Key]attribute.Value{
+		gtm.AttrTransactionID: attribute.StringValue(tx.ID),
+		gtm.AttrPhase:         attribute.StringValue(gtm.PhaseDoNormal),
+		gtm.AttrOffset:        attribute.IntValue(0),
+		gtm.AttrResult:        attribute.StringValue("fail"),
+	}
+	got := map[attribute.Key]attribute.Value{}
+	for _, kv := range normal.Attributes() {
+		got[kv.Key] = kv.Value
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("attribute %v = %v, want %v", k, got[k].Emit(), v.Emit())
+		}
+	}
+
+	if status := normal.Status(); status.Code != codes.Error || status.Description != "insufficient balance" {
+		t.Errorf("status = %v, want the error of the partner", status)
+	}
+}
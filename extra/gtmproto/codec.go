@@ -0,0 +1,108 @@
+// Package gtmproto provides a gtm.Codec encoding the partners generated by protobuf.
+//
+// A partner is a generated message with the partner methods added in the package of the generated code,
+// such as the context-aware methods in a file next to payer.pb.go:
+//
+//	package pb
+//
+//	func (p *Payer) DoContext(ctx context.Context) (gtm.Result, error) { ... }
+//	func (p *Payer) DoNextContext(ctx context.Context) error          { ... }
+//	func (p *Payer) UndoContext(ctx context.Context) error            { ... }
+//
+// The messages are encoded with protojson and named by their full names,
+// so that the persisted content is readable and compatible across the changes of the messages.
+// The other values, such as timers, are encoded by gtm.JSONValueCodec.
+package gtmproto
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/quanhengzhuang/gtm"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Name is the name of the codec saved with the content.
+const Name = "protobuf"
+
+// typePrefix distinguishes the messages from the values encoded by gtm.JSONValueCodec.
+const typePrefix = "proto:"
+
+func init() {
+	gtm.RegisterCodec(NewCodec())
+}
+
+// Codec is the codec of transactions with protobuf partners.
+type Codec struct {
+	json gtm.JSONCodec
+}
+
+var _ gtm.Codec = &Codec{}
+
+// NewCodec returns a Codec resolving the messages by protoregistry.GlobalTypes.
+func NewCodec() *Codec {
+	return &Codec{json: gtm.JSONCodec{Values: ValueCodec{}}}
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+func (c *Codec) Marshal(tx *gtm.Transaction) (string, error) {
+	return c.json.Marshal(tx)
+}
+
+func (c *Codec) Unmarshal(content string) (*gtm.Transaction, error) {
+	return c.json.Unmarshal(content)
+}
+
+// ValueCodec encodes the proto messages with protojson, and the other values with gtm.JSONValueCodec.
+type ValueCodec struct {
+	// Resolver finds the message types by their full names, protoregistry.GlobalTypes if it is nil.
+	Resolver interface {
+		FindMessageByName(protoreflect.FullName) (protoreflect.MessageType, error)
+	}
+}
+
+var _ gtm.ValueCodec = ValueCodec{}
+
+func (c ValueCodec) MarshalValue(v interface{}) (typeName string, data json.RawMessage, err error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return gtm.JSONValueCodec{}.MarshalValue(v)
+	}
+
+	typeName = typePrefix + string(m.ProtoReflect().Descriptor().FullName())
+	if data, err = protojson.Marshal(m); err != nil {
+		return "", nil, fmt.Errorf("protojson encode err: %v, %v", typeName, err)
+	}
+
+	return typeName, data, nil
+}
+
+func (c ValueCodec) UnmarshalValue(typeName string, data json.RawMessage) (interface{}, error) {
+	if len(typeName) <= len(typePrefix) || typeName[:len(typePrefix)] != typePrefix {
+		return gtm.JSONValueCodec{}.UnmarshalValue(typeName, data)
+	}
+
+	var resolver = c.Resolver
+	if resolver == nil {
+		resolver = protoregistry.GlobalTypes
+	}
+
+	fullName := protoreflect.FullName(typeName[len(typePrefix):])
+	mt, err := resolver.FindMessageByName(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("message not registered: %v, %v", fullName, err)
+	}
+
+	m := mt.New().Interface()
+	if err := protojson.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("protojson decode err: %v, %v", fullName, err)
+	}
+
+	return m, nil
+}
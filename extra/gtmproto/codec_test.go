@@ -0,0 +1,67 @@
+package gtmproto_test
+
+import (
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/extra/gtmproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Notifier struct {
+	OrderID string
+}
+
+func (n *Notifier) DoNext() error {
+	return nil
+}
+
+func init() {
+	gtm.Register(&Notifier{})
+}
+
+func TestValueCodec(t *testing.T) {
+	c := gtmproto.ValueCodec{}
+
+	typeName, data, err := c.MarshalValue(wrapperspb.String("100001"))
+	if err != nil {
+		t.Fatalf("MarshalValue() err = %v", err)
+	}
+	if typeName != "proto:google.protobuf.StringValue" || string(data) != `"100001"` {
+		t.Errorf("MarshalValue() = %v, %s, want the full name and protojson", typeName, data)
+	}
+
+	v, err := c.UnmarshalValue(typeName, data)
+	if err != nil {
+		t.Fatalf("UnmarshalValue() err = %v", err)
+	}
+	if !proto.Equal(v.(proto.Message), wrapperspb.String("100001")) {
+		t.Errorf("UnmarshalValue() = %v, want 100001", v)
+	}
+
+	if _, err := c.UnmarshalValue("proto:gtm.Unknown", data); err == nil {
+		t.Errorf("UnmarshalValue() of unknown message returns no error")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	c, err := gtm.CodecByName(gtmproto.Name)
+	if err != nil {
+		t.Fatalf("CodecByName() err = %v", err)
+	}
+
+	tx := gtm.New("test-proto").AddAsync(&Notifier{OrderID: "100001"})
+	content, err := c.Marshal(tx)
+	if err != nil {
+		t.Fatalf("Marshal() err = %v", err)
+	}
+
+	got, err := c.Unmarshal(content)
+	if err != nil {
+		t.Fatalf("Unmarshal() err = %v", err)
+	}
+	if n := got.AsyncPartners[0].(*Notifier); n.OrderID != "100001" {
+		t.Errorf("Unmarshal() partner = %+v, want the order id 100001", n)
+	}
+}
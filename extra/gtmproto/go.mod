@@ -0,0 +1,8 @@
+module github.com/quanhengzhuang/gtm/extra/gtmproto
+
+go 1.13
+
+require (
+	github.com/quanhengzhuang/gtm v0.1.0
+	google.golang.org/protobuf v1.34.2
+)
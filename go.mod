@@ -1,8 +1,9 @@
 module github.com/quanhengzhuang/gtm
 
-go 1.13
+go 1.14
 
 require (
 	github.com/jinzhu/gorm v1.9.14
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/syndtr/goleveldb v1.0.0
 )
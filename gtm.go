@@ -1,7 +1,11 @@
 package gtm
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -14,12 +18,38 @@ type Transaction struct {
 	RetryAt time.Time
 	Timeout time.Duration
 
+	// Retry limits of the transaction, the limits of the manager are used if they are 0.
+	// The transaction is dead when it is executed more than MaxAttempts times,
+	// or when it is retried MaxAge after CreatedAt, or after RequeuedAt if it is requeued.
+	MaxAttempts int
+	MaxAge      time.Duration
+	CreatedAt   time.Time
+	RequeuedAt  time.Time
+
+	// Timer of the transaction, the timer of the manager is used if it is nil.
+	// It is saved with the transaction, so a custom timer must be registered like the partners.
+	Timer Timer
+
 	NormalPartners   []NormalPartner
 	UncertainPartner UncertainPartner
 	CertainPartners  []CertainPartner
 	AsyncPartners    []CertainPartner
 
-	startAt time.Time
+	// Dependencies between NormalPartners, used by GraphDoer.
+	// The key is the offset of a partner in NormalPartners,
+	// the values are the offsets of the partners it depends on, which must be smaller.
+	Dependencies map[int][]int
+
+	// Versions of the partners when the transaction is saved, by the registered names.
+	// The partners of version 1 are omitted. See RegisterUpgrade.
+	Versions map[string]int
+
+	startAt   time.Time
+	retrying  bool
+	ctx       context.Context
+	manager   *Manager
+	results   *resultCache
+	listeners []Listener
 }
 
 type Result string
@@ -29,35 +59,42 @@ const (
 	Success   Result = "success"
 	Fail      Result = "fail"
 	Uncertain Result = "uncertain"
-)
-
-var (
-	// Default storage is nil, must be set when first used.
-	// SetStorage() to switch default storage.
-	defaultStorage Storage = nil
-
-	// SetTimer() to switch default timer.
-	defaultTimer Timer = &DoubleTimer{}
 
-	// SetDoer() to switch default doer.
-	defaultDoer Doer = &SequenceDoer{}
-
-	// Default timeout of a transaction is 60 seconds.
-	// The transaction will be retried after the first timeout.
-	// Call tx.SetTimeout() to change.
-	defaultTimeout = 60 * time.Second
+	// Use for Transaction only.
+	// The transaction exceeded its retry limits and will not be retried until requeued.
+	Dead Result = "dead"
+	// The transaction is not retried until it is resumed or forced by an operator.
+	Suspended Result = "suspended"
 )
 
-// New returns an empty GTM transaction.
+// New returns an empty GTM transaction bound to the default manager.
 func New(name string) *Transaction {
-	return &Transaction{Name: name}
+	return defaultManager.New(name)
 }
 
-// SetStorage is used to set the default storage engine.
-// The setting is effective for all transactions.
+// SetStorage is used to set the storage engine of the default manager.
+// The setting is effective for all transactions of the default manager.
 // The initial value of the storage is nil and must be set.
 func SetStorage(s Storage) {
-	defaultStorage = s
+	defaultManager.SetStorage(s)
+}
+
+// SetTimer is used to switch the timer of the default manager.
+// The initial value is a DoubleTimer.
+func SetTimer(t Timer) {
+	defaultManager.SetTimer(t)
+}
+
+// SetDoer is used to switch the doer of the default manager.
+// The initial value is a SequenceDoer.
+func SetDoer(d Doer) {
+	defaultManager.SetDoer(d)
+}
+
+// SetDefaultTimeout is used to change the default timeout of the default manager.
+// The initial value is 60 seconds.
+func SetDefaultTimeout(timeout time.Duration) {
+	defaultManager.SetTimeout(timeout)
 }
 
 func (tx *Transaction) SetName(name string) *Transaction {
@@ -70,32 +107,58 @@ func (tx *Transaction) SetTimeout(timeout time.Duration) *Transaction {
 	return tx
 }
 
-func (tx *Transaction) storage() Storage {
-	if defaultStorage == nil {
-		panic("gtm: default storage is nil")
+// SetRetryLimit sets the retry limits of the transaction, 0 for the limits of the manager.
+func (tx *Transaction) SetRetryLimit(maxAttempts int, maxAge time.Duration) *Transaction {
+	tx.MaxAttempts = maxAttempts
+	tx.MaxAge = maxAge
+	return tx
+}
+
+// SetTimer sets the timer used to calculate the retry time of the transaction,
+// instead of the timer of the manager.
+func (tx *Transaction) SetTimer(t Timer) *Transaction {
+	tx.Timer = t
+	return tx
+}
+
+// Context returns the context of the current execution.
+// It is never nil, the background context is returned when no context was given.
+// Storage implementations can use it for the transaction's storage calls.
+func (tx *Transaction) Context() context.Context {
+	if tx.ctx != nil {
+		return tx.ctx
+	}
+	return context.Background()
+}
+
+// Manager returns the manager which the transaction is bound to.
+func (tx *Transaction) Manager() *Manager {
+	if tx.manager != nil {
+		return tx.manager
 	}
-	return defaultStorage
+	return defaultManager
+}
+
+func (tx *Transaction) storage() Storage {
+	return tx.Manager().getStorage()
 }
 
 func (tx *Transaction) timer() Timer {
-	if defaultTimer == nil {
-		panic("gtm: default timer is nil")
+	if tx.Timer != nil {
+		return tx.Timer
 	}
-	return defaultTimer
+	return tx.Manager().getTimer()
 }
 
 func (tx *Transaction) doer() Doer {
-	if defaultTimer == nil {
-		panic("gtm: default doer is nil")
-	}
-	return defaultDoer
+	return tx.Manager().getDoer()
 }
 
 func (tx *Transaction) timeout() time.Duration {
 	if tx.Timeout > 0 {
 		return tx.Timeout
 	}
-	return defaultTimeout
+	return tx.Manager().timeout
 }
 
 func (tx *Transaction) AddNormal(partners ...NormalPartner) *Transaction {
@@ -103,6 +166,45 @@ func (tx *Transaction) AddNormal(partners ...NormalPartner) *Transaction {
 	return tx
 }
 
+// AddNormalAfter adds a NormalPartner which depends on the given partners.
+// GraphDoer executes its Do only after all dependencies succeeded, and its Undo before theirs.
+// The dependencies must have been added to the transaction, they are compared with ==,
+// so the partners of uncomparable types, such as structs with slices or maps, must be added as pointers.
+func (tx *Transaction) AddNormalAfter(partner NormalPartner, dependencies ...NormalPartner) *Transaction {
+	offset := len(tx.NormalPartners)
+
+	for _, dependency := range dependencies {
+		i := tx.normalOffset(dependency)
+		if i < 0 {
+			panic(fmt.Sprintf("gtm: dependency is not added or not comparable: %T", dependency))
+		}
+
+		if tx.Dependencies == nil {
+			tx.Dependencies = make(map[int][]int)
+		}
+		tx.Dependencies[offset] = append(tx.Dependencies[offset], i)
+	}
+
+	tx.NormalPartners = append(tx.NormalPartners, partner)
+	return tx
+}
+
+// normalOffset returns the offset of the partner in NormalPartners, or -1 if it is not added.
+// The partners of uncomparable types, such as structs with slices, can not be found, use pointers instead.
+func (tx *Transaction) normalOffset(partner NormalPartner) int {
+	t := reflect.TypeOf(partner)
+	if t == nil || !t.Comparable() {
+		return -1
+	}
+
+	for i, v := range tx.NormalPartners {
+		if reflect.TypeOf(v) == t && v == partner {
+			return i
+		}
+	}
+	return -1
+}
+
 func (tx *Transaction) AddUncertain(partner UncertainPartner) *Transaction {
 	tx.UncertainPartner = partner
 	return tx
@@ -130,40 +232,93 @@ func (tx *Transaction) AddPartners(normalPartners []NormalPartner, uncertainPart
 // ExecuteAsync save the transaction only and will return immediately.
 // The transaction will be executed asynchronously in the background.
 func (tx *Transaction) ExecuteAsync() (err error) {
+	return tx.ExecuteAsyncContext(context.Background())
+}
+
+// ExecuteAsyncContext is like ExecuteAsync but with a context.
+// The context is only used for saving, it is not kept for the background execution.
+func (tx *Transaction) ExecuteAsyncContext(ctx context.Context) (err error) {
+	end := tx.traceExecution(ctx, "gtm.ExecuteAsync")
+	defer func() { end("", err) }()
+
 	tx.RetryAt = time.Now()
+	tx.CreatedAt = tx.RetryAt
 	tx.Timeout = tx.timeout()
-	if _, err := tx.storage().SaveTransaction(tx); err != nil {
+	tx.setVersions()
+	var id string
+	if err = tx.callStorage("SaveTransaction", func(tx *Transaction) (err error) {
+		id, err = tx.storage().SaveTransaction(tx)
+		return err
+	}); err != nil {
 		return fmt.Errorf("save transaction failed: %v", err)
 	}
+	tx.ID = id
+	tx.notify(func(l Listener) { l.OnSaved(tx) })
 
 	return nil
 }
 
-// RetryTimeoutTransactions retry to complete timeout transactions.
+// RetryTimeoutTransactions retry to complete timeout transactions of the default manager.
 // Count is used to set the total number of transactions per retry.
 // Returns the total number of actual retries, and retry errors.
 func RetryTimeoutTransactions(count int) (transactions []*Transaction, results []Result, errs []error, err error) {
-	transactions, err = defaultStorage.GetTimeoutTransactions(count)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("get timeout transactions err: %v", err)
-	}
+	return defaultManager.RetryTimeoutTransactions(count)
+}
 
-	for _, tx := range transactions {
-		result, err := tx.ExecuteRetry()
-		errs = append(errs, err)
-		results = append(results, result)
-	}
+// RetryTimeoutTransactionsContext is like RetryTimeoutTransactions but with a context.
+func RetryTimeoutTransactionsContext(ctx context.Context, count int) (transactions []*Transaction, results []Result, errs []error, err error) {
+	return defaultManager.RetryTimeoutTransactionsContext(ctx, count)
+}
+
+// DeadTransactions returns at most count dead transactions of the default manager.
+func DeadTransactions(count int) ([]*Transaction, error) {
+	return defaultManager.DeadTransactions(count)
+}
 
-	return transactions, results, errs, nil
+// Requeue resets a dead transaction of the default manager so that it is retried again.
+func Requeue(id string) error {
+	return defaultManager.Requeue(id)
+}
+
+// Cancel marks a transaction of the default manager to be rolled back by its next execution.
+func Cancel(id string) error {
+	return defaultManager.Cancel(id)
+}
+
+// Retry executes an unfinished or dead transaction of the default manager immediately.
+func Retry(ctx context.Context, id string) (Result, error) {
+	return defaultManager.Retry(ctx, id)
 }
 
 // ExecuteRetry use to complete the transaction.
 func (tx *Transaction) ExecuteRetry() (result Result, err error) {
+	return tx.ExecuteRetryContext(context.Background())
+}
+
+// ExecuteRetryContext is like ExecuteRetry but with a context.
+func (tx *Transaction) ExecuteRetryContext(ctx context.Context) (result Result, err error) {
+	end := tx.traceExecution(ctx, "gtm.ExecuteRetry")
+	defer func() { end(result, err) }()
+
+	tx.retrying = true
 	tx.Times++
+	if err := tx.exceedLimit(); err != nil {
+		return tx.die(err)
+	}
+
 	retryTime := tx.timer().CalcRetryTime(tx.Times, tx.timeout())
-	if err := tx.storage().UpdateTransactionRetryTime(tx, tx.Times, retryTime); err != nil {
+	if err := tx.callStorage("UpdateTransactionRetryTime", func(tx *Transaction) error {
+		return tx.storage().UpdateTransactionRetryTime(tx, tx.Times, retryTime)
+	}); err != nil {
 		return Uncertain, fmt.Errorf("set transaction retry time err: %v", err)
 	}
+	tx.notify(func(l Listener) { l.OnRetryScheduled(tx, tx.Times, retryTime) })
+
+	// The partners are upgraded after the attempt is counted,
+	// so that a transaction failing to upgrade is retried later, and dies by its retry limits.
+	if err := tx.upgrade(); err != nil {
+		return Uncertain, fmt.Errorf("upgrade err: %v", err)
+	}
 
 	return tx.execute()
 }
@@ -175,18 +330,82 @@ func (tx *Transaction) ExecuteRetry() (result Result, err error) {
 // 2. The returned err may not be nil when results is Fail/Uncertain.
 // 3. When the result is Success/Fail, it means that the transaction has reached the final state.
 func (tx *Transaction) Execute() (result Result, err error) {
+	return tx.ExecuteContext(context.Background())
+}
+
+// ExecuteContext is like Execute but with a context.
+// The context is passed to the context-aware partners and to the storage through tx.Context().
+// If the context is done before a partner is called, the partner is skipped
+// and the transaction is left Uncertain to be completed by retry.
+func (tx *Transaction) ExecuteContext(ctx context.Context) (result Result, err error) {
+	end := tx.traceExecution(ctx, "gtm.Execute")
+	defer func() { end(result, err) }()
+
 	tx.Times = 1
 	tx.RetryAt = tx.timer().CalcRetryTime(0, tx.timeout())
+	tx.CreatedAt = time.Now()
 	tx.Timeout = tx.timeout()
-	if tx.ID, err = tx.storage().SaveTransaction(tx); err != nil {
+	tx.setVersions()
+	var id string
+	if err = tx.callStorage("SaveTransaction", func(tx *Transaction) (err error) {
+		id, err = tx.storage().SaveTransaction(tx)
+		return err
+	}); err != nil {
 		return Fail, fmt.Errorf("save transaction failed: %v", err)
 	}
+	tx.ID = id
+	tx.notify(func(l Listener) { l.OnSaved(tx) })
 
 	return tx.execute()
 }
 
+// exceedLimit returns the reason if the transaction exceeds its retry limits, otherwise nil.
+func (tx *Transaction) exceedLimit() error {
+	maxAttempts, maxAge := tx.Manager().retryLimit(tx.Name)
+	if tx.MaxAttempts > 0 {
+		maxAttempts = tx.MaxAttempts
+	}
+	if tx.MaxAge > 0 {
+		maxAge = tx.MaxAge
+	}
+
+	if maxAttempts > 0 && tx.Times > maxAttempts {
+		return fmt.Errorf("transaction exceeds max attempts: %v", maxAttempts)
+	}
+	since := tx.CreatedAt
+	if !tx.RequeuedAt.IsZero() {
+		since = tx.RequeuedAt
+	}
+	if maxAge > 0 && !since.IsZero() && time.Since(since) > maxAge {
+		return fmt.Errorf("transaction exceeds max age: %v", maxAge)
+	}
+
+	return nil
+}
+
+// die saves the transaction as Dead instead of executing it, and calls the hook of the manager.
+func (tx *Transaction) die(reason error) (result Result, err error) {
+	if err := tx.callStorage("SaveTransactionResult", func(tx *Transaction) error {
+		return tx.storage().SaveTransactionResult(tx, 0, Dead)
+	}); err != nil {
+		return Uncertain, fmt.Errorf("save dead result err: %v", err)
+	}
+	tx.notify(func(l Listener) { l.OnFinal(tx, Dead, 0) })
+
+	if fn := tx.Manager().onDead; fn != nil {
+		fn(tx, reason)
+	}
+
+	return Dead, reason
+}
+
 func (tx *Transaction) execute() (result Result, err error) {
+	defer func() {
+		tx.executed(result, err)
+	}()
+
 	tx.startAt = time.Now()
+	tx.results = newResultCache()
 
 	result, undoOffset, err := tx.do()
 
@@ -223,15 +442,90 @@ func (tx *Transaction) execute() (result Result, err error) {
 // Equivalent to the Prepare phase in 2PC.
 // The result of Do is uncertain.
 // If successful, DoNext will be executed; if it fails, Undo will be executed.
+// A canceled transaction fails before the NormalPartners and before the UncertainPartner.
 func (tx *Transaction) do() (result Result, undoOffset int, err error) {
+	if tx.canceled() {
+		return Fail, tx.doneOffset(), fmt.Errorf("transaction is canceled")
+	}
+
 	result, undoOffset, err = tx.doer().DoNormal(tx)
 	if result != Success {
 		return result, undoOffset, fmt.Errorf("doNormal failed: %v", err)
 	}
 
+	if tx.canceled() {
+		return Fail, len(tx.NormalPartners) - 1, fmt.Errorf("transaction is canceled")
+	}
+
 	return tx.doer().DoUncertain(tx)
 }
 
+// canceled reports whether the transaction is canceled and not committed yet.
+// It is only checked by retries, as a transaction can not be canceled before it is saved.
+// Errors returned by Storage are logged and ignored for the transaction to continue.
+func (tx *Transaction) canceled() bool {
+	if !tx.retrying {
+		return false
+	}
+
+	if result, err := tx.storedPartnerResult(phaseCancel, 0); err != nil || result != Fail {
+		return false
+	}
+
+	committed, err := tx.committed()
+	return err == nil && !committed
+}
+
+// committed reports whether the transaction has passed the commit point, after which it can not fail:
+// its UncertainPartner succeeded, or all its NormalPartners succeeded if it has no UncertainPartner.
+// The results are read from storage even for the first execution.
+func (tx *Transaction) committed() (bool, error) {
+	if tx.UncertainPartner != nil {
+		result, err := tx.storedPartnerResult(PhaseDoUncertain, 0)
+		return result == Success, err
+	}
+
+	for i := range tx.NormalPartners {
+		if result, err := tx.storedPartnerResult(PhaseDoNormal, i); result != Success || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// doneOffset returns the offset of the last NormalPartner whose Do may have taken effect, or -1.
+// It is the undoOffset of a transaction failed without executing the partners.
+// The partners whose Do failed are not undone, so a failed last partner is excluded.
+// A partner without result may have been executed with its result lost, if the transaction was executed before.
+// SequenceDoer stops at the first partner not succeeded, so the partners after it are never executed;
+// the other doers execute them regardless of the order, and skip the ones not executed in their Undo.
+func (tx *Transaction) doneOffset() int {
+	_, sequential := tx.doer().(*SequenceDoer)
+	attempted := tx.Times > 1
+
+	undoOffset := -1
+	for i := range tx.NormalPartners {
+		switch tx.getPartnerResult(PhaseDoNormal, i) {
+		case Success:
+			undoOffset = i
+			continue
+		case Uncertain:
+			undoOffset = i
+		case "":
+			if attempted {
+				undoOffset = i
+			}
+		}
+
+		if sequential {
+			break
+		}
+	}
+
+	return undoOffset
+}
+
 // doNext is used to supplement do.
 // Equivalent to the Commit phase in 2PC.
 // DoNext expects all results to be successful, otherwise it will try again.
@@ -251,25 +545,95 @@ func (tx *Transaction) undo(undoOffset int) error {
 func (tx *Transaction) saveResult(result Result) error {
 	cost := time.Since(tx.startAt)
 
-	if err := tx.storage().SaveTransactionResult(tx, cost, Fail); err != nil {
-		return fmt.Errorf("save transaction result failed: %v, %v, %v", err, cost, Fail)
+	if err := tx.callStorage("SaveTransactionResult", func(tx *Transaction) error {
+		return tx.storage().SaveTransactionResult(tx, cost, result)
+	}); err != nil {
+		return fmt.Errorf("save transaction result failed: %v, %v, %v", err, cost, result)
+	}
+	tx.notify(func(l Listener) { l.OnFinal(tx, result, cost) })
+
+	return nil
+}
+
+// savePartnerResult saves the execution result of the partner at a phase,
+// and keeps it in memory for the rest of the current execution.
+func (tx *Transaction) savePartnerResult(phase string, offset int, cost time.Duration, result Result) error {
+	if err := tx.callStorage("SavePartnerResult", func(tx *Transaction) error {
+		return tx.storage().SavePartnerResult(tx, phase, offset, cost, result)
+	}); err != nil {
+		return err
 	}
 
+	tx.results.set(phase, offset, result)
 	return nil
 }
 
 // getPartnerResult returns the execution result of the partner at each phase.
+// Results saved by the current execution are returned from memory.
 // The transaction will not call storage for the first time to improve performance.
-// Errors returned by Storage will be ignored for the transaction to continue.
+// Errors returned by Storage are logged and ignored for the transaction to continue.
 func (tx *Transaction) getPartnerResult(phase string, offset int) (result Result) {
+	if result = tx.results.get(phase, offset); result != "" {
+		return result
+	}
+
 	if tx.Times <= 1 {
 		return ""
 	}
 
-	var err error
-	if result, err = tx.storage().GetPartnerResult(tx, phase, offset); err != nil {
+	if err := tx.callStorage("GetPartnerResult", func(tx *Transaction) (err error) {
+		result, err = tx.storage().GetPartnerResult(tx, phase, offset)
+		return err
+	}); err != nil {
 		return ""
 	}
 
 	return result
 }
+
+// storedPartnerResult is like getPartnerResult, but reads storage for the first execution too,
+// and returns the errors of storage.
+func (tx *Transaction) storedPartnerResult(phase string, offset int) (Result, error) {
+	if result := tx.results.get(phase, offset); result != "" {
+		return result, nil
+	}
+
+	var result Result
+	err := tx.callStorage("GetPartnerResult", func(tx *Transaction) (err error) {
+		result, err = tx.storage().GetPartnerResult(tx, phase, offset)
+		return err
+	})
+
+	return result, err
+}
+
+// resultCache keeps the partner results saved by the current execution.
+// It is safe for concurrent use by the doers.
+type resultCache struct {
+	mu      sync.Mutex
+	results map[string]Result
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{results: make(map[string]Result)}
+}
+
+func (c *resultCache) set(phase string, offset int, result Result) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[phase+"/"+strconv.Itoa(offset)] = result
+}
+
+func (c *resultCache) get(phase string, offset int) Result {
+	if c == nil {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results[phase+"/"+strconv.Itoa(offset)]
+}
@@ -1,25 +1,15 @@
 package gtm_test
 
 import (
+	"context"
 	"fmt"
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
-	"github.com/quanhengzhuang/gtm"
-	"log"
 	"testing"
+
+	"github.com/quanhengzhuang/gtm"
 )
 
 func init() {
-	db, err := gorm.Open("mysql", "root:root1234@/gtm?charset=utf8&parseTime=True&loc=Local")
-	if err != nil {
-		log.Fatalf("db open failed: %v", err)
-	}
-	db.LogMode(true)
-
-	s := gtm.NewDBStorage(db)
-	s.Register(&Payer{}, &OrderCreator{})
-
-	gtm.SetStorage(s)
+	gtm.SetStorage(gtm.NewMemoryStorage())
 }
 
 func TestNew(t *testing.T) {
@@ -48,6 +38,42 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestExecuteContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tx := gtm.New("test-tx-canceled")
+	tx.AddNormal(&Payer{OrderID: "100002", UserID: 20001, Amount: 99})
+	tx.AddCertain(&Notifier{OrderID: "100002"})
+
+	if result, err := tx.ExecuteContext(ctx); result != gtm.Uncertain {
+		t.Errorf("result = %v, err = %v, want uncertain", result, err)
+	}
+}
+
+type operatorKey struct{}
+
+func TestNormalPartnerContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), operatorKey{}, "alice")
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	committed := &Locker{Key: operatorKey{}}
+	if result, err := m.New("test-normal-context").AddNormal(committed).ExecuteContext(ctx); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if values := committed.Values(); fmt.Sprint(values) != "[do alice doNext alice]" {
+		t.Errorf("committed partner calls = %q, want do and doNext with the context", values)
+	}
+
+	undone := &Locker{Key: operatorKey{}}
+	if result, err := m.New("test-normal-context").AddNormal(undone).AddUncertain(&Counter{Result: gtm.Fail}).ExecuteContext(ctx); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+	if values := undone.Values(); fmt.Sprint(values) != "[do alice undo alice]" {
+		t.Errorf("undone partner calls = %q, want do and undo with the context", values)
+	}
+}
+
 func TestAsync(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		tx := gtm.New("test-tx-async")
@@ -73,6 +99,12 @@ func TestRetry(t *testing.T) {
 }
 
 func ExampleRetryTimeoutTransactions() {
+	for i := 0; i < 10; i++ {
+		tx := gtm.New("example-tx-async")
+		tx.AddNormal(&Payer{OrderID: "100001", UserID: 20001, Amount: 99})
+		tx.ExecuteAsync()
+	}
+
 	transactions, results, errs, err := gtm.RetryTimeoutTransactions(10)
 
 	fmt.Println(len(transactions), len(results), len(errs), err)
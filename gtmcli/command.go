@@ -0,0 +1,471 @@
+// Package gtmcli implements the gtm command line tool, which inspects and operates the transactions in a storage.
+//
+// The commands list and stats read only the names and the states of the transactions, by gtm.SummaryStorage.
+// The other commands decode the partners by their registered types, so they fail on the transactions
+// with partners not registered in the tool. Build the tool with the packages registering your partners,
+// and the drivers of your databases:
+//
+//	package main
+//
+//	import (
+//		"os"
+//
+//		_ "github.com/jinzhu/gorm/dialects/mysql"
+//		"github.com/quanhengzhuang/gtm/gtmcli"
+//
+//		_ "example.com/app/partners"
+//	)
+//
+//	func main() {
+//		os.Exit(gtmcli.Main(os.Args[1:]))
+//	}
+//
+// The command github.com/quanhengzhuang/gtm/cmd/gtm is built with the drivers of MySQL, PostgreSQL and SQLite only.
+package gtmcli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/leveldbstorage"
+)
+
+// Usage is the usage of the gtm command.
+const Usage = `usage: gtm [-driver mysql|postgres|sqlite3] -dsn DSN <command> [arguments]
+       gtm -leveldb PATH [-keep-finished] <command> [arguments]
+
+The storage is DBStorage of the driver and DSN, or leveldbstorage at the path.
+-codec sets the codec of the transactions saved by the commands, gob by default.
+The commands except list and stats decode the partners, which must be registered in the build of the command.
+
+The commands are:
+  list [-name NAME] [-result RESULTS] [-created_after TIME] [-created_before TIME] [-after_id ID] [-count 100]
+        list the transactions, RESULTS are comma separated, "unfinished" for no result
+  show <id>
+        show the transaction with its partners, the results of the partners and the resolutions
+  retry <id>
+        retry the unfinished or dead transaction now
+  retry-due [-count 100]
+        retry the transactions due to retry
+  resolve [-operator NAME] [-reason TEXT] <id> success|fail
+        force the suspended or dead transaction to success or fail
+  purge -before TIME|DURATION [-result success,fail] [-count 1000]
+        delete the transactions finished before the time, or longer ago than the duration
+  stats
+        count the transactions by name and result, and the transactions due to retry
+
+The times are in RFC 3339, such as 2006-01-02T15:04:05Z.
+`
+
+// usageError is an error of the arguments, for which the usage is printed.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{err: fmt.Errorf(format, args...)}
+}
+
+// Main runs the gtm command with the arguments, without the program name,
+// and returns the exit code: 0 on success, 1 if the command failed, and 2 if the arguments are invalid.
+func Main(args []string) int {
+	return run(args, os.Stdout, os.Stderr)
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gtm", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	var (
+		driver       = fs.String("driver", "mysql", "the driver of gorm")
+		dsn          = fs.String("dsn", "", "the DSN of the database")
+		path         = fs.String("leveldb", "", "the path of the LevelDB")
+		keepFinished = fs.Bool("keep-finished", false, "keep the finished transactions in the LevelDB")
+		codec        = fs.String("codec", "", "the codec of the saved transactions")
+	)
+
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		fmt.Fprint(stdout, Usage)
+		return 0
+	} else if err != nil {
+		return usage(stderr, &usageError{err: err})
+	}
+
+	s, closeStorage, err := open(*driver, *dsn, *path, *keepFinished, *codec)
+	if err != nil {
+		return usage(stderr, err)
+	}
+	defer closeStorage()
+
+	if *path != "" && !*keepFinished {
+		fmt.Fprintln(stderr, "gtm: warning: the successful and failed transactions are deleted from the LevelDB without -keep-finished, they are not shown")
+	}
+
+	if err := NewCommand(gtm.NewManager(s)).SetOutput(stdout).Run(context.Background(), fs.Args()); err != nil {
+		return usage(stderr, err)
+	}
+
+	return 0
+}
+
+// usage prints the error, and the usage if it is a usageError, and returns the exit code.
+func usage(w io.Writer, err error) int {
+	fmt.Fprintf(w, "gtm: %v\n", err)
+	if _, ok := err.(*usageError); ok {
+		fmt.Fprint(w, "\n"+Usage)
+		return 2
+	}
+
+	return 1
+}
+
+// open returns the storage of the DSN or the path, and the function closing it.
+func open(driver, dsn, path string, keepFinished bool, codecName string) (s gtm.Storage, closeStorage func() error, err error) {
+	var codec gtm.Codec
+	if codecName != "" {
+		if codec, err = gtm.CodecByName(codecName); err != nil {
+			return nil, nil, newUsageError("%v", err)
+		}
+	}
+
+	switch {
+	case dsn != "" && path != "":
+		return nil, nil, newUsageError("-dsn and -leveldb are exclusive")
+	case dsn != "":
+		db, err := gorm.Open(driver, dsn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("db open err: %v", err)
+		}
+		s := gtm.NewDBStorage(db)
+		if codec != nil {
+			s.SetCodec(codec)
+		}
+		return s, db.Close, nil
+	case path != "":
+		s, err := leveldbstorage.Open(path, &leveldbstorage.Options{KeepFinished: keepFinished, Codec: codec})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	default:
+		return nil, nil, newUsageError("-dsn or -leveldb is required")
+	}
+}
+
+// Command runs the commands of the gtm tool on the transactions of a manager.
+type Command struct {
+	manager *gtm.Manager
+	out     io.Writer
+}
+
+// NewCommand returns the Command of the manager, writing to the standard output.
+func NewCommand(m *gtm.Manager) *Command {
+	return &Command{manager: m, out: os.Stdout}
+}
+
+// SetOutput sets the writer of the output of the commands.
+func (c *Command) SetOutput(w io.Writer) *Command {
+	c.out = w
+	return c
+}
+
+// Run runs the command of the arguments, such as "show", "1", see Usage.
+// The command purge replaces the retention of the manager.
+func (c *Command) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("no command")
+	}
+
+	var run func(ctx context.Context, fs *flag.FlagSet, args []string) error
+	switch args[0] {
+	case "list":
+		run = c.list
+	case "show":
+		run = c.show
+	case "retry":
+		run = c.retry
+	case "retry-due":
+		run = c.retryDue
+	case "resolve":
+		run = c.resolve
+	case "purge":
+		run = c.purge
+	case "stats":
+		run = c.stats
+	default:
+		return newUsageError("unknown command: %v", args[0])
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return run(ctx, fs, args[1:])
+}
+
+// parse parses the flags of the command, and checks the number of the remaining arguments.
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return newUsageError("%v: %v", fs.Name(), err)
+	}
+	if fs.NArg() != n {
+		return newUsageError("%v: %v arguments expected, got %v", fs.Name(), n, fs.NArg())
+	}
+
+	return nil
+}
+
+func (c *Command) list(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	for _, name := range []string{"name", "result", "created_after", "created_before", "after_id"} {
+		fs.String(name, "", "")
+	}
+	fs.String("count", "100", "")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	values := map[string][]string{}
+	fs.Visit(func(f *flag.Flag) {
+		values[f.Name] = []string{f.Value.String()}
+	})
+	filter, err := gtm.ParseTransactionFilter(values)
+	if err != nil {
+		return newUsageError("list: %v", err)
+	}
+
+	s, ok := c.manager.Storage().(gtm.SummaryStorage)
+	if !ok {
+		return fmt.Errorf("storage does not implement SummaryStorage")
+	}
+
+	records, err := s.ListTransactionSummaries(filter)
+	if err != nil {
+		return fmt.Errorf("list transactions err: %v", err)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tRESULT\tTIMES\tRETRY AT\tCREATED AT\tCOST")
+	for _, r := range records {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.Transaction.ID, r.Transaction.Name, resultName(r.Result), r.Transaction.Times,
+			formatTime(r.Transaction.RetryAt), formatTime(r.Transaction.CreatedAt), r.Cost)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(records) == filter.Count {
+		fmt.Fprintf(c.out, "more: -after_id %v\n", records[len(records)-1].Transaction.ID)
+	}
+
+	return nil
+}
+
+func (c *Command) show(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	view, err := gtm.InspectTransaction(c.manager.Storage(), fs.Arg(0))
+	if err != nil {
+		return notRegistered(err)
+	}
+
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(view)
+}
+
+func (c *Command) retry(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	result, err := c.manager.Retry(ctx, fs.Arg(0))
+	fmt.Fprintf(c.out, "%v %v\n", fs.Arg(0), resultName(result))
+	return notRegistered(err)
+}
+
+func (c *Command) retryDue(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	count := fs.Int("count", 100, "")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	txs, results, errs, err := c.manager.RetryTimeoutTransactionsContext(ctx, *count)
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tRESULT\tERROR")
+	for i := range results {
+		message := ""
+		if errs[i] != nil {
+			message = errs[i].Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", txs[i].ID, txs[i].Name, resultName(results[i]), message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.out, "retried: %v\n", len(results))
+	return err
+}
+
+func (c *Command) resolve(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	operator := fs.String("operator", os.Getenv("USER"), "")
+	reason := fs.String("reason", "", "")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	ctx = gtm.WithOperator(ctx, *operator)
+	id := fs.Arg(0)
+
+	var err error
+	switch result := gtm.Result(fs.Arg(1)); result {
+	case gtm.Success:
+		err = c.manager.ForceSuccess(ctx, id, *reason)
+	case gtm.Fail:
+		err = c.manager.ForceFail(ctx, id, *reason)
+	default:
+		return newUsageError("resolve: success or fail expected, got %v", result)
+	}
+	if err != nil {
+		return notRegistered(err)
+	}
+
+	fmt.Fprintf(c.out, "%v %v\n", id, fs.Arg(1))
+	return nil
+}
+
+func (c *Command) purge(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	before := fs.String("before", "", "")
+	results := fs.String("result", "success,fail", "")
+	count := fs.Int("count", 1000, "")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *count <= 0 {
+		return newUsageError("purge: invalid count: %v", *count)
+	}
+
+	t, err := parseBefore(*before)
+	if err != nil {
+		return newUsageError("purge: %v", err)
+	}
+
+	var retention []gtm.Retention
+	for _, result := range strings.Split(*results, ",") {
+		retention = append(retention, gtm.Retention{Result: gtm.Result(result), Before: t})
+	}
+	c.manager.SetRetention(retention...)
+
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := c.manager.Purge(*count)
+		total += n
+		if err != nil {
+			fmt.Fprintf(c.out, "purged: %v\n", total)
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	fmt.Fprintf(c.out, "purged: %v\n", total)
+	return nil
+}
+
+// parseBefore parses a time in RFC 3339, or a duration before now.
+func parseBefore(before string) (time.Time, error) {
+	if before == "" {
+		return time.Time{}, errors.New("-before is required")
+	}
+
+	if t, err := time.Parse(time.RFC3339, before); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(before)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid before: %v", before)
+	}
+
+	return time.Now().Add(-d), nil
+}
+
+func (c *Command) stats(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	s, ok := c.manager.Storage().(gtm.SummaryStorage)
+	if !ok {
+		return fmt.Errorf("storage does not implement SummaryStorage")
+	}
+
+	counts, err := s.CountTransactions()
+	if err != nil {
+		return fmt.Errorf("count transactions err: %v", err)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tRESULT\tCOUNT")
+	for _, count := range counts {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", count.Name, resultName(count.Result), count.Count)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if s, ok := c.manager.Storage().(gtm.CountStorage); ok {
+		count, err := s.CountTimeoutTransactions()
+		if err != nil {
+			return fmt.Errorf("count timeout transactions err: %v", err)
+		}
+		fmt.Fprintf(c.out, "due to retry: %v\n", count)
+	}
+
+	return nil
+}
+
+// notRegistered adds the hint to build the command with the partners to the error of a type not registered.
+func notRegistered(err error) error {
+	var notRegistered *gtm.NotRegisteredError
+	if errors.As(err, &notRegistered) {
+		return fmt.Errorf("%v, build the command with the packages registering the partners, see package gtmcli", err)
+	}
+	return err
+}
+
+// resultName returns the result, or "unfinished" if it is empty.
+func resultName(result gtm.Result) string {
+	if result == "" {
+		return "unfinished"
+	}
+	return string(result)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
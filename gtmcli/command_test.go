@@ -0,0 +1,203 @@
+package gtmcli_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/gtmcli"
+	"github.com/quanhengzhuang/gtm/leveldbstorage"
+)
+
+func init() {
+	gtm.Register(&Payer{})
+}
+
+type Payer struct {
+	OrderID string
+	Result  gtm.Result
+}
+
+func (p *Payer) Do() (gtm.Result, error) {
+	if p.Result != "" {
+		return p.Result, nil
+	}
+	return gtm.Success, nil
+}
+
+func (p *Payer) DoNext() error { return nil }
+func (p *Payer) Undo() error   { return nil }
+
+// run runs the command, and returns its output.
+func run(t *testing.T, c *gtmcli.Command, args ...string) string {
+	t.Helper()
+
+	out := &bytes.Buffer{}
+	if err := c.SetOutput(out).Run(context.Background(), args); err != nil {
+		t.Fatalf("%v err = %v, output = %q", args, err, out)
+	}
+
+	return out.String()
+}
+
+// hasLine reports whether the output has a line of the fields separated by spaces.
+func hasLine(output string, fields ...string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Join(strings.Fields(line), " ") == strings.Join(fields, " ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestCommand(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+	c := gtmcli.NewCommand(m)
+
+	uncertain := &Payer{OrderID: "100001", Result: gtm.Uncertain}
+	if result, _ := m.New("order").AddNormal(&Payer{OrderID: "100001"}).AddUncertain(uncertain).Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, want uncertain", result)
+	}
+	if result, _ := m.New("refund").AddUncertain(&Payer{OrderID: "100002", Result: gtm.Uncertain}).Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, want uncertain", result)
+	}
+	if err := m.Suspend(context.Background(), "2", "check the refund"); err != nil {
+		t.Fatalf("Suspend() err = %v", err)
+	}
+
+	if out := run(t, c, "list", "-result", "unfinished"); !strings.Contains(out, "order") || strings.Contains(out, "refund") {
+		t.Errorf("list = %q, want the order only", out)
+	}
+	if out := run(t, c, "list", "-count", "1"); !strings.Contains(out, "more: -after_id 1") {
+		t.Errorf("list = %q, want more after 1", out)
+	}
+	if out := run(t, c, "show", "1"); !strings.Contains(out, `"OrderID": "100001"`) || !strings.Contains(out, `"phase": "do-normal"`) {
+		t.Errorf("show = %q, want the partner and its result", out)
+	}
+
+	uncertain.Result = gtm.Success
+	if out := run(t, c, "retry", "1"); out != "1 success\n" {
+		t.Errorf("retry = %q, want success", out)
+	}
+	if out := run(t, c, "resolve", "-operator", "alice", "-reason", "not refunded", "2", "fail"); out != "2 fail\n" {
+		t.Errorf("resolve = %q, want fail", out)
+	}
+	if out := run(t, c, "show", "2"); !strings.Contains(out, `"operator": "alice"`) {
+		t.Errorf("show = %q, want the resolution by alice", out)
+	}
+
+	out := run(t, c, "stats")
+	if !hasLine(out, "order", "success", "1") || !hasLine(out, "refund", "fail", "1") || !hasLine(out, "due", "to", "retry:", "0") {
+		t.Errorf("stats = %q, want a success order, a failed refund and no retry", out)
+	}
+
+	if out := run(t, c, "purge", "-before", "1h"); out != "purged: 0\n" {
+		t.Errorf("purge = %q, want nothing purged", out)
+	}
+	if out := run(t, c, "purge", "-before", time.Now().Add(time.Minute).Format(time.RFC3339), "-count", "1"); out != "purged: 2\n" {
+		t.Errorf("purge = %q, want 2 purged", out)
+	}
+
+	for _, args := range [][]string{{}, {"unknown"}, {"show"}, {"resolve", "1", "done"}, {"purge"}, {"list", "-count", "0"}} {
+		if err := c.Run(context.Background(), args); err == nil {
+			t.Errorf("%v returns no error", args)
+		}
+	}
+}
+
+func TestMainExitCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gtm-cli")
+	if err != nil {
+		t.Fatalf("temp dir err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := leveldbstorage.Open(dir, nil)
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	if err := gtm.NewManager(s).New("order").AddNormal(&Payer{OrderID: "100001"}).ExecuteAsync(); err != nil {
+		t.Fatalf("execute async err: %v", err)
+	}
+	s.Close()
+
+	for _, c := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-leveldb", dir, "show", "1"}, 0},
+		{[]string{"-leveldb", dir, "show", "100"}, 1},
+		{[]string{"-leveldb", dir}, 2},
+		{[]string{"stats"}, 2},
+		{[]string{"-leveldb", dir, "-dsn", "gtm.db", "stats"}, 2},
+	} {
+		if code := gtmcli.Main(c.args); code != c.code {
+			t.Errorf("Main(%v) = %v, want %v", c.args, code, c.code)
+		}
+	}
+}
+
+// removedValues encodes the partners with a name not registered, as if the partners were removed.
+type removedValues struct {
+	gtm.JSONValueCodec
+}
+
+func (removedValues) MarshalValue(v interface{}) (string, json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	return "gtmcli-test.removed", data, err
+}
+
+func TestCommandNotRegistered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gtm-cli")
+	if err != nil {
+		t.Fatalf("temp dir err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := leveldbstorage.Open(dir, &leveldbstorage.Options{Codec: &gtm.JSONCodec{Values: removedValues{}}, KeepFinished: true})
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	if err := gtm.NewManager(s).New("order").AddNormal(&Payer{OrderID: "100001"}).ExecuteAsync(); err != nil {
+		t.Fatalf("execute async err: %v", err)
+	}
+	tx := gtm.NewManager(s).New("order").AddNormal(&Payer{OrderID: "100002"})
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Success {
+		t.Fatalf("retry result = %v, err = %v, want success", result, err)
+	}
+	s.Close()
+
+	s, err = leveldbstorage.Open(dir, &leveldbstorage.Options{KeepFinished: true})
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	defer s.Close()
+	c := gtmcli.NewCommand(gtm.NewManager(s))
+
+	if out := run(t, c, "list"); !strings.Contains(out, "order") {
+		t.Errorf("list = %q, want the order", out)
+	}
+	if out := run(t, c, "stats"); !hasLine(out, "order", "unfinished", "1") {
+		t.Errorf("stats = %q, want an unfinished order", out)
+	}
+	if out := run(t, c, "purge", "-before", time.Now().Add(time.Minute).Format(time.RFC3339)); out != "purged: 1\n" {
+		t.Errorf("purge = %q, want the successful order purged", out)
+	}
+
+	for _, args := range [][]string{{"show", "1"}, {"retry", "1"}} {
+		err := c.SetOutput(ioutil.Discard).Run(context.Background(), args)
+		if err == nil || !strings.Contains(err.Error(), "build the command with the packages registering the partners") {
+			t.Errorf("%v err = %v, want the hint of the build", args, err)
+		}
+	}
+}
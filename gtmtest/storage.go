@@ -0,0 +1,841 @@
+// Package gtmtest provides utilities for testing gtm extensions,
+// such as a conformance test suite for custom storages.
+package gtmtest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func init() {
+	gtm.Register(&Partner{})
+}
+
+// Partner is the partner of the transactions saved by the suite.
+// It is registered by gtm.Register, so that it can be encoded by the built-in codecs.
+type Partner struct {
+	Name string
+}
+
+func (p *Partner) Do() (gtm.Result, error) {
+	return gtm.Success, nil
+}
+
+func (p *Partner) DoNext() error {
+	return nil
+}
+
+func (p *Partner) Undo() error {
+	return nil
+}
+
+// StorageFactory returns an empty storage for each test of the suite.
+// It should clean the storage up with t.Cleanup() if needed, which requires Go 1.14.
+type StorageFactory func(t *testing.T) gtm.Storage
+
+// RunStorageSuite verifies that a storage behaves as gtm expects.
+// Every method of gtm.Storage is exercised, including edge cases and concurrent access.
+// The optional interfaces, such as gtm.ClaimStorage, are verified if implemented, and skipped otherwise.
+func RunStorageSuite(t *testing.T, factory StorageFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s gtm.Storage)
+	}{
+		{"SaveTransaction", testSaveTransaction},
+		{"SaveTransactionConcurrently", testSaveTransactionConcurrently},
+		{"PartnerResult", testPartnerResult},
+		{"PartnerResultReplaced", testPartnerResultReplaced},
+		{"PartnerResultConcurrently", testPartnerResultConcurrently},
+		{"TimeoutTransactions", testTimeoutTransactions},
+		{"TimeoutTransactionsCount", testTimeoutTransactionsCount},
+		{"TimeoutTransactionsFinished", testTimeoutTransactionsFinished},
+		{"UpdateTransactionRetryTime", testUpdateTransactionRetryTime},
+		{"ClaimTimeoutTransactions", testClaimTimeoutTransactions},
+		{"ClaimTimeoutTransactionsConcurrently", testClaimTimeoutTransactionsConcurrently},
+		{"GetTransaction", testGetTransaction},
+		{"GetTransactionsByResult", testGetTransactionsByResult},
+		{"ResetTransaction", testResetTransaction},
+		{"UpdateTransactionContent", testUpdateTransactionContent},
+		{"Resolutions", testResolutions},
+		{"Purge", testPurge},
+		{"CountTimeoutTransactions", testCountTimeoutTransactions},
+		{"ListTransactions", testListTransactions},
+		{"ListTransactionSummaries", testListTransactionSummaries},
+		{"CountTransactions", testCountTransactions},
+		{"GetPartnerResults", testGetPartnerResults},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, factory(t))
+		})
+	}
+}
+
+// saveTransaction saves a transaction with one partner named name.
+func saveTransaction(t *testing.T, s gtm.Storage, name string, times int, retryAt time.Time) *gtm.Transaction {
+	t.Helper()
+
+	tx := &gtm.Transaction{
+		Name:           "gtmtest",
+		Times:          times,
+		RetryAt:        retryAt,
+		Timeout:        time.Minute,
+		NormalPartners: []gtm.NormalPartner{&Partner{Name: name}},
+	}
+
+	id, err := s.SaveTransaction(tx)
+	if err != nil {
+		t.Fatalf("SaveTransaction() err = %v", err)
+	}
+	if id == "" {
+		t.Fatalf("SaveTransaction() returns empty id")
+	}
+
+	tx.ID = id
+	return tx
+}
+
+// timeoutTransactions returns the IDs of the timeout transactions.
+func timeoutTransactions(t *testing.T, s gtm.Storage, count int) (ids []string, txs []*gtm.Transaction) {
+	t.Helper()
+
+	txs, err := s.GetTimeoutTransactions(count)
+	if err != nil {
+		t.Fatalf("GetTimeoutTransactions(%v) err = %v", count, err)
+	}
+
+	for _, tx := range txs {
+		ids = append(ids, tx.ID)
+	}
+
+	return ids, txs
+}
+
+func testSaveTransaction(t *testing.T, s gtm.Storage) {
+	a := saveTransaction(t, s, "a", 1, time.Now().Add(-time.Minute))
+	b := saveTransaction(t, s, "b", 1, time.Now().Add(-time.Minute))
+
+	if a.ID == b.ID {
+		t.Errorf("SaveTransaction() returns duplicate id %v", a.ID)
+	}
+}
+
+func testSaveTransactionConcurrently(t *testing.T, s gtm.Storage) {
+	const n = 20
+
+	var wg sync.WaitGroup
+	ids := make([]string, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], errs[i] = s.SaveTransaction(&gtm.Transaction{Name: "gtmtest", RetryAt: time.Now()})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i := range ids {
+		if errs[i] != nil {
+			t.Fatalf("SaveTransaction() err = %v", errs[i])
+		}
+		if seen[ids[i]] {
+			t.Errorf("SaveTransaction() returns duplicate id %v concurrently", ids[i])
+		}
+		seen[ids[i]] = true
+	}
+}
+
+func testPartnerResult(t *testing.T, s gtm.Storage) {
+	a := saveTransaction(t, s, "a", 1, time.Now().Add(time.Hour))
+	b := saveTransaction(t, s, "b", 1, time.Now().Add(time.Hour))
+
+	if result, err := s.GetPartnerResult(a, "do-normal", 0); result != "" || err != nil {
+		t.Errorf("GetPartnerResult() of unknown key = %q, %v, want empty without error", result, err)
+	}
+
+	if err := s.SavePartnerResult(a, "do-normal", 0, time.Millisecond, gtm.Fail); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+	if err := s.SavePartnerResult(a, "doNext", 0, time.Millisecond, gtm.Success); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+
+	cases := []struct {
+		tx     *gtm.Transaction
+		phase  string
+		offset int
+		want   gtm.Result
+	}{
+		{a, "do-normal", 0, gtm.Fail},
+		{a, "doNext", 0, gtm.Success},
+		{a, "do-normal", 1, ""},
+		{a, "undo", 0, ""},
+		{b, "do-normal", 0, ""},
+	}
+
+	for _, c := range cases {
+		if result, err := s.GetPartnerResult(c.tx, c.phase, c.offset); result != c.want || err != nil {
+			t.Errorf("GetPartnerResult(%v, %v, %v) = %q, %v, want %q", c.tx.ID, c.phase, c.offset, result, err, c.want)
+		}
+	}
+}
+
+func testPartnerResultReplaced(t *testing.T, s gtm.Storage) {
+	tx := saveTransaction(t, s, "a", 1, time.Now().Add(time.Hour))
+
+	for _, result := range []gtm.Result{gtm.Uncertain, gtm.Success} {
+		if err := s.SavePartnerResult(tx, "do-normal", 0, time.Millisecond, result); err != nil {
+			t.Fatalf("SavePartnerResult(%v) err = %v", result, err)
+		}
+	}
+
+	if result, err := s.GetPartnerResult(tx, "do-normal", 0); result != gtm.Success || err != nil {
+		t.Errorf("GetPartnerResult() = %q, %v, want the last saved success", result, err)
+	}
+}
+
+func testPartnerResultConcurrently(t *testing.T, s gtm.Storage) {
+	const n = 20
+	tx := saveTransaction(t, s, "a", 1, time.Now().Add(time.Hour))
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.SavePartnerResult(tx, "do-normal", i, time.Millisecond, gtm.Success)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("SavePartnerResult() err = %v", errs[i])
+		}
+		if result, err := s.GetPartnerResult(tx, "do-normal", i); result != gtm.Success || err != nil {
+			t.Errorf("GetPartnerResult(%v) = %q, %v, want success", i, result, err)
+		}
+	}
+}
+
+func testTimeoutTransactions(t *testing.T, s gtm.Storage) {
+	now := time.Now()
+	late := saveTransaction(t, s, "late", 3, now.Add(-time.Minute))
+	early := saveTransaction(t, s, "early", 2, now.Add(-time.Hour))
+	saveTransaction(t, s, "future", 1, now.Add(time.Hour))
+
+	ids, txs := timeoutTransactions(t, s, 10)
+	if want := []string{early.ID, late.ID}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("GetTimeoutTransactions() = %v, want %v in the order of retry time", ids, want)
+	}
+
+	tx := txs[0]
+	if tx.Name != early.Name || tx.Times != early.Times {
+		t.Errorf("GetTimeoutTransactions() returns name = %v, times = %v, want %v, %v", tx.Name, tx.Times, early.Name, early.Times)
+	}
+	if d := tx.RetryAt.Sub(early.RetryAt); d > time.Second || d < -time.Second {
+		t.Errorf("GetTimeoutTransactions() returns retry at %v, want %v", tx.RetryAt, early.RetryAt)
+	}
+	if len(tx.NormalPartners) != 1 {
+		t.Fatalf("GetTimeoutTransactions() returns %v partners, want 1", len(tx.NormalPartners))
+	}
+	if p, ok := tx.NormalPartners[0].(*Partner); !ok || p.Name != "early" {
+		t.Errorf("GetTimeoutTransactions() returns partner %#v, want early", tx.NormalPartners[0])
+	}
+}
+
+func testTimeoutTransactionsCount(t *testing.T, s gtm.Storage) {
+	now := time.Now()
+	var want []string
+	for i := 0; i < 5; i++ {
+		tx := saveTransaction(t, s, "a", 1, now.Add(-time.Duration(10-i)*time.Minute))
+		want = append(want, tx.ID)
+	}
+
+	if ids, _ := timeoutTransactions(t, s, 3); fmt.Sprint(ids) != fmt.Sprint(want[:3]) {
+		t.Errorf("GetTimeoutTransactions(3) = %v, want %v", ids, want[:3])
+	}
+
+	if ids, _ := timeoutTransactions(t, s, 0); len(ids) != 0 {
+		t.Errorf("GetTimeoutTransactions(0) = %v, want none", ids)
+	}
+}
+
+func testTimeoutTransactionsFinished(t *testing.T, s gtm.Storage) {
+	now := time.Now()
+	success := saveTransaction(t, s, "success", 2, now.Add(-time.Hour))
+	fail := saveTransaction(t, s, "fail", 2, now.Add(-time.Hour))
+	pending := saveTransaction(t, s, "pending", 2, now.Add(-time.Hour))
+
+	if err := s.SaveTransactionResult(success, time.Second, gtm.Success); err != nil {
+		t.Fatalf("SaveTransactionResult() err = %v", err)
+	}
+	if err := s.SaveTransactionResult(fail, time.Second, gtm.Fail); err != nil {
+		t.Fatalf("SaveTransactionResult() err = %v", err)
+	}
+
+	if ids, _ := timeoutTransactions(t, s, 10); fmt.Sprint(ids) != fmt.Sprint([]string{pending.ID}) {
+		t.Errorf("GetTimeoutTransactions() = %v, want only %v without result", ids, pending.ID)
+	}
+}
+
+func testUpdateTransactionRetryTime(t *testing.T, s gtm.Storage) {
+	now := time.Now()
+	a := saveTransaction(t, s, "a", 1, now.Add(-time.Hour))
+	b := saveTransaction(t, s, "b", 1, now.Add(-time.Minute))
+
+	if err := s.UpdateTransactionRetryTime(a, 2, now.Add(time.Hour)); err != nil {
+		t.Fatalf("UpdateTransactionRetryTime() err = %v", err)
+	}
+	if ids, _ := timeoutTransactions(t, s, 10); fmt.Sprint(ids) != fmt.Sprint([]string{b.ID}) {
+		t.Errorf("GetTimeoutTransactions() = %v, want %v after a is delayed", ids, b.ID)
+	}
+
+	if err := s.UpdateTransactionRetryTime(a, 3, now.Add(-2*time.Hour)); err != nil {
+		t.Fatalf("UpdateTransactionRetryTime() err = %v", err)
+	}
+	ids, txs := timeoutTransactions(t, s, 10)
+	if fmt.Sprint(ids) != fmt.Sprint([]string{a.ID, b.ID}) {
+		t.Fatalf("GetTimeoutTransactions() = %v, want %v, %v after a is advanced", ids, a.ID, b.ID)
+	}
+	if txs[0].Times != 3 {
+		t.Errorf("GetTimeoutTransactions() returns times = %v, want 3", txs[0].Times)
+	}
+}
+
+func claimStorage(t *testing.T, s gtm.Storage) gtm.ClaimStorage {
+	t.Helper()
+
+	c, ok := s.(gtm.ClaimStorage)
+	if !ok {
+		t.Skip("gtm.ClaimStorage is not implemented")
+	}
+
+	return c
+}
+
+func testClaimTimeoutTransactions(t *testing.T, s gtm.Storage) {
+	c := claimStorage(t, s)
+	now := time.Now()
+	a := saveTransaction(t, s, "a", 2, now.Add(-time.Hour))
+	b := saveTransaction(t, s, "b", 2, now.Add(-time.Minute))
+	saveTransaction(t, s, "future", 1, now.Add(time.Hour))
+
+	txs, err := c.ClaimTimeoutTransactions("owner-1", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("ClaimTimeoutTransactions() err = %v", err)
+	}
+	if len(txs) != 1 || txs[0].ID != a.ID {
+		t.Fatalf("ClaimTimeoutTransactions(1) = %v, want %v", txs, a.ID)
+	}
+	if p, ok := txs[0].NormalPartners[0].(*Partner); !ok || p.Name != "a" {
+		t.Errorf("ClaimTimeoutTransactions() returns partner %#v, want a", txs[0].NormalPartners[0])
+	}
+
+	if ids, _ := timeoutTransactions(t, s, 10); fmt.Sprint(ids) != fmt.Sprint([]string{b.ID}) {
+		t.Errorf("GetTimeoutTransactions() = %v, want %v without the claimed", ids, b.ID)
+	}
+
+	// An expired lease can be claimed again.
+	txs, err = c.ClaimTimeoutTransactions("owner-2", 10, -time.Second)
+	if err != nil {
+		t.Fatalf("ClaimTimeoutTransactions() err = %v", err)
+	}
+	if len(txs) != 1 || txs[0].ID != b.ID {
+		t.Fatalf("ClaimTimeoutTransactions(10) = %v, want %v", txs, b.ID)
+	}
+
+	if txs, _ := c.ClaimTimeoutTransactions("owner-3", 10, time.Hour); len(txs) != 1 || txs[0].ID != b.ID {
+		t.Errorf("ClaimTimeoutTransactions() after the lease expired = %v, want %v", txs, b.ID)
+	}
+}
+
+func testClaimTimeoutTransactionsConcurrently(t *testing.T, s gtm.Storage) {
+	c := claimStorage(t, s)
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		saveTransaction(t, s, "a", 2, time.Now().Add(-time.Hour))
+	}
+
+	var wg sync.WaitGroup
+	claimed := make([][]*gtm.Transaction, 5)
+	errs := make([]error, len(claimed))
+	for i := range claimed {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			claimed[i], errs[i] = c.ClaimTimeoutTransactions(fmt.Sprintf("owner-%v", i), 6, time.Hour)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, txs := range claimed {
+		if errs[i] != nil {
+			t.Fatalf("ClaimTimeoutTransactions() err = %v", errs[i])
+		}
+		for _, tx := range txs {
+			if seen[tx.ID] {
+				t.Errorf("transaction %v is claimed twice", tx.ID)
+			}
+			seen[tx.ID] = true
+		}
+	}
+
+	if len(seen) != n {
+		t.Errorf("claimed %v transactions, want %v", len(seen), n)
+	}
+}
+
+func queryStorage(t *testing.T, s gtm.Storage) gtm.QueryStorage {
+	t.Helper()
+
+	q, ok := s.(gtm.QueryStorage)
+	if !ok {
+		t.Skip("gtm.QueryStorage is not implemented")
+	}
+
+	return q
+}
+
+func testGetTransaction(t *testing.T, s gtm.Storage) {
+	q := queryStorage(t, s)
+	a := saveTransaction(t, s, "a", 2, time.Now().Add(time.Hour))
+	b := saveTransaction(t, s, "b", 3, time.Now().Add(time.Hour))
+	if err := s.SaveTransactionResult(b, time.Millisecond, gtm.Dead); err != nil {
+		t.Fatalf("SaveTransactionResult() err = %v", err)
+	}
+
+	cases := []struct {
+		want   *gtm.Transaction
+		result gtm.Result
+	}{
+		{a, ""},
+		{b, gtm.Dead},
+	}
+
+	for _, c := range cases {
+		tx, result, err := q.GetTransaction(c.want.ID)
+		if err != nil {
+			t.Fatalf("GetTransaction(%v) err = %v", c.want.ID, err)
+		}
+		if tx.ID != c.want.ID || tx.Times != c.want.Times || result != c.result {
+			t.Errorf("GetTransaction(%v) = %v, times = %v, result = %q, want times = %v, result = %q",
+				c.want.ID, tx.ID, tx.Times, result, c.want.Times, c.result)
+		}
+		if p, ok := tx.NormalPartners[0].(*Partner); !ok || p.Name != c.want.NormalPartners[0].(*Partner).Name {
+			t.Errorf("GetTransaction(%v) returns partner %#v", c.want.ID, tx.NormalPartners[0])
+		}
+	}
+
+	if _, _, err := q.GetTransaction("404"); err != gtm.ErrTransactionNotFound {
+		t.Errorf("GetTransaction() of unknown id err = %v, want gtm.ErrTransactionNotFound", err)
+	}
+}
+
+func testGetTransactionsByResult(t *testing.T, s gtm.Storage) {
+	q := queryStorage(t, s)
+
+	var dead []string
+	for i := 0; i < 12; i++ {
+		tx := saveTransaction(t, s, "a", 2, time.Now().Add(-time.Minute))
+		if i%3 == 0 {
+			continue
+		}
+		if err := s.SaveTransactionResult(tx, time.Millisecond, gtm.Dead); err != nil {
+			t.Fatalf("SaveTransactionResult() err = %v", err)
+		}
+		dead = append(dead, tx.ID)
+	}
+
+	txs, err := q.GetTransactionsByResult(gtm.Dead, 100)
+	if err != nil {
+		t.Fatalf("GetTransactionsByResult() err = %v", err)
+	}
+
+	var ids []string
+	for _, tx := range txs {
+		ids = append(ids, tx.ID)
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(dead) {
+		t.Errorf("GetTransactionsByResult(dead) = %v, want %v in the order of ID", ids, dead)
+	}
+
+	if txs, _ := q.GetTransactionsByResult(gtm.Dead, 3); len(txs) != 3 || txs[0].ID != dead[0] {
+		t.Errorf("GetTransactionsByResult(dead, 3) returns %v transactions, want the first 3", len(txs))
+	}
+	if txs, _ := q.GetTransactionsByResult(gtm.Success, 100); len(txs) != 0 {
+		t.Errorf("GetTransactionsByResult(success) returns %v transactions, want none", len(txs))
+	}
+}
+
+func testResetTransaction(t *testing.T, s gtm.Storage) {
+	q := queryStorage(t, s)
+	r, ok := s.(gtm.ResetStorage)
+	if !ok {
+		t.Skip("gtm.ResetStorage is not implemented")
+	}
+
+	tx := saveTransaction(t, s, "a", 5, time.Now().Add(time.Hour))
+	if err := s.SavePartnerResult(tx, "do-normal", 0, time.Millisecond, gtm.Success); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+	if err := s.SaveTransactionResult(tx, time.Millisecond, gtm.Dead); err != nil {
+		t.Fatalf("SaveTransactionResult() err = %v", err)
+	}
+
+	tx.Times = 1
+	tx.MaxAttempts = 10
+	tx.RetryAt = time.Now().Add(-time.Second)
+	if err := r.ResetTransaction(tx); err != nil {
+		t.Fatalf("ResetTransaction() err = %v", err)
+	}
+
+	got, result, err := q.GetTransaction(tx.ID)
+	if err != nil {
+		t.Fatalf("GetTransaction() err = %v", err)
+	}
+	if result != "" || got.Times != 1 || got.MaxAttempts != 10 {
+		t.Errorf("GetTransaction() after reset = result %q, times %v, max attempts %v, want the reset one", result, got.Times, got.MaxAttempts)
+	}
+
+	if ids, _ := timeoutTransactions(t, s, 10); fmt.Sprint(ids) != fmt.Sprint([]string{tx.ID}) {
+		t.Errorf("GetTimeoutTransactions() = %v, want the reset %v", ids, tx.ID)
+	}
+	if result, err := s.GetPartnerResult(tx, "do-normal", 0); result != gtm.Success || err != nil {
+		t.Errorf("GetPartnerResult() after reset = %q, %v, want the result kept", result, err)
+	}
+}
+
+func testUpdateTransactionContent(t *testing.T, s gtm.Storage) {
+	q := queryStorage(t, s)
+	u, ok := s.(gtm.UpgradeStorage)
+	if !ok {
+		t.Skip("gtm.UpgradeStorage is not implemented")
+	}
+
+	retryAt := time.Now().Add(-time.Second)
+	tx := saveTransaction(t, s, "a", 3, retryAt)
+
+	tx.Times = 1
+	tx.RetryAt = time.Now().Add(time.Hour)
+	tx.MaxAttempts = 10
+	if err := u.UpdateTransactionContent(tx); err != nil {
+		t.Fatalf("UpdateTransactionContent() err = %v", err)
+	}
+
+	got, result, err := q.GetTransaction(tx.ID)
+	if err != nil {
+		t.Fatalf("GetTransaction() err = %v", err)
+	}
+	if result != "" || got.Times != 3 || got.MaxAttempts != 10 {
+		t.Errorf("GetTransaction() after update = result %q, times %v, max attempts %v, want the content updated only", result, got.Times, got.MaxAttempts)
+	}
+	if ids, _ := timeoutTransactions(t, s, 10); fmt.Sprint(ids) != fmt.Sprint([]string{tx.ID}) {
+		t.Errorf("GetTimeoutTransactions() = %v, want %v with the retry time kept", ids, tx.ID)
+	}
+
+	tx.ID = "100000"
+	if err := u.UpdateTransactionContent(tx); err == nil {
+		t.Errorf("UpdateTransactionContent() of an unknown transaction returns no error")
+	}
+}
+
+func testResolutions(t *testing.T, s gtm.Storage) {
+	r, ok := s.(gtm.ResolutionStorage)
+	if !ok {
+		t.Skip("gtm.ResolutionStorage is not implemented")
+	}
+
+	a := saveTransaction(t, s, "a", 2, time.Now().Add(time.Hour))
+	b := saveTransaction(t, s, "b", 2, time.Now().Add(time.Hour))
+
+	now := time.Now()
+	want := []*gtm.Resolution{
+		{Action: gtm.ActionSuspend, Operator: "alice", Reason: "check the bank", CreatedAt: now},
+		{Action: gtm.ActionForceSuccess, Operator: "bob", Reason: "", CreatedAt: now.Add(time.Second)},
+	}
+	for _, resolution := range want {
+		if err := r.SaveResolution(a, resolution); err != nil {
+			t.Fatalf("SaveResolution() err = %v", err)
+		}
+	}
+
+	resolutions, err := r.GetResolutions(a.ID)
+	if err != nil {
+		t.Fatalf("GetResolutions() err = %v", err)
+	}
+	if len(resolutions) != len(want) {
+		t.Fatalf("GetResolutions() returns %v resolutions, want %v", len(resolutions), len(want))
+	}
+	for i, got := range resolutions {
+		if got.Action != want[i].Action || got.Operator != want[i].Operator || got.Reason != want[i].Reason {
+			t.Errorf("GetResolutions()[%v] = %+v, want %+v", i, got, want[i])
+		}
+		if d := got.CreatedAt.Sub(want[i].CreatedAt); d > time.Second || d < -time.Second {
+			t.Errorf("GetResolutions()[%v] created at %v, want %v", i, got.CreatedAt, want[i].CreatedAt)
+		}
+	}
+
+	if resolutions, err := r.GetResolutions(b.ID); len(resolutions) != 0 || err != nil {
+		t.Errorf("GetResolutions() of another transaction = %v, %v, want none", resolutions, err)
+	}
+}
+
+func testPurge(t *testing.T, s gtm.Storage) {
+	p, ok := s.(gtm.PurgeStorage)
+	if !ok {
+		t.Skip("gtm.PurgeStorage is not implemented")
+	}
+
+	a := saveTransaction(t, s, "a", 2, time.Now().Add(-time.Minute))
+	b := saveTransaction(t, s, "b", 2, time.Now().Add(-time.Minute))
+	c := saveTransaction(t, s, "c", 2, time.Now().Add(-time.Minute))
+	for _, tx := range []*gtm.Transaction{a, b} {
+		if err := s.SaveTransactionResult(tx, time.Millisecond, gtm.Dead); err != nil {
+			t.Fatalf("SaveTransactionResult() err = %v", err)
+		}
+	}
+	for _, tx := range []*gtm.Transaction{a, c} {
+		if err := s.SavePartnerResult(tx, "do-normal", 0, time.Millisecond, gtm.Success); err != nil {
+			t.Fatalf("SavePartnerResult() err = %v", err)
+		}
+	}
+
+	finished := func(before time.Time, count int) (ids []string) {
+		records, err := p.GetFinishedTransactions(gtm.Dead, before, count)
+		if err != nil {
+			t.Fatalf("GetFinishedTransactions() err = %v", err)
+		}
+		for _, r := range records {
+			if r.Result != gtm.Dead || r.Transaction.CreatedAt.IsZero() {
+				t.Errorf("GetFinishedTransactions() = %+v, %+v, want dead with the creation time", r, r.Transaction)
+			}
+			ids = append(ids, r.Transaction.ID)
+		}
+		return ids
+	}
+
+	if ids := finished(time.Now().Add(time.Second), 10); fmt.Sprint(ids) != fmt.Sprint([]string{a.ID, b.ID}) {
+		t.Errorf("GetFinishedTransactions() = %v, want %v", ids, []string{a.ID, b.ID})
+	}
+	if ids := finished(time.Now().Add(time.Second), 1); fmt.Sprint(ids) != fmt.Sprint([]string{a.ID}) {
+		t.Errorf("GetFinishedTransactions(count 1) = %v, want %v", ids, a.ID)
+	}
+	if ids := finished(time.Now().Add(-time.Hour), 10); len(ids) != 0 {
+		t.Errorf("GetFinishedTransactions() before an hour ago = %v, want none", ids)
+	}
+
+	// c is not finished, and b does not have the result, they are kept.
+	if err := p.DeleteTransactions(gtm.Dead, []string{a.ID, c.ID}); err != nil {
+		t.Fatalf("DeleteTransactions() err = %v", err)
+	}
+	if err := p.DeleteTransactions(gtm.Success, []string{b.ID}); err != nil {
+		t.Fatalf("DeleteTransactions() err = %v", err)
+	}
+
+	if ids := finished(time.Now().Add(time.Second), 10); fmt.Sprint(ids) != fmt.Sprint([]string{b.ID}) {
+		t.Errorf("GetFinishedTransactions() after delete = %v, want %v", ids, b.ID)
+	}
+	if result, err := s.GetPartnerResult(a, "do-normal", 0); result != "" || err != nil {
+		t.Errorf("GetPartnerResult() of the deleted = %q, %v, want none", result, err)
+	}
+	if result, err := s.GetPartnerResult(c, "do-normal", 0); result != gtm.Success || err != nil {
+		t.Errorf("GetPartnerResult() of the kept = %q, %v, want success", result, err)
+	}
+	if ids, _ := timeoutTransactions(t, s, 10); fmt.Sprint(ids) != fmt.Sprint([]string{c.ID}) {
+		t.Errorf("timeout transactions after delete = %v, want %v", ids, c.ID)
+	}
+}
+
+func testCountTimeoutTransactions(t *testing.T, s gtm.Storage) {
+	c, ok := s.(gtm.CountStorage)
+	if !ok {
+		t.Skip("gtm.CountStorage is not implemented")
+	}
+
+	saveTransaction(t, s, "a", 1, time.Now().Add(-time.Minute))
+	saveTransaction(t, s, "b", 1, time.Now().Add(time.Hour))
+	finished := saveTransaction(t, s, "c", 1, time.Now().Add(-time.Minute))
+	if err := s.SaveTransactionResult(finished, time.Millisecond, gtm.Success); err != nil {
+		t.Fatalf("SaveTransactionResult() err = %v", err)
+	}
+
+	if count, err := c.CountTimeoutTransactions(); count != 1 || err != nil {
+		t.Errorf("CountTimeoutTransactions() = %v, %v, want 1", count, err)
+	}
+}
+
+func inspectStorage(t *testing.T, s gtm.Storage) gtm.InspectStorage {
+	t.Helper()
+
+	i, ok := s.(gtm.InspectStorage)
+	if !ok {
+		t.Skip("gtm.InspectStorage is not implemented")
+	}
+
+	return i
+}
+
+// saveListed saves the transactions listed by the tests of ListTransactions, the third one is dead.
+func saveListed(t *testing.T, s gtm.Storage) (ids []string) {
+	t.Helper()
+
+	for k, name := range []string{"refund", "pay", "refund", "refund"} {
+		tx := &gtm.Transaction{Name: name, Times: 1, RetryAt: time.Now().Add(time.Minute), Timeout: time.Minute,
+			NormalPartners: []gtm.NormalPartner{&Partner{Name: name}}}
+		id, err := s.SaveTransaction(tx)
+		if err != nil {
+			t.Fatalf("SaveTransaction() err = %v", err)
+		}
+		tx.ID = id
+		ids = append(ids, id)
+
+		if k == 2 {
+			if err := s.SaveTransactionResult(tx, time.Second, gtm.Dead); err != nil {
+				t.Fatalf("SaveTransactionResult() err = %v", err)
+			}
+		}
+	}
+
+	return ids
+}
+
+// testListFilters verifies the IDs listed by list with the filters, of the transactions saved by saveListed after begin.
+func testListFilters(t *testing.T, list func(filter gtm.TransactionFilter) ([]*gtm.TransactionRecord, error), ids []string, begin time.Time) {
+	t.Helper()
+
+	for _, test := range []struct {
+		filter gtm.TransactionFilter
+		want   []string
+	}{
+		{gtm.TransactionFilter{}, ids},
+		{gtm.TransactionFilter{Count: 2}, ids[:2]},
+		{gtm.TransactionFilter{Count: 0}, ids},
+		{gtm.TransactionFilter{AfterID: ids[1]}, ids[2:]},
+		{gtm.TransactionFilter{Name: "refund"}, []string{ids[0], ids[2], ids[3]}},
+		{gtm.TransactionFilter{Name: "refund", Results: []gtm.Result{""}}, []string{ids[0], ids[3]}},
+		{gtm.TransactionFilter{Results: []gtm.Result{gtm.Dead, gtm.Success}}, []string{ids[2]}},
+		{gtm.TransactionFilter{CreatedAfter: begin, CreatedBefore: time.Now().Add(time.Second)}, ids},
+		{gtm.TransactionFilter{CreatedAfter: time.Now().Add(time.Second)}, nil},
+	} {
+		records, err := list(test.filter)
+		if err != nil {
+			t.Fatalf("list(%+v) err = %v", test.filter, err)
+		}
+
+		var got []string
+		for _, r := range records {
+			got = append(got, r.Transaction.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("list(%+v) = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func testListTransactions(t *testing.T, s gtm.Storage) {
+	i := inspectStorage(t, s)
+
+	begin := time.Now().Add(-time.Second)
+	ids := saveListed(t, s)
+	testListFilters(t, i.ListTransactions, ids, begin)
+
+	records, err := i.ListTransactions(gtm.TransactionFilter{Results: []gtm.Result{gtm.Dead}, Count: 1})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListTransactions(dead) = %v, %v, want 1 record", len(records), err)
+	}
+	if r := records[0]; r.Result != gtm.Dead || r.Transaction.Name != "refund" || r.Transaction.NormalPartners[0].(*Partner).Name != "refund" {
+		t.Errorf("record = %+v, want the dead refund with its partners", r)
+	}
+}
+
+func testListTransactionSummaries(t *testing.T, s gtm.Storage) {
+	i, ok := s.(gtm.SummaryStorage)
+	if !ok {
+		t.Skip("gtm.SummaryStorage is not implemented")
+	}
+
+	begin := time.Now().Add(-time.Second)
+	ids := saveListed(t, s)
+	testListFilters(t, i.ListTransactionSummaries, ids, begin)
+
+	records, err := i.ListTransactionSummaries(gtm.TransactionFilter{Results: []gtm.Result{gtm.Dead}, Count: 1})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("ListTransactionSummaries(dead) = %v, %v, want 1 record", len(records), err)
+	}
+	if r := records[0]; r.Result != gtm.Dead || r.Transaction.ID != ids[2] || r.Transaction.Name != "refund" || r.Transaction.Times != 1 ||
+		r.Transaction.CreatedAt.Before(begin) || r.Transaction.NormalPartners != nil {
+		t.Errorf("record = %+v, transaction = %+v, want the dead refund without its partners", r, r.Transaction)
+	}
+}
+
+func testCountTransactions(t *testing.T, s gtm.Storage) {
+	i, ok := s.(gtm.SummaryStorage)
+	if !ok {
+		t.Skip("gtm.SummaryStorage is not implemented")
+	}
+
+	saveListed(t, s)
+
+	counts, err := i.CountTransactions()
+	if err != nil {
+		t.Fatalf("CountTransactions() err = %v", err)
+	}
+
+	var got []string
+	for _, c := range counts {
+		got = append(got, fmt.Sprintf("%v/%v/%v", c.Name, c.Result, c.Count))
+	}
+	if want := []string{"pay//1", "refund//2", "refund/dead/1"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("CountTransactions() = %v, want %v", got, want)
+	}
+}
+
+func testGetPartnerResults(t *testing.T, s gtm.Storage) {
+	i := inspectStorage(t, s)
+
+	tx := saveTransaction(t, s, "a", 2, time.Now())
+	other := saveTransaction(t, s, "b", 2, time.Now())
+	for _, r := range []struct {
+		phase  string
+		offset int
+		result gtm.Result
+	}{
+		{gtm.PhaseDoNext, 0, gtm.Success},
+		{gtm.PhaseDoNormal, 1, gtm.Uncertain},
+		{gtm.PhaseDoNormal, 0, gtm.Success},
+		{gtm.PhaseDoNormal, 1, gtm.Success},
+	} {
+		if err := s.SavePartnerResult(tx, r.phase, r.offset, time.Millisecond, r.result); err != nil {
+			t.Fatalf("SavePartnerResult() err = %v", err)
+		}
+	}
+	if err := s.SavePartnerResult(other, gtm.PhaseDoNormal, 0, time.Millisecond, gtm.Fail); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+
+	records, err := i.GetPartnerResults(tx.ID)
+	if err != nil {
+		t.Fatalf("GetPartnerResults() err = %v", err)
+	}
+
+	var got []string
+	for _, r := range records {
+		got = append(got, fmt.Sprintf("%v/%v/%v", r.Phase, r.Offset, r.Result))
+	}
+	want := []string{"do-normal/0/success", "do-normal/1/success", "doNext/0/success"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("GetPartnerResults() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,866 @@
+// Package leveldbstorage provides a GTM Storage implementation using an embedded LevelDB.
+// It suits single-process services which do not want to depend on a database server.
+package leveldbstorage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	_ gtm.Storage           = &Storage{}
+	_ gtm.ClaimStorage      = &Storage{}
+	_ gtm.QueryStorage      = &Storage{}
+	_ gtm.ResetStorage      = &Storage{}
+	_ gtm.ResolutionStorage = &Storage{}
+	_ gtm.UpgradeStorage    = &Storage{}
+	_ gtm.PurgeStorage      = &Storage{}
+	_ gtm.CountStorage      = &Storage{}
+	_ gtm.InspectStorage    = &Storage{}
+	_ gtm.SummaryStorage    = &Storage{}
+)
+
+// Keys of the storage:
+//
+//	gtm-seq                                  the last transaction ID
+//	gtm-transaction-{id}                     the transaction record
+//	gtm-retry-{retry at in nanoseconds}-{id} the retry index, ordered by retry time
+//	gtm-partner-{id}-{phase}-{offset}        the partner result
+//	gtm-resolution-{id}-{seq}                the resolutions, in the order of saving
+const (
+	seqKey            = "gtm-seq"
+	transactionPrefix = "gtm-transaction-"
+	retryPrefix       = "gtm-retry-"
+	partnerPrefix     = "gtm-partner-"
+	resolutionPrefix  = "gtm-resolution-"
+)
+
+// Options of the storage.
+type Options struct {
+	// LevelDB is passed to LevelDB when opening the database, nil for default.
+	LevelDB *opt.Options
+
+	// KeepFinished keeps the records of successful and failed transactions.
+	// By default they are deleted with their partner results once finished, so that the database does not grow,
+	// but they can not be inspected afterwards. The kept records are never deleted by the storage itself,
+	// but by gtm.Manager.Purge with a retention.
+	KeepFinished bool
+
+	// Codec encodes the transactions, gtm.GobCodec if it is nil.
+	// The transactions saved by other codecs are still decoded by the registered codecs.
+	Codec gtm.Codec
+
+	// Logger logs the claims, the retry index entries without records and the transactions skipped as they can not be decoded,
+	// nil disables logging.
+	Logger gtm.Logger
+}
+
+// Storage is a GTM Storage implementation using LevelDB.
+// It is safe for concurrent use in one process.
+type Storage struct {
+	db      *leveldb.DB
+	options Options
+
+	// mu serializes the updates of transaction records.
+	mu sync.Mutex
+}
+
+// record is the stored value of a transaction.
+type record struct {
+	// Content is encoded by the codec, or raw gob if Codec is empty.
+	Content []byte
+	Codec   string
+
+	// Name is the name of the transaction, empty in the records saved by the previous versions.
+	Name       string
+	Times      int
+	RetryAt    time.Time
+	Result     gtm.Result
+	Cost       time.Duration
+	LeaseOwner string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Open opens or creates the LevelDB at path and returns a *Storage using it.
+// Options can be nil.
+func Open(path string, options *Options) (*Storage, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	db, err := leveldb.OpenFile(path, options.LevelDB)
+	if err != nil {
+		return nil, fmt.Errorf("open leveldb err: %v", err)
+	}
+
+	return New(db, options), nil
+}
+
+// New returns a *Storage using the opened LevelDB.
+// Options can be nil, and its LevelDB field is ignored.
+func New(db *leveldb.DB, options *Options) *Storage {
+	s := &Storage{db: db}
+	if options != nil {
+		s.options = *options
+	}
+
+	return s
+}
+
+// Close closes the LevelDB.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Register records the types of partners for decoding, as gtm.Register.
+func (s *Storage) Register(values ...interface{}) {
+	gtm.Register(values...)
+}
+
+// SaveTransaction saves the transaction and its retry index in one batch.
+// The ID is an auto-increment number persisted in the database.
+func (s *Storage) SaveTransaction(tx *gtm.Transaction) (id string, err error) {
+	codec, content, err := s.encode(tx)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, err := s.lastID()
+	if err != nil {
+		return "", err
+	}
+	id = strconv.FormatInt(seq+1, 10)
+
+	now := time.Now()
+	row := record{
+		Content:   content,
+		Codec:     codec,
+		Name:      tx.Name,
+		Times:     tx.Times,
+		RetryAt:   tx.RetryAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(seqKey), []byte(id))
+	batch.Put(s.retryKey(row.RetryAt, id), []byte(id))
+	if err := s.putRecord(batch, id, &row); err != nil {
+		return "", err
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return "", fmt.Errorf("db write err: %v", err)
+	}
+
+	return id, nil
+}
+
+// SaveTransactionResult saves the result of the transaction and removes its retry index.
+// Successful and failed transactions are deleted with their partner results unless KeepFinished,
+// the dead ones are always kept to be requeued.
+func (s *Storage) SaveTransactionResult(tx *gtm.Transaction, cost time.Duration, result gtm.Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, err := s.getRecord(tx.ID)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	if result != "" {
+		batch.Delete(s.retryKey(row.RetryAt, tx.ID))
+	}
+
+	if (result == gtm.Success || result == gtm.Fail) && !s.options.KeepFinished {
+		batch.Delete(s.transactionKey(tx.ID))
+		s.deletePartnerResults(batch, tx.ID)
+	} else {
+		row.Result = result
+		row.Cost = cost
+		row.UpdatedAt = time.Now()
+		if err := s.putRecord(batch, tx.ID, row); err != nil {
+			return err
+		}
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("db write err: %v", err)
+	}
+
+	return nil
+}
+
+// SavePartnerResult saves the result of a phase of partner.
+func (s *Storage) SavePartnerResult(tx *gtm.Transaction, phase string, offset int, cost time.Duration, result gtm.Result) error {
+	if err := s.db.Put(s.partnerKey(tx.ID, phase, offset), []byte(result), nil); err != nil {
+		return fmt.Errorf("db put err: %v", err)
+	}
+
+	return nil
+}
+
+// GetPartnerResult returns the result of a phase of partner, or "" if it is not saved.
+func (s *Storage) GetPartnerResult(tx *gtm.Transaction, phase string, offset int) (gtm.Result, error) {
+	value, err := s.db.Get(s.partnerKey(tx.ID, phase, offset), nil)
+	if err == leveldb.ErrNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("db get err: %v", err)
+	}
+
+	return gtm.Result(value), nil
+}
+
+// UpdateTransactionRetryTime moves the retry index of the transaction to the new retry time.
+func (s *Storage) UpdateTransactionRetryTime(tx *gtm.Transaction, times int, newRetryTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, err := s.getRecord(tx.ID)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	if row.Result == "" {
+		batch.Delete(s.retryKey(row.RetryAt, tx.ID))
+		batch.Put(s.retryKey(newRetryTime, tx.ID), []byte(tx.ID))
+	}
+
+	row.Times = times
+	row.RetryAt = newRetryTime
+	row.UpdatedAt = time.Now()
+	if err := s.putRecord(batch, tx.ID, row); err != nil {
+		return err
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("db write err: %v", err)
+	}
+
+	return nil
+}
+
+// GetTimeoutTransactions returns at most count transactions whose retry time has passed,
+// in the order of retry time.
+func (s *Storage) GetTimeoutTransactions(count int) (txs []*gtm.Transaction, err error) {
+	ids, err := s.timeoutIDs(count)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		row, err := s.getRecord(id)
+		if err == errNotFound {
+			s.log(gtm.LevelWarn, "retry index without record", "id", id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		tx, err := s.decode(id, row)
+		if err != nil {
+			s.log(gtm.LevelError, "transaction skipped", "id", id, "err", err)
+			continue
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+// CountTimeoutTransactions returns the number of the transactions to be retried, by the retry index.
+func (s *Storage) CountTimeoutTransactions() (count int, err error) {
+	iterator := s.db.NewIterator(&util.Range{
+		Start: []byte(retryPrefix),
+		Limit: s.retryKey(time.Now(), ""),
+	}, nil)
+	for iterator.Next() {
+		count++
+	}
+
+	iterator.Release()
+	if err := iterator.Error(); err != nil {
+		return 0, fmt.Errorf("iterate retry index err: %v", err)
+	}
+
+	return count, nil
+}
+
+// ClaimTimeoutTransactions claims at most count timeout transactions for the owner,
+// their retry index is moved to now + lease in one batch.
+func (s *Storage) ClaimTimeoutTransactions(owner string, count int, lease time.Duration) (txs []*gtm.Transaction, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.timeoutIDs(count)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	batch := new(leveldb.Batch)
+	for _, id := range ids {
+		row, err := s.getRecord(id)
+		if err == errNotFound {
+			s.log(gtm.LevelWarn, "retry index without record", "id", id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		batch.Delete(s.retryKey(row.RetryAt, id))
+		row.RetryAt = now.Add(lease)
+		row.LeaseOwner = owner
+		row.UpdatedAt = now
+		batch.Put(s.retryKey(row.RetryAt, id), []byte(id))
+		if err := s.putRecord(batch, id, row); err != nil {
+			return nil, err
+		}
+
+		tx, err := s.decode(id, row)
+		if err != nil {
+			s.log(gtm.LevelError, "transaction skipped", "id", id, "err", err)
+			continue
+		}
+
+		txs = append(txs, tx)
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return nil, fmt.Errorf("db write err: %v", err)
+	}
+	s.log(gtm.LevelDebug, "transactions claimed", "owner", owner, "count", len(txs), "lease", lease)
+
+	return txs, nil
+}
+
+// GetTransaction returns the transaction and its result.
+// Finished transactions are not found unless KeepFinished, except the dead ones.
+func (s *Storage) GetTransaction(id string) (*gtm.Transaction, gtm.Result, error) {
+	row, err := s.getRecord(id)
+	if err == errNotFound {
+		return nil, "", gtm.ErrTransactionNotFound
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := s.decode(id, row)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tx, row.Result, nil
+}
+
+// GetTransactionsByResult returns at most count transactions of the result, in the order of ID.
+// There is no index of results, all the records are scanned.
+func (s *Storage) GetTransactionsByResult(result gtm.Result, count int) (txs []*gtm.Transaction, err error) {
+	return s.transactionsOf(count, func(row *record) bool {
+		return row.Result == result
+	})
+}
+
+// GetFinishedTransactions returns at most count transactions of the result updated before the time without the partners,
+// in the order of ID. The records are not decoded, Name is empty for the records saved by the previous versions.
+// The successful and failed transactions are kept only if KeepFinished.
+// There is no index of results, all the records are scanned.
+func (s *Storage) GetFinishedTransactions(result gtm.Result, before time.Time, count int) ([]*gtm.TransactionRecord, error) {
+	return s.recordsOf(count, func(id string, row *record) (*gtm.Transaction, error) {
+		return &gtm.Transaction{ID: id, Name: row.Name, Times: row.Times, RetryAt: row.RetryAt, CreatedAt: row.CreatedAt}, nil
+	}, func(row *record) bool {
+		return row.Result == result && row.UpdatedAt.Before(before)
+	}, nil)
+}
+
+// GetPartnerResults returns the saved results of the partners of the transaction, in the order of phase and offset.
+// The costs and the update times are not saved.
+func (s *Storage) GetPartnerResults(id string) (records []*gtm.PartnerResultRecord, err error) {
+	prefix := partnerPrefix + id + "-"
+	iterator := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iterator.Release()
+
+	for iterator.Next() {
+		// The phases may contain "-", the offset is after the last one.
+		key := string(iterator.Key()[len(prefix):])
+		i := strings.LastIndexByte(key, '-')
+		if i < 0 {
+			continue
+		}
+		offset, err := strconv.Atoi(key[i+1:])
+		if err != nil {
+			continue
+		}
+
+		records = append(records, &gtm.PartnerResultRecord{Phase: key[:i], Offset: offset, Result: gtm.Result(iterator.Value())})
+	}
+
+	if err := iterator.Error(); err != nil {
+		return nil, fmt.Errorf("iterate partner results err: %v", err)
+	}
+
+	gtm.SortPartnerResults(records)
+	return records, nil
+}
+
+// DeleteTransactions deletes the transactions which still have the result, and their partner results, in one batch.
+func (s *Storage) DeleteTransactions(result gtm.Result, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, id := range ids {
+		row, err := s.getRecord(id)
+		if err == errNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if row.Result != result {
+			continue
+		}
+
+		batch.Delete(s.transactionKey(id))
+		s.deletePartnerResults(batch, id)
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("db write err: %v", err)
+	}
+
+	return nil
+}
+
+// transactionsOf returns at most count transactions matching, in the order of ID.
+func (s *Storage) transactionsOf(count int, match func(row *record) bool) (txs []*gtm.Transaction, err error) {
+	records, err := s.recordsOf(count, s.decode, match, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		txs = append(txs, r.Transaction)
+	}
+
+	return txs, nil
+}
+
+// recordsOf returns at most count transactions matching with their states, in the order of ID.
+// The records are matched by match before decoding by decode, then the transactions by matchTx, if they are not nil.
+// The transactions which can not be decoded are skipped and logged, so that they do not block the others.
+func (s *Storage) recordsOf(count int, decode func(id string, row *record) (*gtm.Transaction, error),
+	match func(row *record) bool, matchTx func(tx *gtm.Transaction, row *record) bool) (records []*gtm.TransactionRecord, err error) {
+	type matched struct {
+		seq    int64
+		record *gtm.TransactionRecord
+	}
+	var matches []matched
+
+	iterator := s.db.NewIterator(util.BytesPrefix([]byte(transactionPrefix)), nil)
+	for iterator.Next() {
+		var row record
+		if err := gob.NewDecoder(bytes.NewReader(iterator.Value())).Decode(&row); err != nil {
+			iterator.Release()
+			return nil, fmt.Errorf("gob decode record err: %v", err)
+		}
+		if match != nil && !match(&row) {
+			continue
+		}
+
+		id := string(iterator.Key()[len(transactionPrefix):])
+		seq, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			iterator.Release()
+			return nil, fmt.Errorf("parse id err: %v", err)
+		}
+
+		tx, err := decode(id, &row)
+		if err != nil {
+			s.log(gtm.LevelError, "transaction skipped", "id", id, "err", err)
+			continue
+		}
+		if matchTx != nil && !matchTx(tx, &row) {
+			continue
+		}
+
+		matches = append(matches, matched{seq: seq, record: &gtm.TransactionRecord{
+			Transaction: tx,
+			Result:      row.Result,
+			Cost:        row.Cost,
+			UpdatedAt:   row.UpdatedAt,
+		}})
+	}
+
+	iterator.Release()
+	if err := iterator.Error(); err != nil {
+		return nil, fmt.Errorf("iterate transactions err: %v", err)
+	}
+
+	// The keys are ordered as text, "10" is before "9".
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].seq < matches[j].seq
+	})
+
+	for _, m := range matches {
+		if len(records) >= count {
+			break
+		}
+		records = append(records, m.record)
+	}
+
+	return records, nil
+}
+
+// ListTransactions returns the transactions matching the filter, in the order of ID.
+// The successful and failed transactions are kept only if KeepFinished.
+// There is no index, all the records are scanned.
+func (s *Storage) ListTransactions(filter gtm.TransactionFilter) ([]*gtm.TransactionRecord, error) {
+	return s.recordsOf(listCount(filter), s.decode, nil, func(tx *gtm.Transaction, row *record) bool {
+		tx.CreatedAt = row.CreatedAt
+		return filter.Match(tx, row.Result)
+	})
+}
+
+// listCount returns the maximum number of the transactions listed by the filter, which lists all of them if its Count is 0.
+func listCount(filter gtm.TransactionFilter) int {
+	if filter.Count <= 0 {
+		return math.MaxInt32
+	}
+	return filter.Count
+}
+
+// ListTransactionSummaries returns the transactions matching the filter without the partners, in the order of ID.
+// The content is decoded only for the names of the records saved by the previous versions.
+func (s *Storage) ListTransactionSummaries(filter gtm.TransactionFilter) ([]*gtm.TransactionRecord, error) {
+	return s.recordsOf(listCount(filter), s.summary, nil, func(tx *gtm.Transaction, row *record) bool {
+		return filter.Match(tx, row.Result)
+	})
+}
+
+// CountTransactions returns the numbers of the transactions by name and result, in the order of name and result.
+// The successful and failed transactions are counted only if KeepFinished.
+func (s *Storage) CountTransactions() (counts []*gtm.TransactionCount, err error) {
+	records, err := s.recordsOf(math.MaxInt32, s.summary, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := map[gtm.TransactionCount]int{}
+	for _, r := range records {
+		key := gtm.TransactionCount{Name: r.Transaction.Name, Result: r.Result}
+		if i, ok := indexes[key]; ok {
+			counts[i].Count++
+			continue
+		}
+
+		indexes[key] = len(counts)
+		counts = append(counts, &gtm.TransactionCount{Name: key.Name, Result: key.Result, Count: 1})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Name == counts[j].Name {
+			return counts[i].Result < counts[j].Result
+		}
+		return counts[i].Name < counts[j].Name
+	})
+
+	return counts, nil
+}
+
+// ResetTransaction saves the transaction again, clears its result and restores its retry index.
+func (s *Storage) ResetTransaction(tx *gtm.Transaction) error {
+	codec, content, err := s.encode(tx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, err := s.getRecord(tx.ID)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	if row.Result == "" {
+		batch.Delete(s.retryKey(row.RetryAt, tx.ID))
+	}
+	batch.Put(s.retryKey(tx.RetryAt, tx.ID), []byte(tx.ID))
+
+	row.Content = content
+	row.Codec = codec
+	row.Times = tx.Times
+	row.RetryAt = tx.RetryAt
+	row.Result = ""
+	row.Cost = 0
+	row.LeaseOwner = ""
+	row.UpdatedAt = time.Now()
+	if err := s.putRecord(batch, tx.ID, row); err != nil {
+		return err
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("db write err: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateTransactionContent saves the content of the transaction again.
+func (s *Storage) UpdateTransactionContent(tx *gtm.Transaction) error {
+	codec, content, err := s.encode(tx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, err := s.getRecord(tx.ID)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	row.Content = content
+	row.Codec = codec
+	row.UpdatedAt = time.Now()
+	if err := s.putRecord(batch, tx.ID, row); err != nil {
+		return err
+	}
+
+	if err := s.db.Write(batch, nil); err != nil {
+		return fmt.Errorf("db write err: %v", err)
+	}
+
+	return nil
+}
+
+// SaveResolution saves a resolution of the transaction.
+// The resolutions are kept after the transaction is deleted.
+func (s *Storage) SaveResolution(tx *gtm.Transaction, resolution *gtm.Resolution) error {
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(resolution); err != nil {
+		return fmt.Errorf("gob encode resolution err: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resolutions, err := s.GetResolutions(tx.ID)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%v%v-%06d", resolutionPrefix, tx.ID, len(resolutions))
+	if err := s.db.Put([]byte(key), value.Bytes(), nil); err != nil {
+		return fmt.Errorf("db put err: %v", err)
+	}
+
+	return nil
+}
+
+// GetResolutions returns the resolutions of the transaction in the order of saving.
+func (s *Storage) GetResolutions(id string) (resolutions []*gtm.Resolution, err error) {
+	iterator := s.db.NewIterator(util.BytesPrefix([]byte(resolutionPrefix+id+"-")), nil)
+	defer iterator.Release()
+
+	for iterator.Next() {
+		var resolution gtm.Resolution
+		if err := gob.NewDecoder(bytes.NewReader(iterator.Value())).Decode(&resolution); err != nil {
+			return nil, fmt.Errorf("gob decode resolution err: %v", err)
+		}
+
+		resolutions = append(resolutions, &resolution)
+	}
+
+	if err := iterator.Error(); err != nil {
+		return nil, fmt.Errorf("iterate resolutions err: %v", err)
+	}
+
+	return resolutions, nil
+}
+
+// timeoutIDs returns at most count IDs of the retry index before now.
+func (s *Storage) timeoutIDs(count int) (ids []string, err error) {
+	iterator := s.db.NewIterator(&util.Range{
+		Start: []byte(retryPrefix),
+		Limit: s.retryKey(time.Now(), ""),
+	}, nil)
+	for len(ids) < count && iterator.Next() {
+		ids = append(ids, string(iterator.Value()))
+	}
+
+	iterator.Release()
+	if err := iterator.Error(); err != nil {
+		return nil, fmt.Errorf("iterate retry index err: %v", err)
+	}
+
+	return ids, nil
+}
+
+// log logs to the logger of the options if it is not nil.
+func (s *Storage) log(level gtm.Level, msg string, keyvals ...interface{}) {
+	if s.options.Logger != nil {
+		s.options.Logger.Log(level, msg, keyvals...)
+	}
+}
+
+func (s *Storage) codec() gtm.Codec {
+	if s.options.Codec != nil {
+		return s.options.Codec
+	}
+	return gtm.GobCodec{}
+}
+
+// encode returns the name of the codec and the content of the transaction.
+func (s *Storage) encode(tx *gtm.Transaction) (codec string, content []byte, err error) {
+	c := s.codec()
+
+	text, err := c.Marshal(tx)
+	if err != nil {
+		return "", nil, fmt.Errorf("%v encode err: %v", c.Name(), err)
+	}
+
+	return c.Name(), []byte(text), nil
+}
+
+// decode decodes the transaction of the record, it returns a gtm.DecodeError if it can not be decoded.
+func (s *Storage) decode(id string, row *record) (*gtm.Transaction, error) {
+	var tx *gtm.Transaction
+	if row.Codec == "" {
+		// The content of the previous versions is the Transaction encoded by gob, which GobCodec decodes too.
+		decoded, err := gtm.GobCodec{}.Unmarshal(base64.StdEncoding.EncodeToString(row.Content))
+		if err != nil {
+			return nil, gtm.NewDecodeError(id, fmt.Errorf("gob decode err: %w", err))
+		}
+		tx = decoded
+	} else {
+		c := s.codec()
+		if c.Name() != row.Codec {
+			registered, err := gtm.CodecByName(row.Codec)
+			if err != nil {
+				return nil, gtm.NewDecodeError(id, err)
+			}
+			c = registered
+		}
+
+		decoded, err := c.Unmarshal(string(row.Content))
+		if err != nil {
+			return nil, gtm.NewDecodeError(id, fmt.Errorf("%v decode err: %w", row.Codec, err))
+		}
+		tx = decoded
+	}
+
+	tx.ID = id
+	tx.Times = row.Times
+	tx.RetryAt = row.RetryAt
+
+	return tx, nil
+}
+
+// summary returns the transaction of the record without the partners.
+func (s *Storage) summary(id string, row *record) (*gtm.Transaction, error) {
+	name := row.Name
+	if name == "" {
+		tx, err := s.decode(id, row)
+		if err != nil {
+			return nil, err
+		}
+		name = tx.Name
+	}
+
+	return &gtm.Transaction{ID: id, Name: name, Times: row.Times, RetryAt: row.RetryAt, CreatedAt: row.CreatedAt}, nil
+}
+
+var errNotFound = fmt.Errorf("transaction not found")
+
+func (s *Storage) lastID() (int64, error) {
+	value, err := s.db.Get([]byte(seqKey), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("db get seq err: %v", err)
+	}
+
+	seq, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse seq err: %v", err)
+	}
+
+	return seq, nil
+}
+
+func (s *Storage) getRecord(id string) (*record, error) {
+	value, err := s.db.Get(s.transactionKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, errNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("db get transaction err: %v", err)
+	}
+
+	var row record
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&row); err != nil {
+		return nil, fmt.Errorf("gob decode record err: %v", err)
+	}
+
+	return &row, nil
+}
+
+func (s *Storage) putRecord(batch *leveldb.Batch, id string, row *record) error {
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(row); err != nil {
+		return fmt.Errorf("gob encode record err: %v", err)
+	}
+
+	batch.Put(s.transactionKey(id), value.Bytes())
+	return nil
+}
+
+func (s *Storage) deletePartnerResults(batch *leveldb.Batch, id string) {
+	iterator := s.db.NewIterator(util.BytesPrefix([]byte(partnerPrefix+id+"-")), nil)
+	defer iterator.Release()
+
+	for iterator.Next() {
+		batch.Delete(append([]byte(nil), iterator.Key()...))
+	}
+}
+
+func (s *Storage) transactionKey(id string) []byte {
+	return []byte(transactionPrefix + id)
+}
+
+// retryKey returns the key of retry index, the zero-padded time keeps the keys ordered by time.
+func (s *Storage) retryKey(retryAt time.Time, id string) []byte {
+	nano := retryAt.UnixNano()
+	if nano < 0 {
+		nano = 0
+	}
+
+	return []byte(fmt.Sprintf("%v%019d-%v", retryPrefix, nano, id))
+}
+
+func (s *Storage) partnerKey(id string, phase string, offset int) []byte {
+	return []byte(fmt.Sprintf("%v%v-%v-%v", partnerPrefix, id, phase, offset))
+}
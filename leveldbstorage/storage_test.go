@@ -0,0 +1,128 @@
+package leveldbstorage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/gtmtest"
+	"github.com/quanhengzhuang/gtm/leveldbstorage"
+)
+
+type Payer struct {
+	OrderID string
+}
+
+func (p *Payer) Do() (gtm.Result, error) { return gtm.Success, nil }
+func (p *Payer) DoNext() error           { return nil }
+func (p *Payer) Undo() error             { return nil }
+
+func open(t *testing.T, options *leveldbstorage.Options) *leveldbstorage.Storage {
+	dir, err := ioutil.TempDir("", "gtm-leveldb")
+	if err != nil {
+		t.Fatalf("temp dir err: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s, err := leveldbstorage.Open(dir, options)
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	s.Register(&Payer{})
+	return s
+}
+
+func TestStorageSuite(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		return open(t, nil)
+	})
+}
+
+func TestStorageSuiteJSON(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		return open(t, &leveldbstorage.Options{Codec: &gtm.JSONCodec{}})
+	})
+}
+
+func TestStorage(t *testing.T) {
+	s := open(t, nil)
+	m := gtm.NewManager(s)
+
+	tx := m.New("test-leveldb").AddNormal(&Payer{OrderID: "100001"})
+	if err := tx.ExecuteAsync(); err != nil {
+		t.Fatalf("execute async err: %v", err)
+	}
+
+	other := m.New("test-leveldb").AddNormal(&Payer{OrderID: "100002"})
+	if err := other.ExecuteAsync(); err != nil {
+		t.Fatalf("execute async err: %v", err)
+	}
+
+	if tx.ID == other.ID {
+		t.Fatalf("duplicate id: %v", tx.ID)
+	}
+
+	txs, results, _, err := m.RetryTimeoutTransactions(10)
+	if err != nil || len(txs) != 2 {
+		t.Fatalf("retry = %v, err = %v, want 2 transactions", len(txs), err)
+	}
+	if txs[0].ID != tx.ID || txs[1].ID != other.ID {
+		t.Errorf("retry order = %v, %v, want %v, %v", txs[0].ID, txs[1].ID, tx.ID, other.ID)
+	}
+	if txs[0].NormalPartners[0].(*Payer).OrderID != "100001" {
+		t.Errorf("decoded partner = %+v", txs[0].NormalPartners[0])
+	}
+	for _, result := range results {
+		if result != gtm.Success {
+			t.Errorf("result = %v, want success", result)
+		}
+	}
+
+	// The retried transactions wait for their next retry time.
+	if txs, _ := s.GetTimeoutTransactions(10); len(txs) != 0 {
+		t.Errorf("timeout transactions = %v, want none before retry time", len(txs))
+	}
+}
+
+func TestStorageFinished(t *testing.T) {
+	s := open(t, nil)
+
+	tx := &gtm.Transaction{Name: "test-leveldb", Times: 2, RetryAt: time.Now().Add(-time.Second)}
+	id, err := s.SaveTransaction(tx)
+	if err != nil {
+		t.Fatalf("save err: %v", err)
+	}
+	tx.ID = id
+
+	if result, err := s.GetPartnerResult(tx, "do-normal", 0); result != "" || err != nil {
+		t.Errorf("unknown partner result = %v, err = %v, want empty", result, err)
+	}
+
+	if err := s.SavePartnerResult(tx, "do-normal", 0, time.Second, gtm.Success); err != nil {
+		t.Fatalf("save partner err: %v", err)
+	}
+
+	if err := s.UpdateTransactionRetryTime(tx, 3, time.Now().Add(-time.Millisecond)); err != nil {
+		t.Fatalf("update retry time err: %v", err)
+	}
+
+	if txs, _ := s.GetTimeoutTransactions(10); len(txs) != 1 || txs[0].Times != 3 {
+		t.Fatalf("timeout transactions = %v, want 1 with times 3", txs)
+	}
+
+	if err := s.SaveTransactionResult(tx, time.Second, gtm.Success); err != nil {
+		t.Fatalf("save result err: %v", err)
+	}
+
+	if txs, _ := s.GetTimeoutTransactions(10); len(txs) != 0 {
+		t.Errorf("timeout transactions = %v, want none after finished", len(txs))
+	}
+
+	if result, _ := s.GetPartnerResult(tx, "do-normal", 0); result != "" {
+		t.Errorf("partner result = %v, want deleted", result)
+	}
+}
@@ -0,0 +1,114 @@
+package gtm
+
+import "time"
+
+// Listener observes the lifecycle of the transactions and their partners.
+// The listeners are called synchronously by the goroutine executing the transaction,
+// and concurrently by the doers executing partners in parallel, such as ParallelDoer and GraphDoer.
+// They should return quickly, and must be safe for concurrent use.
+// Embed NopListener to implement only some of the methods.
+type Listener interface {
+	// OnSaved is called after a new transaction is saved, before it is executed.
+	OnSaved(tx *Transaction)
+
+	// OnPartnerStart is called before a partner of the phase is called.
+	OnPartnerStart(tx *Transaction, phase string, offset int)
+
+	// OnPartnerResult is called after a partner of the phase returns, with the time it took.
+	// The result of DoNext and Undo is Success if they return no error, otherwise Fail.
+	OnPartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result, err error)
+
+	// OnRetryScheduled is called after the retry time of a retried transaction is saved.
+	OnRetryScheduled(tx *Transaction, times int, retryAt time.Time)
+
+	// OnFinal is called after the final result of a transaction is saved, including Dead.
+	OnFinal(tx *Transaction, result Result, cost time.Duration)
+}
+
+// ExecutionListener is an optional interface of Listener for the results returned by the executions.
+type ExecutionListener interface {
+	// OnExecuted is called after an execution of a transaction returns, with the result returned.
+	// The first execution of a successful transaction returns Success before its final result is saved.
+	OnExecuted(tx *Transaction, result Result, err error)
+}
+
+// StorageErrorListener is an optional interface of Listener for the failed operations of the storage.
+type StorageErrorListener interface {
+	// OnStorageError is called when an operation of the storage fails, named by the method of Storage.
+	// The tx is nil for the operations of the manager, such as GetTimeoutTransactions.
+	OnStorageError(tx *Transaction, operation string, err error)
+}
+
+// NopListener is a Listener doing nothing.
+type NopListener struct{}
+
+func (NopListener) OnSaved(tx *Transaction)                                  {}
+func (NopListener) OnPartnerStart(tx *Transaction, phase string, offset int) {}
+func (NopListener) OnPartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result, err error) {
+}
+func (NopListener) OnRetryScheduled(tx *Transaction, times int, retryAt time.Time) {}
+func (NopListener) OnFinal(tx *Transaction, result Result, cost time.Duration)     {}
+
+// AddListener adds listeners to the default manager.
+func AddListener(listeners ...Listener) {
+	defaultManager.AddListener(listeners...)
+}
+
+// AddListener adds listeners called for all transactions of the manager.
+func (m *Manager) AddListener(listeners ...Listener) *Manager {
+	m.listeners = append(m.listeners, listeners...)
+	return m
+}
+
+// AddListener adds listeners called for the transaction only, after the listeners of the manager.
+// They are not saved, so they are not called when the transaction is retried from the storage.
+func (tx *Transaction) AddListener(listeners ...Listener) *Transaction {
+	tx.listeners = append(tx.listeners, listeners...)
+	return tx
+}
+
+// notify calls fn with the listeners of the manager, and logs the event if the manager has a logger.
+func (m *Manager) notify(fn func(l Listener)) {
+	if m.logger != nil {
+		fn(logListener{logger: m.logger})
+	}
+	for _, l := range m.listeners {
+		fn(l)
+	}
+}
+
+// notify calls fn with the listeners of the manager and the transaction.
+func (tx *Transaction) notify(fn func(l Listener)) {
+	tx.Manager().notify(fn)
+	for _, l := range tx.listeners {
+		fn(l)
+	}
+}
+
+// executed notifies the listeners implementing ExecutionListener of the result of an execution.
+func (tx *Transaction) executed(result Result, err error) {
+	tx.notify(func(l Listener) {
+		if e, ok := l.(ExecutionListener); ok {
+			e.OnExecuted(tx, result, err)
+		}
+	})
+}
+
+// storageFailed notifies the listeners implementing StorageErrorListener of the error of a storage operation.
+func (tx *Transaction) storageFailed(operation string, err error) {
+	tx.notify(func(l Listener) {
+		if s, ok := l.(StorageErrorListener); ok {
+			s.OnStorageError(tx, operation, err)
+		}
+	})
+}
+
+// storageFailed notifies the listeners of the manager implementing StorageErrorListener
+// of the error of a storage operation not of a transaction.
+func (m *Manager) storageFailed(operation string, err error) {
+	m.notify(func(l Listener) {
+		if s, ok := l.(StorageErrorListener); ok {
+			s.OnStorageError(nil, operation, err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package gtm_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// Recorder records the events of the listener.
+type Recorder struct {
+	gtm.NopListener
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *Recorder) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *Recorder) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *Recorder) OnSaved(tx *gtm.Transaction) {
+	r.record("saved")
+}
+
+func (r *Recorder) OnPartnerStart(tx *gtm.Transaction, phase string, offset int) {
+	r.record("start %v %v", phase, offset)
+}
+
+func (r *Recorder) OnPartnerResult(tx *gtm.Transaction, phase string, offset int, cost time.Duration, result gtm.Result, err error) {
+	r.record("result %v %v %v", phase, offset, result)
+}
+
+func (r *Recorder) OnRetryScheduled(tx *gtm.Transaction, times int, retryAt time.Time) {
+	r.record("retry %v", times)
+}
+
+func (r *Recorder) OnFinal(tx *gtm.Transaction, result gtm.Result, cost time.Duration) {
+	r.record("final %v", result)
+}
+
+func TestListener(t *testing.T) {
+	managerListener, txListener := &Recorder{}, &Recorder{}
+	m := gtm.NewManager(gtm.DefaultManager().Storage()).AddListener(managerListener)
+
+	tx := m.New("test-listener").AddNormal(&Counter{Result: gtm.Success}, &Counter{Result: gtm.Fail}).AddListener(txListener)
+	if result, err := tx.Execute(); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+
+	want := []string{
+		"saved",
+		"start do-normal 0", "result do-normal 0 success",
+		"start do-normal 1", "result do-normal 1 fail",
+		"start undo 0", "result undo 0 success",
+		"final fail",
+	}
+	for _, r := range []*Recorder{managerListener, txListener} {
+		if got := r.Events(); !reflect.DeepEqual(got, want) {
+			t.Errorf("events = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestListenerDoers(t *testing.T) {
+	for _, doer := range []gtm.Doer{&gtm.SequenceDoer{}, gtm.NewParallelDoer(2), gtm.NewGraphDoer(2)} {
+		r := &Recorder{}
+		m := gtm.NewManager(gtm.DefaultManager().Storage()).SetDoer(doer).AddListener(r)
+
+		tx := m.New("test-listener-doers").AddNormal(&Counter{Result: gtm.Success}, &Counter{Result: gtm.Success})
+		if result, err := tx.Execute(); result != gtm.Success {
+			t.Fatalf("%T result = %v, err = %v, want success", doer, result, err)
+		}
+		if result, err := tx.ExecuteRetry(); result != gtm.Success {
+			t.Fatalf("%T retry result = %v, err = %v, want success", doer, result, err)
+		}
+
+		// The partners of the same phase may be executed in any order.
+		got := r.Events()
+		sort.Strings(got)
+		want := []string{
+			"final success", "result do-normal 0 success", "result do-normal 1 success",
+			"result doNext 0 success", "result doNext 1 success",
+			"retry 2", "saved",
+			"start do-normal 0", "start do-normal 1", "start doNext 0", "start doNext 1",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%T events = %q, want %q", doer, got, want)
+		}
+	}
+}
+
+// ErrorRecorder records the events of the optional interfaces of Listener.
+type ErrorRecorder struct {
+	Recorder
+}
+
+func (r *ErrorRecorder) OnExecuted(tx *gtm.Transaction, result gtm.Result, err error) {
+	r.record("executed %v", result)
+}
+
+func (r *ErrorRecorder) OnStorageError(tx *gtm.Transaction, operation string, err error) {
+	r.record("storage error %v", operation)
+}
+
+// BrokenResultStorage is a storage failing to get the partner results.
+type BrokenResultStorage struct {
+	*gtm.MemoryStorage
+}
+
+func (s BrokenResultStorage) GetPartnerResult(tx *gtm.Transaction, phase string, offset int) (gtm.Result, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestListenerOptional(t *testing.T) {
+	r, optional := &Recorder{}, &ErrorRecorder{}
+	m := gtm.NewManager(BrokenResultStorage{gtm.NewMemoryStorage()}).AddListener(r, optional)
+
+	tx := m.New("test-listener-optional").AddNormal(&Counter{Result: gtm.Success})
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	// The listeners implementing the optional interfaces are notified of the executions and the storage errors too.
+	var got []string
+	for _, event := range optional.Events() {
+		if strings.HasPrefix(event, "executed") || strings.HasPrefix(event, "storage error") {
+			got = append(got, event)
+		}
+	}
+	if len(got) == 0 || got[0] != "executed success" || got[len(got)-1] != "executed success" || !contains(got, "storage error GetPartnerResult") {
+		t.Errorf("optional events = %q, want the executions and the storage errors", got)
+	}
+	if len(r.Events())+len(got) != len(optional.Events()) {
+		t.Errorf("events of the Listener only = %q, want the events without the optional ones", r.Events())
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
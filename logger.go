@@ -0,0 +1,178 @@
+package gtm
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Level is the level of a log, the values are the same as log/slog.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Logger records the events of GTM with the fields as alternating keys and values, such as "id", tx.ID.
+// The manager logs the executions, the partner calls, the retries and the storage errors:
+// the errors are logged at LevelError, the unfinished results at LevelWarn,
+// the retries and the final results at LevelInfo, and the others at LevelDebug.
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// SetLogger sets the logger of the default manager.
+func SetLogger(l Logger) {
+	defaultManager.SetLogger(l)
+}
+
+// SetLogger sets the logger of the transactions of the manager, nil disables logging.
+func (m *Manager) SetLogger(l Logger) *Manager {
+	m.logger = l
+	return m
+}
+
+// logTo logs to the logger if it is not nil.
+func logTo(l Logger, level Level, msg string, keyvals ...interface{}) {
+	if l != nil {
+		l.Log(level, msg, keyvals...)
+	}
+}
+
+// StdLogger is a Logger writing to a log.Logger in the logfmt style, such as
+//
+//	level=INFO msg="retry scheduled" id=1 name=refund times=2
+type StdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+// NewStdLogger returns a StdLogger writing the logs of the level and above,
+// to the standard error if the logger is nil.
+func NewStdLogger(logger *log.Logger, level Level) *StdLogger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	return &StdLogger{logger: logger, level: level}
+}
+
+func (s *StdLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < s.level {
+		return
+	}
+
+	b := strings.Builder{}
+	b.WriteString("level=" + level.String() + " msg=" + formatLogValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		b.WriteString(" " + fmt.Sprint(keyvals[i]) + "=" + formatLogValue(value))
+	}
+
+	s.logger.Output(2, b.String())
+}
+
+// formatLogValue formats the value, quoted if it is empty or has spaces, quotes or equal signs.
+func formatLogValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logListener is the Listener logging the events of the transactions to the logger of the manager.
+type logListener struct {
+	logger Logger
+}
+
+var (
+	_ ExecutionListener    = logListener{}
+	_ StorageErrorListener = logListener{}
+)
+
+func (l logListener) OnSaved(tx *Transaction) {
+	l.logger.Log(LevelDebug, "transaction saved", "id", tx.ID, "name", tx.Name)
+}
+
+func (l logListener) OnPartnerStart(tx *Transaction, phase string, offset int) {
+	l.logger.Log(LevelDebug, "partner start", "id", tx.ID, "name", tx.Name, "times", tx.Times, "phase", phase, "offset", offset)
+}
+
+func (l logListener) OnPartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result, err error) {
+	level := LevelDebug
+	if result != Success {
+		level = LevelWarn
+	}
+
+	keyvals := []interface{}{"id", tx.ID, "name", tx.Name, "times", tx.Times, "phase", phase, "offset", offset, "result", result, "cost", cost}
+	if err != nil {
+		keyvals = append(keyvals, "err", err)
+	}
+	l.logger.Log(level, "partner result", keyvals...)
+}
+
+func (l logListener) OnRetryScheduled(tx *Transaction, times int, retryAt time.Time) {
+	l.logger.Log(LevelInfo, "retry scheduled", "id", tx.ID, "name", tx.Name, "times", times, "retry_at", retryAt)
+}
+
+func (l logListener) OnFinal(tx *Transaction, result Result, cost time.Duration) {
+	level := LevelInfo
+	if result == Dead {
+		level = LevelWarn
+	}
+
+	l.logger.Log(level, "transaction finished", "id", tx.ID, "name", tx.Name, "times", tx.Times, "result", result, "cost", cost)
+}
+
+func (l logListener) OnExecuted(tx *Transaction, result Result, err error) {
+	level := LevelDebug
+	if result == Uncertain {
+		level = LevelWarn
+	}
+
+	keyvals := []interface{}{"id", tx.ID, "name", tx.Name, "times", tx.Times, "result", result}
+	if err != nil {
+		keyvals = append(keyvals, "err", err)
+	}
+	l.logger.Log(level, "transaction executed", keyvals...)
+}
+
+func (l logListener) OnStorageError(tx *Transaction, operation string, err error) {
+	keyvals := []interface{}{"operation", operation, "err", err}
+	if tx != nil {
+		keyvals = append([]interface{}{"id", tx.ID, "name", tx.Name}, keyvals...)
+	}
+	l.logger.Log(LevelError, "storage failed", keyvals...)
+}
@@ -0,0 +1,28 @@
+//go:build go1.21
+// +build go1.21
+
+package gtm
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger is a Logger writing to a log/slog logger.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a SlogLogger writing to the logger, slog.Default() if it is nil.
+// Use slog.New to log to a slog.Handler.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SlogLogger{logger: logger}
+}
+
+func (s *SlogLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	s.logger.Log(context.Background(), slog.Level(level), msg, keyvals...)
+}
@@ -0,0 +1,26 @@
+//go:build go1.21
+// +build go1.21
+
+package gtm_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})
+	logger := gtm.NewSlogLogger(slog.New(handler))
+
+	logger.Log(gtm.LevelInfo, "retry scheduled", "id", "1")
+	logger.Log(gtm.LevelError, "storage failed", "operation", "SaveTransaction")
+
+	if got := buf.String(); strings.Contains(got, "retry scheduled") || !strings.Contains(got, `level=ERROR msg="storage failed" operation=SaveTransaction`) {
+		t.Errorf("log = %q, want the error only", got)
+	}
+}
@@ -0,0 +1,83 @@
+package gtm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// LogRecorder is a logger recording the logs as "LEVEL msg k=v ...".
+type LogRecorder struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (r *LogRecorder) Log(level gtm.Level, msg string, keyvals ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, fmt.Sprintf("%v %v %v", level, msg, keyvals))
+}
+
+// Find returns the logs containing all the substrings.
+func (r *LogRecorder) Find(substrings ...string) (logs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+next:
+	for _, l := range r.logs {
+		for _, s := range substrings {
+			if !strings.Contains(l, s) {
+				continue next
+			}
+		}
+		logs = append(logs, l)
+	}
+	return logs
+}
+
+func TestLogger(t *testing.T) {
+	logger := &LogRecorder{}
+	m := gtm.NewManager(BrokenResultStorage{gtm.NewMemoryStorage()}).SetLogger(logger)
+
+	tx := m.New("test-logger").AddNormal(&Counter{Result: gtm.Success})
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	// The errors of getting the partner results are ignored, the partners are executed again.
+	if result, err := tx.ExecuteRetry(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	for _, want := range [][]string{
+		{"DEBUG transaction saved", "id " + tx.ID},
+		{"INFO retry scheduled", "times 2"},
+		{"ERROR storage failed", "operation GetPartnerResult", "connection refused"},
+		{"DEBUG partner result", "times 2", "phase do-normal", "result success"},
+		{"INFO transaction finished", "result success"},
+		{"DEBUG transaction executed", "result success"},
+	} {
+		if logs := logger.Find(want...); len(logs) == 0 {
+			t.Errorf("no log contains %q, logs = %q", want, logger.logs)
+		}
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := gtm.NewStdLogger(log.New(&buf, "", 0), gtm.LevelInfo)
+
+	logger.Log(gtm.LevelDebug, "partner start", "id", "1")
+	logger.Log(gtm.LevelWarn, "partner result", "id", "1", "err", errors.New("user not found"), "phase")
+
+	want := `level=WARN msg="partner result" id=1 err="user not found" phase=!MISSING` + "\n"
+	if buf.String() != want {
+		t.Errorf("log = %q, want %q", buf.String(), want)
+	}
+}
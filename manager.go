@@ -0,0 +1,382 @@
+package gtm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Manager owns everything a transaction needs besides its partners:
+// the storage, the timer, the doer and the default timeout.
+// Transactions created by a Manager are bound to it, so several independent
+// managers can run in the same process, e.g. one per database.
+// The package-level functions use a default manager.
+// A Manager should be configured before it is used and not changed afterwards.
+type Manager struct {
+	storage Storage
+	timer   Timer
+	doer    Doer
+	timeout time.Duration
+	lease   time.Duration
+	limits  map[string]retryLimit
+	onDead  func(tx *Transaction, reason error)
+
+	retention []Retention
+	archiver  Archiver
+	listeners []Listener
+	tracer    Tracer
+	logger    Logger
+}
+
+// retryLimit is the retry limits of the transactions of a name.
+type retryLimit struct {
+	maxAttempts int
+	maxAge      time.Duration
+}
+
+// Default manager used by the package-level functions.
+var defaultManager = NewManager(nil)
+
+// NewManager returns a Manager using the storage,
+// with a DoubleTimer, a SequenceDoer and a timeout of 60 seconds.
+func NewManager(storage Storage) *Manager {
+	return &Manager{
+		storage: storage,
+		timer:   &DoubleTimer{},
+		doer:    &SequenceDoer{},
+		timeout: 60 * time.Second,
+	}
+}
+
+// DefaultManager returns the manager used by the package-level functions.
+func DefaultManager() *Manager {
+	return defaultManager
+}
+
+// SetStorage sets the storage engine of the manager.
+func (m *Manager) SetStorage(s Storage) *Manager {
+	m.storage = s
+	return m
+}
+
+// SetTimer sets the timer used to calculate the retry time.
+func (m *Manager) SetTimer(t Timer) *Manager {
+	m.timer = t
+	return m
+}
+
+// SetDoer sets the doer used to execute the partners.
+func (m *Manager) SetDoer(d Doer) *Manager {
+	m.doer = d
+	return m
+}
+
+// SetTimeout sets the default timeout of the transactions.
+// The transaction will be retried after the first timeout.
+// Call tx.SetTimeout() to change the timeout of a single transaction.
+func (m *Manager) SetTimeout(timeout time.Duration) *Manager {
+	m.timeout = timeout
+	return m
+}
+
+// SetLease sets how long the claimed timeout transactions are reserved for the retry,
+// when the storage implements ClaimStorage. It defaults to the timeout of the manager.
+// It should be longer than a retry takes, otherwise others may retry it at the same time.
+func (m *Manager) SetLease(lease time.Duration) *Manager {
+	m.lease = lease
+	return m
+}
+
+// SetRetryLimit sets the retry limits of the transactions of the name, or of all transactions if name is "".
+// A transaction is saved as Dead instead of retried when it has been executed maxAttempts times,
+// or when maxAge has passed since it was created. 0 means no limit.
+// The limits of a transaction itself take precedence.
+func (m *Manager) SetRetryLimit(name string, maxAttempts int, maxAge time.Duration) *Manager {
+	if m.limits == nil {
+		m.limits = make(map[string]retryLimit)
+	}
+	m.limits[name] = retryLimit{maxAttempts: maxAttempts, maxAge: maxAge}
+	return m
+}
+
+// OnDead sets the hook called after a transaction is saved as Dead, with the reason.
+func (m *Manager) OnDead(fn func(tx *Transaction, reason error)) *Manager {
+	m.onDead = fn
+	return m
+}
+
+// SetRetention sets how long the finished transactions are kept, by their results.
+// The transactions of the results not set are kept forever. See Purge.
+func (m *Manager) SetRetention(retention ...Retention) *Manager {
+	m.retention = retention
+	return m
+}
+
+// SetArchiver sets the archiver writing the transactions before they are purged.
+// The transactions are deleted without archiving if it is nil.
+func (m *Manager) SetArchiver(a Archiver) *Manager {
+	m.archiver = a
+	return m
+}
+
+// Storage returns the storage engine of the manager.
+func (m *Manager) Storage() Storage {
+	return m.storage
+}
+
+// New returns an empty GTM transaction bound to the manager.
+func (m *Manager) New(name string) *Transaction {
+	return &Transaction{Name: name, manager: m}
+}
+
+// RetryTimeoutTransactions retry to complete timeout transactions of the manager.
+// Count is used to set the total number of transactions per retry.
+// Returns the total number of actual retries, and retry errors.
+func (m *Manager) RetryTimeoutTransactions(count int) (transactions []*Transaction, results []Result, errs []error, err error) {
+	return m.RetryTimeoutTransactionsContext(context.Background(), count)
+}
+
+// RetryTimeoutTransactionsContext is like RetryTimeoutTransactions but with a context.
+// The context is passed to every retried transaction.
+// When the context is done, the remaining transactions are not retried and the context's error is returned.
+func (m *Manager) RetryTimeoutTransactionsContext(ctx context.Context, count int) (transactions []*Transaction, results []Result, errs []error, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	transactions, err = m.timeoutTransactions(count)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for k, tx := range transactions {
+		if err := ctx.Err(); err != nil {
+			return transactions[:k], results, errs, err
+		}
+
+		result, err := m.retry(ctx, tx)
+		errs = append(errs, err)
+		results = append(results, result)
+	}
+
+	return transactions, results, errs, nil
+}
+
+// DeadTransactions returns at most count dead transactions, it requires the storage to implement QueryStorage.
+func (m *Manager) DeadTransactions(count int) ([]*Transaction, error) {
+	s, ok := m.getStorage().(QueryStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not implement QueryStorage")
+	}
+
+	transactions, err := s.GetTransactionsByResult(Dead, count)
+	if err != nil {
+		return nil, fmt.Errorf("get dead transactions err: %v", err)
+	}
+
+	return transactions, nil
+}
+
+// Requeue resets a dead transaction so that it is retried as soon as possible,
+// with its attempts and age counted from now on. The results of its partners are kept.
+// It requires the storage to implement QueryStorage and ResetStorage.
+func (m *Manager) Requeue(id string) error {
+	q, ok := m.getStorage().(QueryStorage)
+	if !ok {
+		return fmt.Errorf("storage does not implement QueryStorage")
+	}
+	r, ok := m.getStorage().(ResetStorage)
+	if !ok {
+		return fmt.Errorf("storage does not implement ResetStorage")
+	}
+
+	tx, result, err := m.getTransaction(q, id)
+	if err != nil {
+		return fmt.Errorf("get transaction err: %w", err)
+	}
+	if result != Dead {
+		return fmt.Errorf("transaction is not dead: %v, result = %v", id, result)
+	}
+
+	tx.manager = m
+	tx.Times = 1
+	tx.RequeuedAt = time.Now()
+	tx.RetryAt = tx.RequeuedAt
+	if err := tx.callStorage("ResetTransaction", func(tx *Transaction) error {
+		return r.ResetTransaction(tx)
+	}); err != nil {
+		return fmt.Errorf("reset transaction err: %v", err)
+	}
+
+	return nil
+}
+
+// Cancel marks a transaction to be rolled back, if it has not passed the commit point:
+// its UncertainPartner has not succeeded, or its NormalPartners have not all succeeded if it has none.
+// The next execution undoes the NormalPartners already done and saves Fail, instead of continuing.
+// It returns an error if the transaction is committed or succeeded, and nil if it has failed.
+// An execution calling the UncertainPartner at the same time may still commit the transaction,
+// so the result should be checked after the transaction is finished.
+// It requires the storage to implement QueryStorage.
+func (m *Manager) Cancel(id string) error {
+	q, ok := m.getStorage().(QueryStorage)
+	if !ok {
+		return fmt.Errorf("storage does not implement QueryStorage")
+	}
+
+	tx, result, err := m.getTransaction(q, id)
+	if err != nil {
+		return fmt.Errorf("get transaction err: %w", err)
+	}
+
+	switch result {
+	case Success:
+		return fmt.Errorf("transaction has succeeded: %v", id)
+	case Fail:
+		return nil
+	}
+
+	tx.manager = m
+	if committed, err := tx.committed(); err != nil {
+		return fmt.Errorf("get partner result err: %v", err)
+	} else if committed {
+		return fmt.Errorf("transaction is committed: %v", id)
+	}
+
+	if err := tx.callStorage("SavePartnerResult", func(tx *Transaction) error {
+		return m.getStorage().SavePartnerResult(tx, phaseCancel, 0, 0, Fail)
+	}); err != nil {
+		return fmt.Errorf("save cancel mark err: %v", err)
+	}
+
+	// Check again for an execution committed in the meantime, the mark is ignored then.
+	if committed, err := tx.committed(); err != nil {
+		return fmt.Errorf("get partner result err: %v", err)
+	} else if committed {
+		return fmt.Errorf("transaction is committed: %v", id)
+	}
+
+	return nil
+}
+
+// Retry executes an unfinished transaction immediately instead of waiting for its retry time,
+// and returns the result of the retry. A dead transaction is requeued first.
+// A retry of the same transaction running at the same time is not stopped.
+// It requires the storage to implement QueryStorage, and ResetStorage for the dead transactions.
+func (m *Manager) Retry(ctx context.Context, id string) (Result, error) {
+	q, ok := m.getStorage().(QueryStorage)
+	if !ok {
+		return Uncertain, fmt.Errorf("storage does not implement QueryStorage")
+	}
+
+	tx, result, err := m.getTransaction(q, id)
+	if err != nil {
+		return Uncertain, fmt.Errorf("get transaction err: %w", err)
+	}
+
+	switch result {
+	case "":
+	case Dead:
+		if err := m.Requeue(id); err != nil {
+			return Uncertain, err
+		}
+		if tx, _, err = m.getTransaction(q, id); err != nil {
+			return Uncertain, fmt.Errorf("get transaction err: %w", err)
+		}
+	default:
+		return result, fmt.Errorf("transaction is finished: %v, result = %v", id, result)
+	}
+
+	return m.retry(ctx, tx)
+}
+
+// getTransaction gets the transaction from the storage,
+// and notifies the listeners of the error unless the transaction is not found.
+func (m *Manager) getTransaction(q QueryStorage, id string) (*Transaction, Result, error) {
+	tx, result, err := q.GetTransaction(id)
+	if err != nil && err != ErrTransactionNotFound {
+		m.storageFailed("GetTransaction", err)
+	}
+
+	return tx, result, err
+}
+
+// timeoutTransactions returns the transactions to retry.
+// They are claimed if the storage supports, otherwise got.
+func (m *Manager) timeoutTransactions(count int) ([]*Transaction, error) {
+	s, ok := m.getStorage().(ClaimStorage)
+	if !ok {
+		transactions, err := m.getStorage().GetTimeoutTransactions(count)
+		if err != nil {
+			m.storageFailed("GetTimeoutTransactions", err)
+			return nil, fmt.Errorf("get timeout transactions err: %v", err)
+		}
+		return transactions, nil
+	}
+
+	lease := m.lease
+	if lease <= 0 {
+		lease = m.timeout
+	}
+
+	owner, err := newLeaseOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.ClaimTimeoutTransactions(owner, count, lease)
+	if err != nil {
+		m.storageFailed("ClaimTimeoutTransactions", err)
+		return nil, fmt.Errorf("claim timeout transactions err: %v", err)
+	}
+
+	return transactions, nil
+}
+
+// newLeaseOwner returns a random token identifying a claim.
+func newLeaseOwner() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("generate lease owner err: %v", err)
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// retry binds the transaction from storage to the manager, and retries it.
+func (m *Manager) retry(ctx context.Context, tx *Transaction) (Result, error) {
+	tx.manager = m
+	return tx.ExecuteRetryContext(ctx)
+}
+
+// retryLimit returns the retry limits of the transactions of the name.
+func (m *Manager) retryLimit(name string) (maxAttempts int, maxAge time.Duration) {
+	limit, ok := m.limits[name]
+	if !ok {
+		limit = m.limits[""]
+	}
+	return limit.maxAttempts, limit.maxAge
+}
+
+func (m *Manager) getStorage() Storage {
+	if m.storage == nil {
+		panic("gtm: storage of manager is nil")
+	}
+	return m.storage
+}
+
+func (m *Manager) getTimer() Timer {
+	if m.timer == nil {
+		panic("gtm: timer of manager is nil")
+	}
+	return m.timer
+}
+
+func (m *Manager) getDoer() Doer {
+	if m.doer == nil {
+		panic("gtm: doer of manager is nil")
+	}
+	return m.doer
+}
@@ -0,0 +1,267 @@
+package gtm_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func TestManagerNew(t *testing.T) {
+	m := gtm.NewManager(nil)
+
+	if tx := m.New("test-manager"); tx.Manager() != m {
+		t.Errorf("tx is not bound to the manager")
+	}
+
+	if tx := gtm.New("test-default"); tx.Manager() != gtm.DefaultManager() {
+		t.Errorf("tx is not bound to the default manager")
+	}
+}
+
+func TestManagerRetryLimit(t *testing.T) {
+	var dead []string
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetRetryLimit("test-dead", 2, 0)
+	m.OnDead(func(tx *gtm.Transaction, reason error) {
+		dead = append(dead, tx.ID)
+	})
+
+	tx := m.New("test-dead").AddUncertain(&Counter{Result: gtm.Uncertain})
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Uncertain {
+		t.Fatalf("retry result = %v, err = %v, want uncertain", result, err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Dead || err == nil {
+		t.Fatalf("retry result = %v, err = %v, want dead with the reason", result, err)
+	}
+	if fmt.Sprint(dead) != fmt.Sprint([]string{tx.ID}) {
+		t.Errorf("OnDead() called with %v, want %v", dead, tx.ID)
+	}
+
+	if txs, err := m.DeadTransactions(10); err != nil || len(txs) != 1 || txs[0].ID != tx.ID {
+		t.Fatalf("DeadTransactions() = %v, %v, want %v", txs, err, tx.ID)
+	}
+	if txs, _ := m.Storage().GetTimeoutTransactions(10); len(txs) != 0 {
+		t.Errorf("GetTimeoutTransactions() returns %v dead transactions, want none", len(txs))
+	}
+
+	if err := m.Requeue(tx.ID); err != nil {
+		t.Fatalf("Requeue() err = %v", err)
+	}
+	if err := m.Requeue(tx.ID); err == nil {
+		t.Errorf("Requeue() of a requeued transaction returns no error")
+	}
+
+	transactions, results, _, err := m.RetryTimeoutTransactions(10)
+	if err != nil || len(transactions) != 1 || transactions[0].Times != 2 {
+		t.Fatalf("RetryTimeoutTransactions() after requeue = %v, %v, want retried as the second attempt", transactions, err)
+	}
+	if results[0] != gtm.Uncertain {
+		t.Errorf("retry result after requeue = %v, want uncertain", results[0])
+	}
+}
+
+func TestTransactionMaxAge(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetRetryLimit("", 100, time.Hour)
+
+	tx := m.New("test-max-age").SetRetryLimit(0, time.Nanosecond).AddUncertain(&Counter{Result: gtm.Uncertain})
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+
+	time.Sleep(time.Millisecond)
+	if result, err := tx.ExecuteRetry(); result != gtm.Dead {
+		t.Errorf("retry result = %v, err = %v, want dead by the max age of the transaction", result, err)
+	}
+}
+
+func TestRequeueMaxAge(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	tx := m.New("test-requeue-max-age").SetRetryLimit(0, 10*time.Millisecond).AddUncertain(&Counter{Result: gtm.Uncertain})
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	createdAt := tx.CreatedAt
+
+	time.Sleep(20 * time.Millisecond)
+	if result, err := tx.ExecuteRetry(); result != gtm.Dead {
+		t.Fatalf("retry result = %v, err = %v, want dead by the max age", result, err)
+	}
+	if err := m.Requeue(tx.ID); err != nil {
+		t.Fatalf("Requeue() err = %v", err)
+	}
+
+	// The age is counted from the requeue, and the creation time is kept.
+	requeued, _, err := m.Storage().(gtm.QueryStorage).GetTransaction(tx.ID)
+	if err != nil {
+		t.Fatalf("GetTransaction() err = %v", err)
+	}
+	if !requeued.CreatedAt.Equal(createdAt) || !requeued.RequeuedAt.After(createdAt) {
+		t.Errorf("CreatedAt, RequeuedAt = %v, %v after Requeue(), want %v and later", requeued.CreatedAt, requeued.RequeuedAt, createdAt)
+	}
+	if _, results, _, err := m.RetryTimeoutTransactions(10); err != nil || len(results) != 1 || results[0] != gtm.Uncertain {
+		t.Errorf("RetryTimeoutTransactions() after requeue = %v, %v, want uncertain", results, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	// Canceled before executed.
+	normal, uncertain := &Counter{Result: gtm.Success}, &Counter{Result: gtm.Success}
+	tx := m.New("test-cancel").AddNormal(normal).AddUncertain(uncertain)
+	if err := tx.ExecuteAsync(); err != nil {
+		t.Fatalf("ExecuteAsync() err = %v", err)
+	}
+	if err := m.Cancel(tx.ID); err != nil {
+		t.Fatalf("Cancel() err = %v", err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Fail {
+		t.Errorf("retry result = %v, err = %v, want fail", result, err)
+	}
+	if do, _, undo := normal.Calls(); do != 0 || undo != 0 {
+		t.Errorf("normal partner Do(), Undo() called %v, %v times, want 0, 0", do, undo)
+	}
+	if err := m.Cancel(tx.ID); err != nil {
+		t.Errorf("Cancel() of a failed transaction err = %v, want nil", err)
+	}
+
+	// Canceled when uncertain.
+	normal, uncertain = &Counter{Result: gtm.Success}, &Counter{Result: gtm.Uncertain}
+	tx = m.New("test-cancel").AddNormal(normal).AddUncertain(uncertain)
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	if err := m.Cancel(tx.ID); err != nil {
+		t.Fatalf("Cancel() err = %v", err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Fail {
+		t.Errorf("retry result = %v, err = %v, want fail", result, err)
+	}
+	if do, _, undo := normal.Calls(); do != 1 || undo != 1 {
+		t.Errorf("normal partner Do(), Undo() called %v, %v times, want 1, 1", do, undo)
+	}
+	if do, _, _ := uncertain.Calls(); do != 1 {
+		t.Errorf("uncertain partner Do() called %v times, want 1", do)
+	}
+}
+
+func TestCancelCommitted(t *testing.T) {
+	s := gtm.NewMemoryStorage()
+	m := gtm.NewManager(s)
+
+	tx := m.New("test-cancel").AddNormal(&Counter{Result: gtm.Success}).AddUncertain(&Counter{Result: gtm.Uncertain})
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	if err := s.SavePartnerResult(tx, "do-uncertain", 0, 0, gtm.Success); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+	if err := m.Cancel(tx.ID); err == nil {
+		t.Errorf("Cancel() of a committed transaction returns no error")
+	}
+
+	// Without an UncertainPartner, the transaction is committed once the NormalPartners succeeded.
+	tx = m.New("test-cancel").AddNormal(&Counter{Result: gtm.Success})
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if err := m.Cancel(tx.ID); err == nil {
+		t.Errorf("Cancel() of a successful transaction returns no error")
+	}
+
+	tx = m.New("test-cancel").AddNormal(&Counter{Result: gtm.Success})
+	if err := tx.ExecuteAsync(); err != nil {
+		t.Fatalf("ExecuteAsync() err = %v", err)
+	}
+	if err := s.SavePartnerResult(tx, "do-normal", 0, 0, gtm.Success); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+	if err := m.Cancel(tx.ID); err == nil {
+		t.Errorf("Cancel() of a committed transaction without UncertainPartner returns no error")
+	}
+}
+
+// UndoOffsetDoer is a SequenceDoer recording the offsets passed to Undo.
+type UndoOffsetDoer struct {
+	gtm.SequenceDoer
+
+	offsets []int
+}
+
+func (d *UndoOffsetDoer) Undo(tx *gtm.Transaction, undoOffset int) error {
+	d.offsets = append(d.offsets, undoOffset)
+	return d.SequenceDoer.Undo(tx, undoOffset)
+}
+
+func TestCancelSkipsFailedPartner(t *testing.T) {
+	doer := &UndoOffsetDoer{}
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetDoer(doer)
+
+	// The undo of the first partner fails, so the transaction is left unfinished.
+	succeeded, failed := &Counter{Result: gtm.Success, UndoErr: errors.New("undo failed")}, &Counter{Result: gtm.Fail}
+	tx := m.New("test-cancel").AddNormal(succeeded, failed)
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	if err := m.Cancel(tx.ID); err != nil {
+		t.Fatalf("Cancel() err = %v", err)
+	}
+
+	succeeded.UndoErr = nil
+	if result, err := tx.ExecuteRetry(); result != gtm.Fail {
+		t.Fatalf("retry result = %v, err = %v, want fail", result, err)
+	}
+	if _, _, undo := succeeded.Calls(); undo != 2 {
+		t.Errorf("successful partner Undo() called %v times, want 2", undo)
+	}
+	if _, _, undo := failed.Calls(); undo != 0 {
+		t.Errorf("failed partner Undo() called %v times, want 0", undo)
+	}
+	if fmt.Sprint(doer.offsets) != "[0 0]" {
+		t.Errorf("undo offsets = %v, want [0 0] excluding the failed partner", doer.offsets)
+	}
+}
+
+// LostResultStorage is a storage failing to save the do-normal results of the partner at Offset.
+type LostResultStorage struct {
+	*gtm.MemoryStorage
+
+	Offset int
+}
+
+func (s LostResultStorage) SavePartnerResult(tx *gtm.Transaction, phase string, offset int, cost time.Duration, result gtm.Result) error {
+	if phase == gtm.PhaseDoNormal && offset == s.Offset {
+		return errors.New("connection refused")
+	}
+	return s.MemoryStorage.SavePartnerResult(tx, phase, offset, cost, result)
+}
+
+func TestCancelUndoesLostResult(t *testing.T) {
+	for _, doer := range []gtm.Doer{&gtm.SequenceDoer{}, &gtm.ParallelDoer{}} {
+		m := gtm.NewManager(LostResultStorage{gtm.NewMemoryStorage(), 1}).SetDoer(doer)
+
+		// The Do of the second partner is executed, but its result is lost.
+		first, second := &Counter{Result: gtm.Success}, &Counter{Result: gtm.Success}
+		tx := m.New("test-cancel").AddNormal(first, second)
+		if result, err := tx.Execute(); result != gtm.Uncertain {
+			t.Fatalf("%T: result = %v, err = %v, want uncertain", doer, result, err)
+		}
+		if err := m.Cancel(tx.ID); err != nil {
+			t.Fatalf("%T: Cancel() err = %v", doer, err)
+		}
+		if result, err := tx.ExecuteRetry(); result != gtm.Fail {
+			t.Fatalf("%T: retry result = %v, err = %v, want fail", doer, result, err)
+		}
+		for i, partner := range []*Counter{first, second} {
+			if do, _, undo := partner.Calls(); do != 1 || undo != 1 {
+				t.Errorf("%T: partner %v Do(), Undo() called %v, %v times, want 1, 1", doer, i, do, undo)
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package gtm
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the histograms of Metrics.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics is a Listener counting the transactions and the partners,
+// and an http.Handler exposing the metrics in the Prometheus text format:
+//
+//	gtm_transactions_saved_total{name}                  new transactions saved
+//	gtm_transactions_executed_total{name,result}        results returned by the executions
+//	gtm_transactions_finished_total{name,result}        final results saved, including Dead
+//	gtm_transaction_duration_seconds{name,result}       cost of the final executions, excluding Dead
+//	gtm_transaction_retries_total{name}                 retries scheduled
+//	gtm_partner_calls_total{name,phase,result}          partners called
+//	gtm_partner_duration_seconds{name,phase}            time the partners took
+//	gtm_storage_errors_total{operation}                 failed operations of the storage
+//	gtm_retry_backlog                                   unfinished transactions due to retry
+//
+// The backlog is counted when the metrics are scraped, if the storage implements CountStorage.
+type Metrics struct {
+	NopListener
+
+	storage Storage
+	buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+var (
+	_ ExecutionListener    = &Metrics{}
+	_ StorageErrorListener = &Metrics{}
+)
+
+// metricFamily is a metric with the series of its label values.
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*metricSeries
+}
+
+// metricSeries is the value of a counter, or the observations of a histogram.
+type metricSeries struct {
+	values []string
+	value  float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewMetrics returns the Metrics sampling the backlog from the storage, which may be nil.
+// Add it to a Manager by AddListener, and serve it at an endpoint such as /metrics.
+func NewMetrics(storage Storage) *Metrics {
+	return &Metrics{
+		storage:  storage,
+		buckets:  DefaultBuckets,
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// SetBuckets sets the upper bounds in seconds of the histograms, in increasing order.
+// It should be called before the metrics are collected.
+func (m *Metrics) SetBuckets(buckets []float64) *Metrics {
+	m.buckets = buckets
+	return m
+}
+
+func (m *Metrics) OnSaved(tx *Transaction) {
+	m.add("gtm_transactions_saved_total", "Number of new transactions saved.", []string{"name"}, tx.Name)
+}
+
+func (m *Metrics) OnPartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result, err error) {
+	m.add("gtm_partner_calls_total", "Number of partners called by phase and result.",
+		[]string{"name", "phase", "result"}, tx.Name, phase, string(result))
+	m.observe("gtm_partner_duration_seconds", "Time the partners took by phase.",
+		[]string{"name", "phase"}, cost, tx.Name, phase)
+}
+
+func (m *Metrics) OnRetryScheduled(tx *Transaction, times int, retryAt time.Time) {
+	m.add("gtm_transaction_retries_total", "Number of retries scheduled.", []string{"name"}, tx.Name)
+}
+
+func (m *Metrics) OnFinal(tx *Transaction, result Result, cost time.Duration) {
+	m.add("gtm_transactions_finished_total", "Number of final results saved.", []string{"name", "result"}, tx.Name, string(result))
+	if result != Dead {
+		m.observe("gtm_transaction_duration_seconds", "Cost of the final executions.",
+			[]string{"name", "result"}, cost, tx.Name, string(result))
+	}
+}
+
+func (m *Metrics) OnExecuted(tx *Transaction, result Result, err error) {
+	m.add("gtm_transactions_executed_total", "Number of results returned by the executions.",
+		[]string{"name", "result"}, tx.Name, string(result))
+}
+
+func (m *Metrics) OnStorageError(tx *Transaction, operation string, err error) {
+	m.add("gtm_storage_errors_total", "Number of failed operations of the storage.", []string{"operation"}, operation)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	backlog, sampled := m.backlog()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m.families[name].write(b, m.buckets)
+	}
+
+	if sampled {
+		fmt.Fprintf(b, "# HELP gtm_retry_backlog Number of unfinished transactions due to retry.\n")
+		fmt.Fprintf(b, "# TYPE gtm_retry_backlog gauge\n")
+		fmt.Fprintf(b, "gtm_retry_backlog %v\n", backlog)
+	}
+}
+
+// backlog counts the transactions to be retried, the error is counted as a storage error.
+func (m *Metrics) backlog() (count int, ok bool) {
+	s, ok := m.storage.(CountStorage)
+	if !ok {
+		return 0, false
+	}
+
+	count, err := s.CountTimeoutTransactions()
+	if err != nil {
+		m.OnStorageError(nil, "CountTimeoutTransactions", err)
+		return 0, false
+	}
+
+	return count, true
+}
+
+// add increases the counter of the label values by 1.
+func (m *Metrics) add(name, help string, labels []string, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series(name, help, "counter", labels, values).value++
+}
+
+// observe adds the duration to the histogram of the label values.
+func (m *Metrics) observe(name, help string, labels []string, d time.Duration, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.series(name, help, "histogram", labels, values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(m.buckets))
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+// series returns the series of the label values, created if it does not exist.
+// It must be called with the lock held.
+func (m *Metrics) series(name, help, kind string, labels, values []string) *metricSeries {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+		m.families[name] = f
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{values: values}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// write writes the family with its series in the order of the label values.
+func (f *metricFamily) write(b *bufio.Writer, buckets []float64) {
+	fmt.Fprintf(b, "# HELP %v %v\n", f.name, f.help)
+	fmt.Fprintf(b, "# TYPE %v %v\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		labels := formatLabels(f.labels, s.values)
+
+		if f.kind != "histogram" {
+			fmt.Fprintf(b, "%v%v %v\n", f.name, wrapLabels(labels), formatValue(s.value))
+			continue
+		}
+
+		for i, bound := range buckets {
+			le := `le="` + formatValue(bound) + `"`
+			fmt.Fprintf(b, "%v_bucket%v %v\n", f.name, wrapLabels(joinLabels(labels, le)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%v_bucket%v %v\n", f.name, wrapLabels(joinLabels(labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(b, "%v_sum%v %v\n", f.name, wrapLabels(labels), formatValue(s.sum))
+		fmt.Fprintf(b, "%v_count%v %v\n", f.name, wrapLabels(labels), s.count)
+	}
+}
+
+// formatLabels returns the labels as name="value" pairs separated by commas.
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,46 @@
+package gtm_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func TestMetrics(t *testing.T) {
+	s := gtm.NewMemoryStorage()
+	metrics := gtm.NewMetrics(s).SetBuckets([]float64{1})
+	m := gtm.NewManager(s).AddListener(metrics)
+
+	if result, err := m.New("test-metrics").AddNormal(&Counter{Result: gtm.Fail}).Execute(); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+	if err := m.New("test-metrics").AddNormal(&Counter{Result: gtm.Success}).ExecuteAsync(); err != nil {
+		t.Fatalf("execute async err: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+
+	for _, want := range []string{
+		"# TYPE gtm_transactions_saved_total counter",
+		`gtm_transactions_saved_total{name="test-metrics"} 2`,
+		`gtm_transactions_executed_total{name="test-metrics",result="fail"} 1`,
+		`gtm_transactions_finished_total{name="test-metrics",result="fail"} 1`,
+		`gtm_transaction_duration_seconds_bucket{name="test-metrics",result="fail",le="1"} 1`,
+		`gtm_transaction_duration_seconds_bucket{name="test-metrics",result="fail",le="+Inf"} 1`,
+		`gtm_transaction_duration_seconds_count{name="test-metrics",result="fail"} 1`,
+		`gtm_partner_calls_total{name="test-metrics",phase="do-normal",result="fail"} 1`,
+		"# TYPE gtm_partner_duration_seconds histogram",
+		`gtm_partner_duration_seconds_count{name="test-metrics",phase="do-normal"} 1`,
+		"# TYPE gtm_retry_backlog gauge",
+		"gtm_retry_backlog 1",
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics does not contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package gtm
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 // NormalPartner is a normal participant.
 // This participant needs three methods to implement 2PC.
 // In business, DoNext is often omitted and can directly return success.
@@ -24,3 +30,102 @@ type UncertainPartner interface {
 type CertainPartner interface {
 	DoNext() error
 }
+
+// NormalPartnerContext is an optional interface that may be implemented by a NormalPartner.
+// If implemented, the doer will call the context version instead of the plain method,
+// so that the partner can observe cancellation, deadlines and request-scoped values.
+type NormalPartnerContext interface {
+	DoContext(ctx context.Context) (Result, error)
+	DoNextContext(ctx context.Context) error
+	UndoContext(ctx context.Context) error
+}
+
+// UncertainPartnerContext is the context version of UncertainPartner.
+// It is optional and preferred over UncertainPartner.Do when implemented.
+type UncertainPartnerContext interface {
+	DoContext(ctx context.Context) (Result, error)
+}
+
+// CertainPartnerContext is the context version of CertainPartner.
+// It is optional and preferred over CertainPartner.DoNext when implemented.
+type CertainPartnerContext interface {
+	DoNextContext(ctx context.Context) error
+}
+
+// partnerDo calls DoContext if the partner implements NormalPartnerContext or UncertainPartnerContext, otherwise Do.
+// The listeners are notified before and after the call, which is traced in a span.
+func partnerDo(tx *Transaction, phase string, offset int, partner UncertainPartner) (result Result, err error) {
+	tx.notify(func(l Listener) { l.OnPartnerStart(tx, phase, offset) })
+	ctx, span := tx.startPartnerSpan(phase, offset, partner)
+	begin := time.Now()
+
+	switch p := partner.(type) {
+	case NormalPartnerContext:
+		result, err = p.DoContext(ctx)
+	case UncertainPartnerContext:
+		result, err = p.DoContext(ctx)
+	default:
+		result, err = partner.Do()
+	}
+
+	cost := time.Since(begin)
+	span.SetAttributes(Attribute{AttrResult, string(result)})
+	span.End(err)
+	tx.notify(func(l Listener) { l.OnPartnerResult(tx, phase, offset, cost, result, err) })
+	return result, err
+}
+
+// partnerDoNext calls DoNextContext if the partner implements NormalPartnerContext or CertainPartnerContext, otherwise DoNext.
+// The listeners are notified before and after the call, which is traced in a span.
+func partnerDoNext(tx *Transaction, phase string, offset int, partner CertainPartner) (err error) {
+	tx.notify(func(l Listener) { l.OnPartnerStart(tx, phase, offset) })
+	ctx, span := tx.startPartnerSpan(phase, offset, partner)
+	begin := time.Now()
+
+	switch p := partner.(type) {
+	case NormalPartnerContext:
+		err = p.DoNextContext(ctx)
+	case CertainPartnerContext:
+		err = p.DoNextContext(ctx)
+	default:
+		err = partner.DoNext()
+	}
+
+	cost := time.Since(begin)
+	span.End(err)
+	tx.notify(func(l Listener) { l.OnPartnerResult(tx, phase, offset, cost, errResult(err), err) })
+	return err
+}
+
+// partnerUndo calls UndoContext if the partner implements NormalPartnerContext, otherwise Undo.
+// The listeners are notified before and after the call, which is traced in a span.
+func partnerUndo(tx *Transaction, phase string, offset int, partner NormalPartner) (err error) {
+	tx.notify(func(l Listener) { l.OnPartnerStart(tx, phase, offset) })
+	ctx, span := tx.startPartnerSpan(phase, offset, partner)
+	begin := time.Now()
+
+	if p, ok := partner.(NormalPartnerContext); ok {
+		err = p.UndoContext(ctx)
+	} else {
+		err = partner.Undo()
+	}
+
+	cost := time.Since(begin)
+	span.End(err)
+	tx.notify(func(l Listener) { l.OnPartnerResult(tx, phase, offset, cost, errResult(err), err) })
+	return err
+}
+
+// errResult returns the result of a partner method returning only an error.
+func errResult(err error) Result {
+	if err != nil {
+		return Fail
+	}
+	return Success
+}
+
+// startPartnerSpan starts the span of a partner call, named by the phase.
+func (tx *Transaction) startPartnerSpan(phase string, offset int, partner interface{}) (context.Context, Span) {
+	return tx.startSpan("gtm."+phase, Attribute{AttrTransactionTimes, tx.Times},
+		Attribute{AttrPhase, phase}, Attribute{AttrOffset, offset}, Attribute{AttrPartner, fmt.Sprintf("%T", partner)})
+}
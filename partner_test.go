@@ -1,9 +1,12 @@
 package gtm_test
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/quanhengzhuang/gtm"
@@ -12,6 +15,9 @@ import (
 var (
 	_ gtm.NormalPartner    = &Payer{}
 	_ gtm.UncertainPartner = &OrderCreator{}
+
+	_ gtm.NormalPartnerContext  = &Locker{}
+	_ gtm.CertainPartnerContext = &Notifier{}
 )
 
 type Payer struct {
@@ -55,3 +61,93 @@ func (order *OrderCreator) Do() (gtm.Result, error) {
 		return gtm.Uncertain, fmt.Errorf("network anomaly")
 	}
 }
+
+// Locker is a context-aware normal partner, recording the values of the keys in the contexts of its calls.
+type Locker struct {
+	Key interface{}
+
+	mu     sync.Mutex
+	values []string
+}
+
+func (l *Locker) Do() (gtm.Result, error) { return l.DoContext(context.Background()) }
+func (l *Locker) DoNext() error           { return l.DoNextContext(context.Background()) }
+func (l *Locker) Undo() error             { return l.UndoContext(context.Background()) }
+
+func (l *Locker) DoContext(ctx context.Context) (gtm.Result, error) {
+	l.record("do", ctx)
+	return gtm.Success, nil
+}
+
+func (l *Locker) DoNextContext(ctx context.Context) error {
+	l.record("doNext", ctx)
+	return nil
+}
+
+func (l *Locker) UndoContext(ctx context.Context) error {
+	l.record("undo", ctx)
+	return nil
+}
+
+func (l *Locker) record(method string, ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.values = append(l.values, fmt.Sprintf("%v %v", method, ctx.Value(l.Key)))
+}
+
+// Values returns the recorded values, such as "do alice".
+func (l *Locker) Values() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.values...)
+}
+
+// Notifier is a context-aware certain partner.
+type Notifier struct {
+	OrderID string
+}
+
+func (n *Notifier) DoNext() error {
+	return n.DoNextContext(context.Background())
+}
+
+func (n *Notifier) DoNextContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("[notifier] notify. n = %+v", n)
+	return nil
+}
+
+// Counter is a normal partner returning Result and counting the calls of each method.
+type Counter struct {
+	Result gtm.Result
+
+	// UndoErr is returned by Undo if it is not nil.
+	UndoErr error
+
+	do, doNext, undo int32
+}
+
+func (c *Counter) Do() (gtm.Result, error) {
+	atomic.AddInt32(&c.do, 1)
+	if c.Result != gtm.Success {
+		return c.Result, fmt.Errorf("counter %v", c.Result)
+	}
+	return gtm.Success, nil
+}
+
+func (c *Counter) DoNext() error {
+	atomic.AddInt32(&c.doNext, 1)
+	return nil
+}
+
+func (c *Counter) Undo() error {
+	atomic.AddInt32(&c.undo, 1)
+	return c.UndoErr
+}
+
+func (c *Counter) Calls() (do, doNext, undo int) {
+	return int(atomic.LoadInt32(&c.do)), int(atomic.LoadInt32(&c.doNext)), int(atomic.LoadInt32(&c.undo))
+}
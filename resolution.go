@@ -0,0 +1,253 @@
+package gtm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Actions of the resolutions.
+const (
+	ActionSuspend      = "suspend"
+	ActionResume       = "resume"
+	ActionForceSuccess = "force-success"
+	ActionForceFail    = "force-fail"
+)
+
+// Resolution records a manual intervention on a transaction: what was done, by whom and why.
+type Resolution struct {
+	Action    string
+	Operator  string
+	Reason    string
+	CreatedAt time.Time
+}
+
+type operatorKey struct{}
+
+// WithOperator returns a context carrying the operator, who is recorded in the resolutions.
+func WithOperator(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, operatorKey{}, operator)
+}
+
+// OperatorFromContext returns the operator carried by the context, or "" if there is none.
+func OperatorFromContext(ctx context.Context) string {
+	operator, _ := ctx.Value(operatorKey{}).(string)
+	return operator
+}
+
+// Suspend stops retrying a transaction of the default manager until it is resolved manually.
+func Suspend(ctx context.Context, id, reason string) error {
+	return defaultManager.Suspend(ctx, id, reason)
+}
+
+// Resume retries a suspended transaction of the default manager.
+func Resume(ctx context.Context, id, reason string) (Result, error) {
+	return defaultManager.Resume(ctx, id, reason)
+}
+
+// ForceSuccess completes a suspended or dead transaction of the default manager as Success.
+func ForceSuccess(ctx context.Context, id, reason string) error {
+	return defaultManager.ForceSuccess(ctx, id, reason)
+}
+
+// ForceFail completes a suspended or dead transaction of the default manager as Fail.
+func ForceFail(ctx context.Context, id, reason string) error {
+	return defaultManager.ForceFail(ctx, id, reason)
+}
+
+// Suspend saves an unfinished or dead transaction as Suspended, so that it is not retried any more
+// until it is resumed or forced by an operator, e.g. when the downstream system must be checked by a human.
+// A retry already running is not stopped, and may still finish the transaction.
+// The operator is taken from ctx, see WithOperator.
+// It requires the storage to implement QueryStorage, ResetStorage and ResolutionStorage.
+func (m *Manager) Suspend(ctx context.Context, id, reason string) error {
+	tx, err := m.resolvable(ctx, id, "", Dead)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.callStorage("SaveTransactionResult", func(tx *Transaction) error {
+		return m.getStorage().SaveTransactionResult(tx, 0, Suspended)
+	}); err != nil {
+		return fmt.Errorf("save suspended result err: %v", err)
+	}
+
+	return m.saveResolution(tx, ActionSuspend, reason)
+}
+
+// Resume retries a suspended transaction immediately, and returns the result of the retry.
+// If the retry is not finished, the transaction is retried automatically again.
+// As Requeue, its attempts and age are counted from now on, so that a transaction suspended after it was dead is retried.
+func (m *Manager) Resume(ctx context.Context, id, reason string) (Result, error) {
+	tx, err := m.resolvable(ctx, id, Suspended)
+	if err != nil {
+		return Uncertain, err
+	}
+
+	if err := m.saveResolution(tx, ActionResume, reason); err != nil {
+		return Uncertain, err
+	}
+
+	tx.Times = 1
+	tx.RequeuedAt = time.Now()
+	tx.RetryAt = tx.RequeuedAt
+
+	if err := tx.callStorage("ResetTransaction", func(tx *Transaction) error {
+		return m.getStorage().(ResetStorage).ResetTransaction(tx)
+	}); err != nil {
+		return Uncertain, fmt.Errorf("reset transaction err: %v", err)
+	}
+
+	return m.retry(ctx, tx)
+}
+
+// ForceSuccess completes a suspended or dead transaction as Success,
+// after the operator confirmed that its UncertainPartner succeeded.
+// The DoNext of the partners is executed through the Doer.
+// It returns an error without any change if a NormalPartner did not succeed.
+func (m *Manager) ForceSuccess(ctx context.Context, id, reason string) error {
+	tx, err := m.resolvable(ctx, id, Suspended, Dead)
+	if err != nil {
+		return err
+	}
+
+	tx.prepareResolve(ctx)
+	for i := range tx.NormalPartners {
+		if result := tx.getPartnerResult(PhaseDoNormal, i); result != Success {
+			return conflictErrorf("normal partner is not successful: %v, %q", i, result)
+		}
+	}
+	if tx.UncertainPartner != nil {
+		if result := tx.getPartnerResult(PhaseDoUncertain, 0); result == Fail {
+			return conflictErrorf("uncertain partner has failed")
+		}
+		if err := tx.savePartnerResult(PhaseDoUncertain, 0, 0, Success); err != nil {
+			return fmt.Errorf("save partner result err: %v", err)
+		}
+	}
+
+	if _, err := tx.doNext(); err != nil {
+		return fmt.Errorf("doNext() failed: %v", err)
+	}
+
+	return m.finishResolve(tx, Success, ActionForceSuccess, reason)
+}
+
+// ForceFail completes a suspended or dead transaction as Fail,
+// after the operator confirmed that its UncertainPartner failed.
+// The Undo of the NormalPartners which were executed is executed through the Doer.
+// It returns an error without any change if the UncertainPartner succeeded, as the transaction is committed.
+func (m *Manager) ForceFail(ctx context.Context, id, reason string) error {
+	tx, err := m.resolvable(ctx, id, Suspended, Dead)
+	if err != nil {
+		return err
+	}
+
+	tx.prepareResolve(ctx)
+	if tx.UncertainPartner != nil {
+		if result := tx.getPartnerResult(PhaseDoUncertain, 0); result == Success {
+			return conflictErrorf("uncertain partner has succeeded")
+		}
+		if err := tx.savePartnerResult(PhaseDoUncertain, 0, 0, Fail); err != nil {
+			return fmt.Errorf("save partner result err: %v", err)
+		}
+	}
+
+	if err := tx.undo(tx.doneOffset()); err != nil {
+		return fmt.Errorf("undo() failed: %v", err)
+	}
+
+	return m.finishResolve(tx, Fail, ActionForceFail, reason)
+}
+
+// resolvable returns the transaction of id bound to the manager, if its result is one of the results.
+func (m *Manager) resolvable(ctx context.Context, id string, results ...Result) (*Transaction, error) {
+	q, ok := m.getStorage().(QueryStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not implement QueryStorage")
+	}
+	if _, ok := m.getStorage().(ResetStorage); !ok {
+		return nil, fmt.Errorf("storage does not implement ResetStorage")
+	}
+	if _, ok := m.getStorage().(ResolutionStorage); !ok {
+		return nil, fmt.Errorf("storage does not implement ResolutionStorage")
+	}
+
+	tx, result, err := m.getTransaction(q, id)
+	if err != nil {
+		return nil, fmt.Errorf("get transaction err: %w", err)
+	}
+
+	for _, r := range results {
+		if result == r {
+			tx.manager = m
+			tx.ctx = ctx
+			if err := tx.upgrade(); err != nil {
+				return nil, err
+			}
+			return tx, nil
+		}
+	}
+
+	return nil, conflictErrorf("transaction can not be resolved: %v, result = %q", id, result)
+}
+
+// prepareResolve prepares the transaction to execute a phase as a retry,
+// so that the saved partner results are used and the async partners are included.
+func (tx *Transaction) prepareResolve(ctx context.Context) {
+	tx.ctx = ctx
+	tx.Times++
+	tx.startAt = time.Now()
+	tx.results = newResultCache()
+}
+
+// finishResolve saves the result of a forced transaction and the resolution.
+func (m *Manager) finishResolve(tx *Transaction, result Result, action, reason string) error {
+	if err := tx.callStorage("UpdateTransactionRetryTime", func(tx *Transaction) error {
+		return m.getStorage().UpdateTransactionRetryTime(tx, tx.Times, tx.RetryAt)
+	}); err != nil {
+		return fmt.Errorf("update transaction times err: %v", err)
+	}
+
+	// The resolution is saved first, as the record of a successful or failed transaction may be deleted.
+	if err := m.saveResolution(tx, action, reason); err != nil {
+		return err
+	}
+
+	if err := tx.saveResult(result); err != nil {
+		return fmt.Errorf("save result failed: %v, %v", err, result)
+	}
+
+	return nil
+}
+
+func (m *Manager) saveResolution(tx *Transaction, action, reason string) error {
+	resolution := &Resolution{
+		Action:    action,
+		Operator:  OperatorFromContext(tx.Context()),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+
+	if err := tx.callStorage("SaveResolution", func(tx *Transaction) error {
+		return m.getStorage().(ResolutionStorage).SaveResolution(tx, resolution)
+	}); err != nil {
+		return fmt.Errorf("save resolution err: %v", err)
+	}
+
+	return nil
+}
+
+// conflictError is returned by the resolutions when the state of the transaction does not allow the action,
+// unlike the errors of the storage and the partners.
+type conflictError struct {
+	msg string
+}
+
+func (e conflictError) Error() string {
+	return e.msg
+}
+
+func conflictErrorf(format string, args ...interface{}) error {
+	return conflictError{msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,208 @@
+package gtm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// suspended returns a suspended transaction of the manager, whose UncertainPartner is uncertain.
+func suspended(t *testing.T, m *gtm.Manager, ctx context.Context) (tx *gtm.Transaction, normal, uncertain, certain *Counter) {
+	normal, uncertain, certain = &Counter{Result: gtm.Success}, &Counter{Result: gtm.Uncertain}, &Counter{}
+	tx = m.New("test-resolution").AddNormal(normal).AddUncertain(uncertain).AddCertain(certain)
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+
+	if err := m.Suspend(ctx, tx.ID, "check the order"); err != nil {
+		t.Fatalf("Suspend() err = %v", err)
+	}
+
+	return tx, normal, uncertain, certain
+}
+
+func transactionResult(t *testing.T, m *gtm.Manager, id string) gtm.Result {
+	t.Helper()
+
+	_, result, err := m.Storage().(gtm.QueryStorage).GetTransaction(id)
+	if err != nil {
+		t.Fatalf("GetTransaction() err = %v", err)
+	}
+
+	return result
+}
+
+func TestSuspendAndForceSuccess(t *testing.T) {
+	s := gtm.NewMemoryStorage()
+	m := gtm.NewManager(s)
+	ctx := gtm.WithOperator(context.Background(), "alice")
+
+	tx, normal, _, certain := suspended(t, m, ctx)
+	if result := transactionResult(t, m, tx.ID); result != gtm.Suspended {
+		t.Fatalf("result = %v after Suspend(), want suspended", result)
+	}
+	if err := m.Suspend(ctx, tx.ID, ""); err == nil {
+		t.Errorf("Suspend() of a suspended transaction returns no error")
+	}
+
+	if err := m.ForceSuccess(ctx, tx.ID, "the order is created"); err != nil {
+		t.Fatalf("ForceSuccess() err = %v", err)
+	}
+	if result := transactionResult(t, m, tx.ID); result != gtm.Success {
+		t.Errorf("result = %v after ForceSuccess(), want success", result)
+	}
+	if _, doNext, _ := normal.Calls(); doNext != 1 {
+		t.Errorf("normal partner DoNext() called %v times, want 1", doNext)
+	}
+	if _, doNext, _ := certain.Calls(); doNext != 1 {
+		t.Errorf("certain partner DoNext() called %v times, want 1", doNext)
+	}
+
+	resolutions, err := s.GetResolutions(tx.ID)
+	if err != nil || len(resolutions) != 2 {
+		t.Fatalf("GetResolutions() = %v, %v, want 2 resolutions", resolutions, err)
+	}
+	if r := resolutions[1]; r.Action != gtm.ActionForceSuccess || r.Operator != "alice" || r.Reason != "the order is created" {
+		t.Errorf("resolution = %+v, want force-success by alice", r)
+	}
+
+	if err := m.ForceFail(ctx, tx.ID, ""); err == nil {
+		t.Errorf("ForceFail() of a successful transaction returns no error")
+	}
+}
+
+func TestForceFail(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	tx, normal, _, certain := suspended(t, m, context.Background())
+	if err := m.ForceFail(context.Background(), tx.ID, "the order is not created"); err != nil {
+		t.Fatalf("ForceFail() err = %v", err)
+	}
+
+	if result := transactionResult(t, m, tx.ID); result != gtm.Fail {
+		t.Errorf("result = %v after ForceFail(), want fail", result)
+	}
+	if _, doNext, undo := normal.Calls(); doNext != 0 || undo != 1 {
+		t.Errorf("normal partner DoNext(), Undo() called %v, %v times, want 0, 1", doNext, undo)
+	}
+	if _, doNext, _ := certain.Calls(); doNext != 0 {
+		t.Errorf("certain partner DoNext() called %v times, want 0", doNext)
+	}
+}
+
+func TestForceFailCommitted(t *testing.T) {
+	s := gtm.NewMemoryStorage()
+	m := gtm.NewManager(s)
+
+	tx, _, _, _ := suspended(t, m, context.Background())
+	if err := s.SavePartnerResult(tx, "do-uncertain", 0, 0, gtm.Success); err != nil {
+		t.Fatalf("SavePartnerResult() err = %v", err)
+	}
+
+	if err := m.ForceFail(context.Background(), tx.ID, ""); err == nil {
+		t.Errorf("ForceFail() of a committed transaction returns no error")
+	}
+	if result := transactionResult(t, m, tx.ID); result != gtm.Suspended {
+		t.Errorf("result = %v after ForceFail() is refused, want suspended", result)
+	}
+}
+
+func TestResume(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	tx, _, uncertain, certain := suspended(t, m, context.Background())
+	if txs, _ := m.Storage().GetTimeoutTransactions(10); len(txs) != 0 {
+		t.Errorf("GetTimeoutTransactions() returns %v suspended transactions, want none", len(txs))
+	}
+
+	// The partners are shared with the memory storage, the downstream is fixed.
+	uncertain.Result = gtm.Success
+	if result, err := m.Resume(context.Background(), tx.ID, "fixed"); result != gtm.Success {
+		t.Fatalf("Resume() = %v, %v, want success", result, err)
+	}
+	if result := transactionResult(t, m, tx.ID); result != gtm.Success {
+		t.Errorf("result = %v after Resume(), want success", result)
+	}
+	if _, doNext, _ := certain.Calls(); doNext != 1 {
+		t.Errorf("certain partner DoNext() called %v times, want 1", doNext)
+	}
+}
+
+func TestResumeDead(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetRetryLimit("test-resume-dead", 2, 0)
+
+	uncertain := &Counter{Result: gtm.Uncertain}
+	tx := m.New("test-resume-dead").AddUncertain(uncertain)
+	tx.Execute()
+	tx.ExecuteRetry()
+	if result, err := tx.ExecuteRetry(); result != gtm.Dead {
+		t.Fatalf("retry result = %v, err = %v, want dead", result, err)
+	}
+	if err := m.Suspend(context.Background(), tx.ID, "check the order"); err != nil {
+		t.Fatalf("Suspend() err = %v", err)
+	}
+
+	uncertain.Result = gtm.Success
+	if result, err := m.Resume(context.Background(), tx.ID, "fixed"); result != gtm.Success {
+		t.Fatalf("Resume() = %v, %v, want success", result, err)
+	}
+	if result := transactionResult(t, m, tx.ID); result != gtm.Success {
+		t.Errorf("result = %v after Resume(), want success", result)
+	}
+	if resumed, _, _ := m.Storage().(gtm.QueryStorage).GetTransaction(tx.ID); !resumed.CreatedAt.Equal(tx.CreatedAt) {
+		t.Errorf("CreatedAt = %v after Resume(), want %v", resumed.CreatedAt, tx.CreatedAt)
+	}
+}
+
+func TestForceFailSkipsFailedPartner(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	// The undo of the first partner fails, so the transaction is left unfinished.
+	succeeded, failed := &Counter{Result: gtm.Success, UndoErr: errors.New("undo failed")}, &Counter{Result: gtm.Fail}
+	tx := m.New("test-force-fail").AddNormal(succeeded, failed)
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+	if err := m.Suspend(context.Background(), tx.ID, "check the undo"); err != nil {
+		t.Fatalf("Suspend() err = %v", err)
+	}
+
+	succeeded.UndoErr = nil
+	if err := m.ForceFail(context.Background(), tx.ID, "undone"); err != nil {
+		t.Fatalf("ForceFail() err = %v", err)
+	}
+	if _, _, undo := succeeded.Calls(); undo != 2 {
+		t.Errorf("successful partner Undo() called %v times, want 2", undo)
+	}
+	if _, _, undo := failed.Calls(); undo != 0 {
+		t.Errorf("failed partner Undo() called %v times, want 0", undo)
+	}
+}
+
+// BrokenResolutionStorage is a storage failing to save the resolutions.
+type BrokenResolutionStorage struct {
+	*gtm.MemoryStorage
+}
+
+func (s BrokenResolutionStorage) SaveResolution(tx *gtm.Transaction, resolution *gtm.Resolution) error {
+	return errors.New("connection refused")
+}
+
+func TestResolutionStorageError(t *testing.T) {
+	r := &ErrorRecorder{}
+	m := gtm.NewManager(BrokenResolutionStorage{gtm.NewMemoryStorage()}).AddListener(r)
+
+	tx := m.New("test-resolution-storage-error").AddUncertain(&Counter{Result: gtm.Uncertain})
+	if result, err := tx.Execute(); result != gtm.Uncertain {
+		t.Fatalf("result = %v, err = %v, want uncertain", result, err)
+	}
+
+	if err := m.Suspend(context.Background(), tx.ID, "check the order"); err == nil {
+		t.Fatalf("Suspend() returns no error")
+	}
+	if !contains(r.Events(), "storage error SaveResolution") {
+		t.Errorf("events = %q, want the storage error of SaveResolution", r.Events())
+	}
+}
@@ -0,0 +1,188 @@
+package gtm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Retention keeps the finished transactions of the result for MaxAge after they are finished.
+type Retention struct {
+	Result Result
+	MaxAge time.Duration
+
+	// Before purges the transactions finished before the time instead of MaxAge, if it is not zero.
+	Before time.Time
+}
+
+// Archiver writes the finished transactions before they are purged, e.g. to an archive table or a file.
+type Archiver interface {
+	// Archive writes the transactions of the result.
+	// They are deleted only if it returns nil, and may be archived again if the deletion fails.
+	Archive(result Result, txs []*ArchivedTransaction) error
+}
+
+// ArchivedTransaction is a finished transaction written by Archiver, with the saved results of its partners.
+// The creation time of the transaction is the one saved by the storage.
+type ArchivedTransaction struct {
+	Transaction    *Transaction
+	PartnerResults []*PartnerResultRecord
+}
+
+var (
+	_ Archiver = &JSONLArchiver{}
+	_ Archiver = &SQLArchiver{}
+)
+
+// Purge deletes the finished transactions of the default manager beyond the retention.
+func Purge(count int) (int, error) {
+	return defaultManager.Purge(count)
+}
+
+// Purge deletes the finished transactions beyond the retention of the manager, and their partner results.
+// At most count transactions of each result are purged by a call, so that a call takes bounded time,
+// call it periodically, e.g. by Scheduler.SetPurgeInterval. Returns the number of transactions purged.
+// The transactions are not decoded, unless the archiver is set, which writes them first.
+// A transaction which can not be decoded is not archived, Purge returns its DecodeError and keeps the batch.
+// It requires the storage to implement PurgeStorage, and QueryStorage for the archiver.
+func (m *Manager) Purge(count int) (purged int, err error) {
+	s, ok := m.getStorage().(PurgeStorage)
+	if !ok {
+		return 0, fmt.Errorf("storage does not implement PurgeStorage")
+	}
+
+	for _, retention := range m.retention {
+		if retention.Result == "" {
+			return purged, fmt.Errorf("retention without result")
+		}
+
+		before := time.Now().Add(-retention.MaxAge)
+		if !retention.Before.IsZero() {
+			before = retention.Before
+		}
+
+		records, err := s.GetFinishedTransactions(retention.Result, before, count)
+		if err != nil {
+			return purged, fmt.Errorf("get finished transactions err: %v", err)
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		if m.archiver != nil {
+			txs, err := m.archived(s, records)
+			if err != nil {
+				return purged, err
+			}
+			if err := m.archiver.Archive(retention.Result, txs); err != nil {
+				return purged, fmt.Errorf("archive err: %v", err)
+			}
+		}
+
+		ids := make([]string, len(records))
+		for i, r := range records {
+			ids[i] = r.Transaction.ID
+		}
+		if err := s.DeleteTransactions(retention.Result, ids); err != nil {
+			return purged, fmt.Errorf("delete transactions err: %v", err)
+		}
+
+		purged += len(records)
+	}
+
+	return purged, nil
+}
+
+// archived returns the transactions of the records decoded with their partner results, to be archived.
+func (m *Manager) archived(s PurgeStorage, records []*TransactionRecord) ([]*ArchivedTransaction, error) {
+	q, ok := m.getStorage().(QueryStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not implement QueryStorage")
+	}
+
+	var txs []*ArchivedTransaction
+	for _, r := range records {
+		tx, _, err := q.GetTransaction(r.Transaction.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get transaction err: %w", err)
+		}
+		tx.CreatedAt = r.Transaction.CreatedAt
+
+		results, err := s.GetPartnerResults(tx.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get partner results err: %v", err)
+		}
+
+		txs = append(txs, &ArchivedTransaction{Transaction: tx, PartnerResults: results})
+	}
+
+	return txs, nil
+}
+
+// JSONLArchiver writes the transactions as JSON Lines, one transaction per line, e.g.
+//
+//	{"id":"1","result":"success","archived_at":"...","transaction":{"name":"...","normal_partners":[...]},"partner_results":[...]}
+//
+// The transactions are encoded by JSONCodec.
+type JSONLArchiver struct {
+	mu    sync.Mutex
+	w     io.Writer
+	codec JSONCodec
+}
+
+// jsonlRecord is a line written by JSONLArchiver.
+type jsonlRecord struct {
+	ID             string               `json:"id"`
+	Result         Result               `json:"result"`
+	ArchivedAt     time.Time            `json:"archived_at"`
+	Transaction    json.RawMessage      `json:"transaction"`
+	PartnerResults []jsonlPartnerResult `json:"partner_results"`
+}
+
+// jsonlPartnerResult is a partner result of a line written by JSONLArchiver.
+type jsonlPartnerResult struct {
+	Phase     string        `json:"phase"`
+	Offset    int           `json:"offset"`
+	Result    Result        `json:"result"`
+	Cost      time.Duration `json:"cost"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// NewJSONLArchiver returns a *JSONLArchiver writing to w, e.g. a file opened with os.O_APPEND.
+func NewJSONLArchiver(w io.Writer) *JSONLArchiver {
+	return &JSONLArchiver{w: w}
+}
+
+func (a *JSONLArchiver) Archive(result Result, txs []*ArchivedTransaction) error {
+	var lines []byte
+	now := time.Now()
+	for _, archived := range txs {
+		tx := archived.Transaction
+		content, err := a.codec.Marshal(tx)
+		if err != nil {
+			return fmt.Errorf("encode err: %v, %v", tx.ID, err)
+		}
+
+		r := &jsonlRecord{ID: tx.ID, Result: result, ArchivedAt: now, Transaction: json.RawMessage(content)}
+		for _, p := range archived.PartnerResults {
+			r.PartnerResults = append(r.PartnerResults, jsonlPartnerResult{Phase: p.Phase, Offset: p.Offset, Result: p.Result, Cost: p.Cost, UpdatedAt: p.UpdatedAt})
+		}
+
+		line, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("json encode err: %v, %v", tx.ID, err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.w.Write(lines); err != nil {
+		return fmt.Errorf("write err: %v", err)
+	}
+
+	return nil
+}
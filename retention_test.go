@@ -0,0 +1,149 @@
+package gtm_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func TestPurge(t *testing.T) {
+	var archive bytes.Buffer
+	m := gtm.NewManager(gtm.NewMemoryStorage()).
+		SetRetention(gtm.Retention{Result: gtm.Success}, gtm.Retention{Result: gtm.Fail, MaxAge: time.Hour}).
+		SetArchiver(gtm.NewJSONLArchiver(&archive))
+
+	success := m.New("test-purge").AddNormal(&Payer{OrderID: "100001"})
+	// The first execution of a successful transaction is finished by the retry.
+	if result, err := success.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if result, err := success.ExecuteRetry(); result != gtm.Success {
+		t.Fatalf("retry result = %v, err = %v, want success", result, err)
+	}
+	fail := m.New("test-purge").AddNormal(&Counter{Result: gtm.Fail})
+	if result, err := fail.Execute(); result != gtm.Fail {
+		t.Fatalf("result = %v, err = %v, want fail", result, err)
+	}
+
+	if n, err := m.Purge(10); n != 1 || err != nil {
+		t.Fatalf("Purge() = %v, %v, want 1", n, err)
+	}
+
+	q := m.Storage().(gtm.QueryStorage)
+	if _, _, err := q.GetTransaction(success.ID); err != gtm.ErrTransactionNotFound {
+		t.Errorf("GetTransaction() of the purged err = %v, want not found", err)
+	}
+	if _, result, err := q.GetTransaction(fail.ID); result != gtm.Fail {
+		t.Errorf("GetTransaction() of the retained = %v, %v, want fail", result, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(archive.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"id":"`+success.ID+`","result":"success"`) || !strings.Contains(lines[0], `"OrderID":"100001"`) {
+		t.Errorf("archive = %v, want the successful transaction", archive.String())
+	}
+	if !strings.Contains(lines[0], `"partner_results":[{"phase":"do-normal","offset":0,"result":"success"`) {
+		t.Errorf("archive = %v, want the partner results", archive.String())
+	}
+
+	if n, err := m.Purge(10); n != 0 || err != nil {
+		t.Errorf("Purge() again = %v, %v, want 0", n, err)
+	}
+}
+
+func TestSQLArchiver(t *testing.T) {
+	db := openSQLiteDB(t)
+	s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{})
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("migrate err: %v", err)
+	}
+
+	m := gtm.NewManager(s).
+		SetRetention(gtm.Retention{Result: gtm.Success}).
+		SetArchiver(gtm.NewSQLArchiver(db, gtm.SQLiteDialect{}))
+
+	tx := m.New("test-archive").AddNormal(&Payer{OrderID: "100001"})
+	// The first execution of a successful transaction is finished by the retry.
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Success {
+		t.Fatalf("retry result = %v, err = %v, want success", result, err)
+	}
+
+	// The content saved by the previous versions has no creation time.
+	legacy := &gtm.Transaction{Name: "test-archive"}
+	legacy.AddNormal(&Payer{OrderID: "100002"})
+	id, err := s.SaveTransaction(legacy)
+	if err != nil {
+		t.Fatalf("save err: %v", err)
+	}
+	legacy.ID = id
+	if err := s.SaveTransactionResult(legacy, 0, gtm.Success); err != nil {
+		t.Fatalf("save result err: %v", err)
+	}
+
+	if n, err := m.Purge(10); n != 2 || err != nil {
+		t.Fatalf("Purge() = %v, %v, want 2", n, err)
+	}
+
+	var name, result, codec, content string
+	row := db.QueryRow("SELECT name, result, codec, content FROM gtm_transactions_archive WHERE id=?", tx.ID)
+	if err := row.Scan(&name, &result, &codec, &content); err != nil {
+		t.Fatalf("query archive err: %v", err)
+	}
+	if name != "test-archive" || result != "success" || codec != "json" || !strings.Contains(content, `"OrderID":"100001"`) {
+		t.Errorf("archived = %v, %v, %v, %v, want the transaction in JSON", name, result, codec, content)
+	}
+
+	var createdAt time.Time
+	if err := db.QueryRow("SELECT created_at FROM gtm_transactions_archive WHERE id=?", legacy.ID).Scan(&createdAt); err != nil || createdAt.Before(time.Now().Add(-time.Hour)) {
+		t.Errorf("archived created_at of the legacy = %v, %v, want the saved time", createdAt, err)
+	}
+
+	var phase, partnerResult string
+	row = db.QueryRow("SELECT phase, result FROM gtm_partner_result_archive WHERE transaction_id=?", tx.ID)
+	if err := row.Scan(&phase, &partnerResult); err != nil || phase != "do-normal" || partnerResult != "success" {
+		t.Errorf("archived partner result = %v, %v, %v, want do-normal success", phase, partnerResult, err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM gtm_transactions").Scan(&count); err != nil || count != 0 {
+		t.Errorf("transactions after purge = %v, %v, want 0", count, err)
+	}
+}
+
+func TestPurgeUndecodable(t *testing.T) {
+	db := openSQLiteDB(t)
+	s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{})
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("migrate err: %v", err)
+	}
+	m := gtm.NewManager(s).SetRetention(gtm.Retention{Result: gtm.Success})
+
+	tx := &gtm.Transaction{Name: "test-purge-undecodable"}
+	tx.AddNormal(&Payer{OrderID: "100001"})
+	id, err := s.SaveTransaction(tx)
+	if err != nil {
+		t.Fatalf("save err: %v", err)
+	}
+	tx.ID = id
+	if err := s.SaveTransactionResult(tx, 0, gtm.Success); err != nil {
+		t.Fatalf("save result err: %v", err)
+	}
+	if _, err := db.Exec("UPDATE gtm_transactions SET content=? WHERE id=?", "undecodable", id); err != nil {
+		t.Fatalf("update err: %v", err)
+	}
+
+	// The transactions are not decoded without the archiver.
+	var decodeErr *gtm.DecodeError
+	if n, err := m.SetArchiver(gtm.NewSQLArchiver(db, gtm.SQLiteDialect{})).Purge(10); n != 0 || !errors.As(err, &decodeErr) {
+		t.Errorf("Purge() with the archiver = %v, %v, want the decode error", n, err)
+	}
+	if n, err := m.SetArchiver(nil).Purge(10); n != 1 || err != nil {
+		t.Errorf("Purge() = %v, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,218 @@
+package gtm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scheduler retries the timeout transactions of a manager in the background.
+// It polls the storage on an interval, and retries the transactions with a pool of workers.
+// When a batch is full, the next one is polled immediately.
+// It purges the finished transactions too if SetPurgeInterval is set.
+type Scheduler struct {
+	manager       *Manager
+	interval      time.Duration
+	batchSize     int
+	workers       int
+	purgeInterval time.Duration
+	onResult      func(tx *Transaction, result Result, err error)
+	onError       func(err error)
+
+	mu      sync.Mutex
+	running bool
+	stop    chan struct{}
+	done    chan struct{}
+	cancel  context.CancelFunc
+}
+
+// NewScheduler returns a Scheduler of the manager, which polls every second
+// at most 100 transactions, and retries them with 10 workers.
+func NewScheduler(m *Manager) *Scheduler {
+	return &Scheduler{
+		manager:   m,
+		interval:  time.Second,
+		batchSize: 100,
+		workers:   10,
+	}
+}
+
+// SetInterval sets the interval of polling the storage.
+func (s *Scheduler) SetInterval(interval time.Duration) *Scheduler {
+	s.interval = interval
+	return s
+}
+
+// SetBatchSize sets the maximum number of transactions of each poll.
+func (s *Scheduler) SetBatchSize(size int) *Scheduler {
+	s.batchSize = size
+	return s
+}
+
+// SetWorkers sets the number of transactions retried at the same time.
+func (s *Scheduler) SetWorkers(workers int) *Scheduler {
+	s.workers = workers
+	return s
+}
+
+// SetPurgeInterval sets the interval of purging the finished transactions by the retention of the manager,
+// at most the batch size of each result at a time. 0 disables purging, which is the default.
+func (s *Scheduler) SetPurgeInterval(interval time.Duration) *Scheduler {
+	s.purgeInterval = interval
+	return s
+}
+
+// OnResult sets the callback receiving the result of each retry.
+// It is called by the workers concurrently.
+func (s *Scheduler) OnResult(fn func(tx *Transaction, result Result, err error)) *Scheduler {
+	s.onResult = fn
+	return s
+}
+
+// OnError sets the callback receiving the errors of polling and purging the storage.
+func (s *Scheduler) OnError(fn func(err error)) *Scheduler {
+	s.onError = fn
+	return s
+}
+
+// Start starts polling in the background.
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return fmt.Errorf("scheduler is running")
+	}
+	if s.done != nil {
+		select {
+		case <-s.done:
+		default:
+			return fmt.Errorf("scheduler is stopping")
+		}
+	}
+	if s.interval <= 0 || s.batchSize <= 0 || s.workers <= 0 {
+		return fmt.Errorf("invalid scheduler: interval = %v, batch size = %v, workers = %v", s.interval, s.batchSize, s.workers)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.running = true
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	s.cancel = cancel
+
+	go s.loop(ctx)
+
+	return nil
+}
+
+// Stop stops polling and waits for the in-flight retries to finish.
+// If ctx is done first, the context of the in-flight retries is canceled
+// and ctx's error is returned without waiting for them any more.
+// The retries not finished will be retried later.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return fmt.Errorf("scheduler is not running")
+	}
+
+	s.running = false
+	close(s.stop)
+
+	select {
+	case <-s.done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	defer close(s.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var purge <-chan time.Time
+	if s.purgeInterval > 0 {
+		ticker := time.NewTicker(s.purgeInterval)
+		defer ticker.Stop()
+		purge = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-purge:
+			s.purge()
+			continue
+		case <-timer.C:
+		}
+
+		next := s.interval
+		if n := s.runBatch(ctx); n >= s.batchSize {
+			next = 0
+		}
+
+		timer.Reset(next)
+	}
+}
+
+// runBatch retries a batch of timeout transactions and waits for them.
+// Returns the number of transactions polled.
+func (s *Scheduler) runBatch(ctx context.Context) int {
+	transactions, err := s.manager.timeoutTransactions(s.batchSize)
+	if err != nil {
+		if s.onError != nil {
+			s.onError(err)
+		}
+		return 0
+	}
+
+	queue := make(chan *Transaction)
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.workers && i < len(transactions); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for tx := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				result, err := s.manager.retry(ctx, tx)
+				if s.onResult != nil {
+					s.onResult(tx, result, err)
+				}
+			}
+		}()
+	}
+
+	// Transactions not dispatched before stop are left to be retried later.
+dispatch:
+	for _, tx := range transactions {
+		select {
+		case queue <- tx:
+		case <-s.stop:
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return len(transactions)
+}
+
+// purge purges a batch of the finished transactions.
+func (s *Scheduler) purge() {
+	if _, err := s.manager.Purge(s.batchSize); err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
@@ -0,0 +1,84 @@
+package gtm_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+func TestScheduler(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage())
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		tx := m.New("test-scheduler").AddNormal(&Payer{OrderID: "100001", UserID: 20001, Amount: 99})
+		if err := tx.ExecuteAsync(); err != nil {
+			t.Fatalf("execute async err: %v", err)
+		}
+	}
+
+	results := make(chan gtm.Result, n)
+	s := gtm.NewScheduler(m).SetInterval(10 * time.Millisecond).SetBatchSize(2).SetWorkers(2)
+	s.OnResult(func(tx *gtm.Transaction, result gtm.Result, err error) {
+		results <- result
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("start err: %v", err)
+	}
+	if err := s.Start(); err == nil {
+		t.Errorf("start twice, want err")
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case result := <-results:
+			if result != gtm.Success {
+				t.Errorf("result = %v, want success", result)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %v results, want %v", i, n)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Errorf("stop err: %v", err)
+	}
+}
+
+func TestSchedulerPurge(t *testing.T) {
+	m := gtm.NewManager(gtm.NewMemoryStorage()).SetRetention(gtm.Retention{Result: gtm.Success})
+
+	tx := m.New("test-scheduler-purge").AddNormal(&Payer{OrderID: "100001", UserID: 20001, Amount: 99})
+	// The first execution of a successful transaction is finished by the retry.
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if result, err := tx.ExecuteRetry(); result != gtm.Success {
+		t.Fatalf("retry result = %v, err = %v, want success", result, err)
+	}
+
+	s := gtm.NewScheduler(m).SetPurgeInterval(10 * time.Millisecond)
+	s.OnError(func(err error) {
+		t.Errorf("scheduler err: %v", err)
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("start err: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, err := m.Storage().(gtm.QueryStorage).GetTransaction(tx.ID); err == gtm.ErrTransactionNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("transaction is not purged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,9 +1,14 @@
 package gtm
 
 import (
+	"errors"
+	"sort"
+	"strconv"
 	"time"
 )
 
+// Storage is used to persist transactions and the results of their partners.
+// The context of the current execution is available through tx.Context().
 type Storage interface {
 	// Save the transaction data.
 	// Must be reliable.
@@ -27,3 +32,185 @@ type Storage interface {
 	// Return transactions to be retried.
 	GetTimeoutTransactions(count int) ([]*Transaction, error)
 }
+
+// ClaimStorage is an optional interface of Storage for several retry workers.
+// If the storage implements it, the manager claims the timeout transactions instead of getting them,
+// so that a transaction is never retried by two workers at the same time.
+type ClaimStorage interface {
+	// Claim at most count timeout transactions in one atomic operation,
+	// by pushing their retry time to now + lease and recording the owner token.
+	// The claimed transactions are not returned to others until the lease expires.
+	ClaimTimeoutTransactions(owner string, count int, lease time.Duration) ([]*Transaction, error)
+}
+
+// ErrTransactionNotFound is returned by QueryStorage.GetTransaction if the transaction does not exist.
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// QueryStorage is an optional interface of Storage for finding transactions,
+// used by the APIs operating the saved transactions, such as Requeue.
+type QueryStorage interface {
+	// Return the transaction and its result, "" if it is not finished.
+	// Return ErrTransactionNotFound if it does not exist.
+	GetTransaction(id string) (*Transaction, Result, error)
+
+	// Return at most count transactions of the result, in the order of ID.
+	GetTransactionsByResult(result Result, count int) ([]*Transaction, error)
+}
+
+// ResetStorage is an optional interface of Storage for retrying a finished transaction again.
+type ResetStorage interface {
+	// Save the transaction again with its Times and RetryAt, and clear its result,
+	// so that it is returned by GetTimeoutTransactions. The partner results are kept.
+	ResetTransaction(tx *Transaction) error
+}
+
+// UpgradeStorage is an optional interface of Storage for saving the partners upgraded by RegisterUpgrade,
+// so that they are not upgraded again by every retry.
+type UpgradeStorage interface {
+	// Save the content of the transaction again, its Times, RetryAt, result and lease are kept.
+	UpdateTransactionContent(tx *Transaction) error
+}
+
+// ResolutionStorage is an optional interface of Storage for recording the manual interventions,
+// such as Suspend and ForceSuccess.
+type ResolutionStorage interface {
+	// Save a resolution of the transaction.
+	// The resolutions should be kept even if the transaction is deleted.
+	SaveResolution(tx *Transaction, resolution *Resolution) error
+
+	// Return the resolutions of the transaction in the order of time.
+	GetResolutions(id string) ([]*Resolution, error)
+}
+
+// PurgeStorage is an optional interface of Storage for deleting the finished transactions, used by Purge.
+type PurgeStorage interface {
+	// Return at most count transactions of the result finished before the time, in the order of ID.
+	// The time a transaction is finished is the last time it is updated.
+	// The content is not decoded, so that the transactions are purged even if their partners are not registered:
+	// only ID, Name, Times, RetryAt and CreatedAt of the transactions are set, the partners are nil.
+	GetFinishedTransactions(result Result, before time.Time, count int) ([]*TransactionRecord, error)
+
+	// Return the saved results of the partners of the transaction, in the order of phase and offset,
+	// which are archived with the transaction.
+	GetPartnerResults(id string) ([]*PartnerResultRecord, error)
+
+	// Delete the transactions which still have the result, and their partner results.
+	// The transactions changed in the meantime, e.g. requeued, are kept. The resolutions are kept.
+	DeleteTransactions(result Result, ids []string) error
+}
+
+// CountStorage is an optional interface of Storage for the backlog of the retries, used by Metrics.
+type CountStorage interface {
+	// Return the number of the unfinished transactions whose retry time has passed.
+	CountTimeoutTransactions() (int, error)
+}
+
+// TransactionFilter selects the transactions listed by InspectStorage.
+// The zero values do not filter.
+type TransactionFilter struct {
+	Name string
+
+	// Results of the transactions, "" for the unfinished ones.
+	Results []Result
+
+	// CreatedAfter and CreatedBefore limit the creation time, inclusive and exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// AfterID lists the transactions after the ID, the last ID of the previous page.
+	AfterID string
+
+	// Count is the maximum number of the transactions listed, 0 lists all of them.
+	Count int
+}
+
+// TransactionRecord is a saved transaction with its state in the storage.
+type TransactionRecord struct {
+	Transaction *Transaction
+	Result      Result
+	Cost        time.Duration
+	UpdatedAt   time.Time
+}
+
+// PartnerResultRecord is a saved result of a partner at a phase.
+// Cost and UpdatedAt are zero if the storage does not save them.
+type PartnerResultRecord struct {
+	Phase     string
+	Offset    int
+	Result    Result
+	Cost      time.Duration
+	UpdatedAt time.Time
+}
+
+// InspectStorage is an optional interface of Storage for inspecting the saved transactions,
+// used by the operation tools such as AdminHandler.
+type InspectStorage interface {
+	// Return the transactions matching the filter, in the order of ID.
+	ListTransactions(filter TransactionFilter) ([]*TransactionRecord, error)
+
+	// Return the saved results of the partners of the transaction, in the order of phase and offset.
+	GetPartnerResults(id string) ([]*PartnerResultRecord, error)
+}
+
+// TransactionCount is the number of the saved transactions of a name and a result.
+type TransactionCount struct {
+	Name   string
+	Result Result
+	Count  int
+}
+
+// SummaryStorage is an optional interface of Storage for inspecting the saved transactions without decoding their content,
+// so that the types of the partners need not be registered, used by the gtm command.
+type SummaryStorage interface {
+	// Return the transactions matching the filter, in the order of ID, as ListTransactions of InspectStorage.
+	// Only ID, Name, Times, RetryAt and CreatedAt of the transactions are set, the partners are nil.
+	ListTransactionSummaries(filter TransactionFilter) ([]*TransactionRecord, error)
+
+	// Return the numbers of the transactions by name and result, in the order of name and result.
+	CountTransactions() ([]*TransactionCount, error)
+}
+
+// Match reports whether the transaction of the result matches the filter except Count,
+// for the storages filtering in memory. The IDs are compared as numbers.
+func (f TransactionFilter) Match(tx *Transaction, result Result) bool {
+	if f.Name != "" && tx.Name != f.Name {
+		return false
+	}
+
+	if len(f.Results) > 0 {
+		found := false
+		for _, r := range f.Results {
+			found = found || r == result
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !f.CreatedAfter.IsZero() && tx.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !tx.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+
+	if f.AfterID != "" {
+		after, _ := strconv.ParseInt(f.AfterID, 10, 64)
+		id, _ := strconv.ParseInt(tx.ID, 10, 64)
+		if id <= after {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SortPartnerResults sorts the partner results in the order of phase and offset.
+func SortPartnerResults(records []*PartnerResultRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Phase != records[j].Phase {
+			return records[i].Phase < records[j].Phase
+		}
+		return records[i].Offset < records[j].Offset
+	})
+}
@@ -1,9 +1,6 @@
 package gtm
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/gob"
 	"fmt"
 	"strconv"
 	"time"
@@ -12,35 +9,82 @@ import (
 )
 
 // DBStorage is a GTM Storage implementation using DB.
-// It depends on a gorm.DB.
+// It depends on a gorm.DB of MySQL, PostgreSQL or SQLite.
 type DBStorage struct {
-	db *gorm.DB
+	db      *gorm.DB
+	dialect Dialect
+	codec   Codec
+	logger  Logger
 }
 
+var (
+	_ ClaimStorage      = &DBStorage{}
+	_ QueryStorage      = &DBStorage{}
+	_ ResetStorage      = &DBStorage{}
+	_ ResolutionStorage = &DBStorage{}
+	_ UpgradeStorage    = &DBStorage{}
+	_ PurgeStorage      = &DBStorage{}
+	_ CountStorage      = &DBStorage{}
+	_ InspectStorage    = &DBStorage{}
+	_ SummaryStorage    = &DBStorage{}
+)
+
 // NewDBStorage returns a *DBStorage and needs to be injected into the gorm.DB.
 func NewDBStorage(db *gorm.DB) *DBStorage {
-	return &DBStorage{db: db}
-}
-
-/*
-DROP TABLE gtm_transactions;
-
-CREATE TABLE gtm_transactions (
-	id         bigint UNSIGNED NOT NULL AUTO_INCREMENT,
-	name       varchar(50) NOT NULL,
-	times      int UNSIGNED NOT NULL,
-	retry_at   timestamp NOT NULL,
-	timeout    int UNSIGNED NOT NULL,
-	result     enum('success', 'fail', '') NOT NULL,
-	cost       bigint UNSIGNED NOT NULL,
-	content    mediumtext,
-	created_at timestamp NOT NULL,
-	updated_at timestamp NOT NULL,
-
-	PRIMARY KEY (id),
-	KEY idx_retry (result, retry_at)
-);
-*/
+	return &DBStorage{db: db, dialect: dbDialect(db), codec: GobCodec{}}
+}
+
+// dbDialect returns the Dialect of the gorm dialect, or nil if it is not supported.
+func dbDialect(db *gorm.DB) Dialect {
+	switch db.Dialect().GetName() {
+	case "mysql":
+		return MySQLDialect{}
+	case "postgres":
+		return PostgreSQLDialect{}
+	case "sqlite3":
+		return SQLiteDialect{}
+	default:
+		return nil
+	}
+}
+
+// getDialect returns the Dialect of the storage, or an error if the gorm dialect is not supported.
+func (s *DBStorage) getDialect() (Dialect, error) {
+	if s.dialect == nil {
+		return nil, fmt.Errorf("unsupported dialect: %v", s.db.Dialect().GetName())
+	}
+	return s.dialect, nil
+}
+
+// SetCodec sets the codec encoding the transactions, GobCodec by default.
+// The transactions saved by other codecs are still decoded by the registered codecs.
+func (s *DBStorage) SetCodec(c Codec) *DBStorage {
+	s.codec = c
+	return s
+}
+
+// SetLogger sets the logger of the migrations, the claims and the transactions skipped as they can not be decoded,
+// nil disables logging.
+func (s *DBStorage) SetLogger(l Logger) *DBStorage {
+	s.logger = l
+	return s
+}
+
+// Migrate creates the tables of the storage, or upgrades the tables created by the previous versions.
+// The tables are the same as SQLStorage, created by the Dialect of the gorm dialect,
+// and the applied versions are recorded in the table gtm_schema_version.
+// It returns an error if the live schema is incompatible, or is migrated by a newer version of GTM.
+// Run it from one process at a time, e.g. when the service is deployed.
+func (s *DBStorage) Migrate() error {
+	d, err := s.getDialect()
+	if err != nil {
+		return err
+	}
+
+	return migrate(s.db.DB(), d, s.logger)
+}
+
+// DBStorageTransaction is a row of gtm_transactions, see MySQLDialect.CreateTables() for the schema.
 type DBStorageTransaction struct {
 	ID        int
 	Name      string
@@ -50,6 +94,7 @@ type DBStorageTransaction struct {
 	Result    string
 	Cost      time.Duration
 	Content   string
+	Codec     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -58,23 +103,7 @@ func (*DBStorageTransaction) TableName() string {
 	return "gtm_transactions"
 }
 
-/*
-DROP TABLE gtm_partner_result;
-
-CREATE TABLE gtm_partner_result (
-	id              bigint UNSIGNED NOT NULL AUTO_INCREMENT,
-	transaction_id  bigint UNSIGNED NOT NULL,
-	phase           varchar(20) NOT NULL,
-	offset          tinyint UNSIGNED NOT NULL,
-	result          enum('success', 'fail', 'uncertain') NOT NULL,
-	cost            bigint UNSIGNED NOT NULL,
-	created_at      timestamp NOT NULL,
-	updated_at      timestamp NOT NULL,
-
-	PRIMARY KEY (id),
-	UNIQUE KEY uni_tx_id (transaction_id, phase, offset)
-);
-*/
+// DBStoragePartnerResult is a row of gtm_partner_result, see MySQLDialect.CreateTables() for the schema.
 type DBStoragePartnerResult struct {
 	ID            int
 	TransactionID int
@@ -90,10 +119,24 @@ func (*DBStoragePartnerResult) TableName() string {
 	return "gtm_partner_result"
 }
 
+// DBStorageResolution is a row of gtm_resolution, see MySQLDialect.CreateTables() for the schema.
+type DBStorageResolution struct {
+	ID            int
+	TransactionID int
+	Action        string
+	Operator      string
+	Reason        string
+	CreatedAt     time.Time
+}
+
+func (*DBStorageResolution) TableName() string {
+	return "gtm_resolution"
+}
+
 // SaveTransaction save transaction data to db.
 func (s *DBStorage) SaveTransaction(tx *Transaction) (id string, err error) {
-	var content string
-	if content, err = s.Encode(tx); err != nil {
+	codec, content, err := encodeTransaction(s.codec, tx)
+	if err != nil {
 		return "", fmt.Errorf("encode err: %v", err)
 	}
 
@@ -103,9 +146,12 @@ func (s *DBStorage) SaveTransaction(tx *Transaction) (id string, err error) {
 		RetryAt: tx.RetryAt,
 		Timeout: int(tx.Timeout.Seconds()),
 		Content: content,
+		Codec:   codec,
 	}
 
-	if err := s.db.Create(&data).Error; err != nil {
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		return db.Create(&data).Error
+	}); err != nil {
 		return "", fmt.Errorf("db create failed: %v", err)
 	}
 
@@ -114,10 +160,12 @@ func (s *DBStorage) SaveTransaction(tx *Transaction) (id string, err error) {
 
 // SaveTransactionResult save transaction results to db.
 func (s *DBStorage) SaveTransactionResult(tx *Transaction, cost time.Duration, result Result) error {
-	if err := s.db.Model(DBStorageTransaction{}).Where("id=?", tx.ID).Update(map[string]interface{}{
-		"cost":   int64(cost),
-		"result": result,
-	}).Error; err != nil {
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		return db.Model(DBStorageTransaction{}).Where("id=?", tx.ID).Update(map[string]interface{}{
+			"cost":   int64(cost),
+			"result": result,
+		}).Error
+	}); err != nil {
 		return fmt.Errorf("update err: %v", err)
 	}
 
@@ -125,32 +173,48 @@ func (s *DBStorage) SaveTransactionResult(tx *Transaction, cost time.Duration, r
 }
 
 // SavePartnerResult save the result of a phase of partner to db.
+// The previous result of the same phase and offset is replaced.
 func (s *DBStorage) SavePartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result) error {
 	txID, err := strconv.Atoi(tx.ID)
 	if err != nil {
 		return fmt.Errorf("strconv id err: %v", err)
 	}
 
-	data := DBStoragePartnerResult{
-		TransactionID: txID,
-		Phase:         phase,
-		Offset:        offset,
-		Cost:          cost,
-		Result:        string(result),
+	d, err := s.getDialect()
+	if err != nil {
+		return err
 	}
 
-	if err := s.db.Create(&data).Error; err != nil {
-		return fmt.Errorf("db create failed: %v", err)
+	query := d.Upsert("gtm_partner_result",
+		[]string{"transaction_id", "phase", "offset", "result", "cost", "created_at", "updated_at"},
+		[]string{"transaction_id", "phase", "offset"},
+		[]string{"result", "cost", "updated_at"},
+	)
+
+	now := time.Now()
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		_, err := db.CommonDB().Exec(query, txID, phase, offset, string(result), int64(cost), now, now)
+		return err
+	}); err != nil {
+		return fmt.Errorf("db upsert failed: %v", err)
 	}
 
 	return nil
 }
 
-// GetPartnerResult returns the execution result of a partner.
+// GetPartnerResult returns the execution result of a partner, or "" if it is not saved.
 func (s *DBStorage) GetPartnerResult(tx *Transaction, phase string, offset int) (Result, error) {
+	d, err := s.getDialect()
+	if err != nil {
+		return "", err
+	}
+
 	var row DBStoragePartnerResult
-	if err := s.db.Where("transaction_id=? AND phase=? AND offset=?", tx.ID, phase, offset).
-		Find(&row).Error; err != nil {
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		return db.Where(rebind(d, "{transaction_id}=? AND {phase}=? AND {offset}=?"), tx.ID, phase, offset).Find(&row).Error
+	}); gorm.IsRecordNotFoundError(err) {
+		return "", nil
+	} else if err != nil {
 		return "", fmt.Errorf("find err: %v", err)
 	}
 
@@ -164,62 +228,389 @@ func (s *DBStorage) UpdateTransactionRetryTime(tx *Transaction, times int, newRe
 		"retry_at": newRetryTime,
 	}
 
-	if err := s.db.Model(DBStorageTransaction{}).Where("id=?", tx.ID).Update(data).Error; err != nil {
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		return db.Model(DBStorageTransaction{}).Where("id=?", tx.ID).Update(data).Error
+	}); err != nil {
 		return fmt.Errorf("update err: %v", err)
 	}
 
 	return nil
 }
 
-// GetTimeoutTransactions returns all transactions that require timeout retry.
+// GetTimeoutTransactions returns all transactions that require timeout retry, in the order of retry time.
 func (s *DBStorage) GetTimeoutTransactions(count int) (txs []*Transaction, err error) {
 	var rows []DBStorageTransaction
-	err = s.db.Where("result=? AND retry_at<?", "", time.Now()).Limit(count).Find(&rows).Error
+	err = s.db.Where("result=? AND retry_at<?", "", time.Now()).Order("retry_at").Limit(count).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	return s.decodeRows(rows), nil
+}
+
+// CountTimeoutTransactions returns the number of the transactions to be retried.
+func (s *DBStorage) CountTimeoutTransactions() (count int, err error) {
+	err = s.db.Model(&DBStorageTransaction{}).Where("result=? AND retry_at<?", "", time.Now()).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("count err: %v", err)
+	}
+
+	return count, nil
+}
+
+// ClaimTimeoutTransactions claims at most count timeout transactions for the owner in one UPDATE,
+// their retry time is pushed to now + lease. It requires the lease_owner column.
+func (s *DBStorage) ClaimTimeoutTransactions(owner string, count int, lease time.Duration) (txs []*Transaction, err error) {
+	d, err := s.getDialect()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	query := rebind(d, d.UpdateFirst("{gtm_transactions}", "{retry_at}=?, {lease_owner}=?", "{result}=? AND {retry_at}<?", "{retry_at}", "?"))
+	claim, err := s.db.CommonDB().Exec(query, now.Add(lease), owner, "", now, count)
+	if err != nil {
+		return nil, fmt.Errorf("claim err: %v", err)
+	}
+	if claimed, err := claim.RowsAffected(); err == nil {
+		logTo(s.logger, LevelDebug, "transactions claimed", "owner", owner, "count", claimed, "lease", lease)
+	}
+
+	var rows []DBStorageTransaction
+	if err := s.db.Where("lease_owner=? AND result=?", owner, "").Order("id").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	return s.decodeRows(rows), nil
+}
+
+// GetTransaction returns the transaction and its result.
+func (s *DBStorage) GetTransaction(id string) (*Transaction, Result, error) {
+	var row DBStorageTransaction
+	if err := s.db.Where("id=?", id).Find(&row).Error; gorm.IsRecordNotFoundError(err) {
+		return nil, "", ErrTransactionNotFound
+	} else if err != nil {
+		return nil, "", fmt.Errorf("find err: %v", err)
+	}
+
+	tx, err := s.decodeRow(row)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tx, Result(row.Result), nil
+}
+
+// GetTransactionsByResult returns at most count transactions of the result, in the order of ID.
+func (s *DBStorage) GetTransactionsByResult(result Result, count int) (txs []*Transaction, err error) {
+	var rows []DBStorageTransaction
+	if err := s.db.Where("result=?", result).Order("id").Limit(count).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	return s.decodeRows(rows), nil
+}
+
+// GetFinishedTransactions returns at most count transactions of the result updated before the time without the partners,
+// in the order of ID. The content is not selected.
+func (s *DBStorage) GetFinishedTransactions(result Result, before time.Time, count int) (records []*TransactionRecord, err error) {
+	var rows []DBStorageTransaction
+	db := s.db.Select("id, name, times, retry_at, result, cost, created_at, updated_at")
+	if err := db.Where("result=? AND updated_at<?", result, before).Order("id").Limit(count).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	for _, row := range rows {
+		tx := &Transaction{ID: strconv.Itoa(row.ID), Name: row.Name, Times: row.Times, RetryAt: row.RetryAt, CreatedAt: row.CreatedAt}
+		records = append(records, &TransactionRecord{Transaction: tx, Result: Result(row.Result), Cost: row.Cost, UpdatedAt: row.UpdatedAt})
+	}
+
+	return records, nil
+}
+
+// GetPartnerResults returns the saved results of the partners of the transaction, in the order of phase and offset.
+func (s *DBStorage) GetPartnerResults(id string) (records []*PartnerResultRecord, err error) {
+	d, err := s.getDialect()
 	if err != nil {
+		return nil, err
+	}
+
+	var rows []DBStoragePartnerResult
+	if err := s.db.Where("transaction_id=?", id).Order(rebind(d, "{phase}, {offset}")).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	for _, row := range rows {
+		records = append(records, &PartnerResultRecord{Phase: row.Phase, Offset: row.Offset, Result: Result(row.Result), Cost: row.Cost, UpdatedAt: row.UpdatedAt})
+	}
+
+	return records, nil
+}
+
+// ListTransactions returns the transactions matching the filter, in the order of ID.
+func (s *DBStorage) ListTransactions(filter TransactionFilter) (records []*TransactionRecord, err error) {
+	var rows []DBStorageTransaction
+	db := s.filter(filter).Order("id")
+	if filter.Count > 0 {
+		db = db.Limit(filter.Count)
+	}
+	if err := db.Find(&rows).Error; err != nil {
 		return nil, fmt.Errorf("find err: %v", err)
 	}
 
 	for _, row := range rows {
-		tx, err := s.Decode(row.Content)
+		tx, err := s.decodeRow(row)
 		if err != nil {
-			return nil, fmt.Errorf("tx decode err: %v", err)
+			logTo(s.logger, LevelError, "transaction skipped", "id", row.ID, "err", err)
+			continue
 		}
 
-		tx.ID = strconv.Itoa(row.ID)
-		tx.Times = row.Times
-		tx.RetryAt = row.RetryAt
+		tx.CreatedAt = row.CreatedAt
+		records = append(records, &TransactionRecord{Transaction: tx, Result: Result(row.Result), Cost: row.Cost, UpdatedAt: row.UpdatedAt})
+	}
 
-		txs = append(txs, tx)
+	return records, nil
+}
+
+// ListTransactionSummaries returns the transactions matching the filter without the partners, in the order of ID.
+// The content is not selected.
+func (s *DBStorage) ListTransactionSummaries(filter TransactionFilter) (records []*TransactionRecord, err error) {
+	var rows []DBStorageTransaction
+	db := s.filter(filter).Select("id, name, times, retry_at, result, cost, created_at, updated_at").Order("id")
+	if filter.Count > 0 {
+		db = db.Limit(filter.Count)
+	}
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	for _, row := range rows {
+		tx := &Transaction{ID: strconv.Itoa(row.ID), Name: row.Name, Times: row.Times, RetryAt: row.RetryAt, CreatedAt: row.CreatedAt}
+		records = append(records, &TransactionRecord{Transaction: tx, Result: Result(row.Result), Cost: row.Cost, UpdatedAt: row.UpdatedAt})
 	}
 
-	return txs, nil
+	return records, nil
 }
 
-func (s *DBStorage) Register(values ...interface{}) {
-	for _, value := range values {
-		gob.Register(value)
+// CountTransactions returns the numbers of the transactions by name and result, in the order of name and result.
+func (s *DBStorage) CountTransactions() (counts []*TransactionCount, err error) {
+	var rows []struct {
+		Name   string
+		Result string
+		Total  int
+	}
+	err = s.db.Model(&DBStorageTransaction{}).Select("name, result, COUNT(*) AS total").Group("name, result").Order("name, result").Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("count err: %v", err)
+	}
+
+	for _, row := range rows {
+		counts = append(counts, &TransactionCount{Name: row.Name, Result: Result(row.Result), Count: row.Total})
 	}
+
+	return counts, nil
 }
 
-func (s *DBStorage) Encode(tx *Transaction) (string, error) {
-	var buffer bytes.Buffer
-	if err := gob.NewEncoder(&buffer).Encode(tx); err != nil {
-		return "", fmt.Errorf("gob encode err: %v", err)
+// filter returns the db selecting the transactions matching the filter except Count.
+func (s *DBStorage) filter(filter TransactionFilter) *gorm.DB {
+	db := s.db
+	if filter.Name != "" {
+		db = db.Where("name=?", filter.Name)
+	}
+	if len(filter.Results) > 0 {
+		db = db.Where("result IN (?)", filter.Results)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		db = db.Where("created_at>=?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		db = db.Where("created_at<?", filter.CreatedBefore)
+	}
+	if filter.AfterID != "" {
+		db = db.Where("id>?", filter.AfterID)
 	}
 
-	return base64.StdEncoding.EncodeToString(buffer.Bytes()), nil
+	return db
 }
 
-func (s *DBStorage) Decode(content string) (*Transaction, error) {
-	data, err := base64.StdEncoding.DecodeString(content)
+// DeleteTransactions deletes the transactions which still have the result,
+// then the partner results of the transactions deleted.
+func (s *DBStorage) DeleteTransactions(result Result, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := s.db.Where("result=? AND id IN (?)", result, ids).Delete(&DBStorageTransaction{}).Error; err != nil {
+		return fmt.Errorf("delete transactions err: %v", err)
+	}
+
+	err := s.db.Exec("DELETE FROM gtm_partner_result WHERE transaction_id IN (?) AND transaction_id NOT IN (SELECT id FROM gtm_transactions WHERE id IN (?))", ids, ids).Error
 	if err != nil {
-		return nil, fmt.Errorf("base64 decode err :%v", err)
+		return fmt.Errorf("delete partner results err: %v", err)
+	}
+
+	return nil
+}
+
+// ResetTransaction saves the transaction again and clears its result.
+func (s *DBStorage) ResetTransaction(tx *Transaction) error {
+	codec, content, err := encodeTransaction(s.codec, tx)
+	if err != nil {
+		return fmt.Errorf("encode err: %v", err)
+	}
+
+	var updated int64
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		db = db.Model(DBStorageTransaction{}).Where("id=?", tx.ID).Update(map[string]interface{}{
+			"times":       tx.Times,
+			"retry_at":    tx.RetryAt,
+			"content":     content,
+			"codec":       codec,
+			"result":      "",
+			"cost":        0,
+			"lease_owner": "",
+		})
+		updated = db.RowsAffected
+		return db.Error
+	}); err != nil {
+		return fmt.Errorf("update err: %v", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	return nil
+}
+
+// UpdateTransactionContent saves the content of the transaction again.
+func (s *DBStorage) UpdateTransactionContent(tx *Transaction) error {
+	codec, content, err := encodeTransaction(s.codec, tx)
+	if err != nil {
+		return fmt.Errorf("encode err: %v", err)
+	}
+
+	var updated int64
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		db = db.Model(DBStorageTransaction{}).Where("id=?", tx.ID).Update(map[string]interface{}{
+			"content": content,
+			"codec":   codec,
+		})
+		updated = db.RowsAffected
+		return db.Error
+	}); err != nil {
+		return fmt.Errorf("update err: %v", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	return nil
+}
+
+// SaveResolution saves a resolution of the transaction.
+func (s *DBStorage) SaveResolution(tx *Transaction, resolution *Resolution) error {
+	txID, err := strconv.Atoi(tx.ID)
+	if err != nil {
+		return fmt.Errorf("strconv id err: %v", err)
+	}
+
+	data := DBStorageResolution{
+		TransactionID: txID,
+		Action:        resolution.Action,
+		Operator:      resolution.Operator,
+		Reason:        resolution.Reason,
+		CreatedAt:     resolution.CreatedAt,
+	}
+
+	if err := s.withContext(tx, func(db *gorm.DB) error {
+		return db.Create(&data).Error
+	}); err != nil {
+		return fmt.Errorf("db create failed: %v", err)
+	}
+
+	return nil
+}
+
+// GetResolutions returns the resolutions of the transaction in the order of time.
+func (s *DBStorage) GetResolutions(id string) (resolutions []*Resolution, err error) {
+	var rows []DBStorageResolution
+	if err := s.db.Where("transaction_id=?", id).Order("created_at, id").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("find err: %v", err)
+	}
+
+	for _, row := range rows {
+		resolutions = append(resolutions, &Resolution{
+			Action:    row.Action,
+			Operator:  row.Operator,
+			Reason:    row.Reason,
+			CreatedAt: row.CreatedAt,
+		})
+	}
+
+	return resolutions, nil
+}
+
+// decodeRows decodes the transactions of the rows.
+// The ones which can not be decoded are skipped and logged, so that they do not block the others.
+func (s *DBStorage) decodeRows(rows []DBStorageTransaction) (txs []*Transaction) {
+	for _, row := range rows {
+		tx, err := s.decodeRow(row)
+		if err != nil {
+			logTo(s.logger, LevelError, "transaction skipped", "id", row.ID, "err", err)
+			continue
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
+
+// decodeRow decodes the transaction of the row, it returns a DecodeError if it can not be decoded.
+func (s *DBStorage) decodeRow(row DBStorageTransaction) (*Transaction, error) {
+	tx, err := decodeTransaction(s.codec, row.Codec, row.Content)
+	if err != nil {
+		return nil, NewDecodeError(strconv.Itoa(row.ID), err)
+	}
+
+	tx.ID = strconv.Itoa(row.ID)
+	tx.Times = row.Times
+	tx.RetryAt = row.RetryAt
+
+	return tx, nil
+}
+
+// Register records the types of partners for decoding, as gtm.Register.
+func (s *DBStorage) Register(values ...interface{}) {
+	Register(values...)
+}
+
+// Encode encodes the transaction with the codec of the storage.
+func (s *DBStorage) Encode(tx *Transaction) (string, error) {
+	_, content, err := encodeTransaction(s.codec, tx)
+	return content, err
+}
+
+// Decode decodes the content encoded by the codec of the storage.
+func (s *DBStorage) Decode(content string) (*Transaction, error) {
+	return decodeTransaction(s.codec, s.codec.Name(), content)
+}
+
+// withContext runs fn with the db in a database transaction bound to the context of tx,
+// as the statements of gorm v1 take no context, so that they are canceled with it.
+// The db is used directly if it is a transaction already.
+func (s *DBStorage) withContext(tx *Transaction, fn func(db *gorm.DB) error) error {
+	db := s.db.BeginTx(tx.Context(), nil)
+	if db.Error == gorm.ErrCantStartTransaction {
+		return fn(s.db)
+	} else if db.Error != nil {
+		return fmt.Errorf("db begin err: %v", db.Error)
 	}
 
-	var tx Transaction
-	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil {
-		return nil, fmt.Errorf("gob decode err: %v", err)
+	if err := fn(db); err != nil {
+		db.Rollback()
+		return err
 	}
 
-	return &tx, nil
+	return db.Commit().Error
 }
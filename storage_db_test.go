@@ -1,9 +1,108 @@
 package gtm_test
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/gtmtest"
 )
 
 var (
 	_ gtm.Storage = &gtm.DBStorage{}
 )
+
+// TestDBStorage runs the storage suite on the MySQL of GTM_MYSQL_DSN,
+// e.g. root:root1234@/gtm?charset=utf8&parseTime=True&loc=Local.
+// The tables are migrated, and truncated before each test.
+func TestDBStorage(t *testing.T) {
+	dsn := os.Getenv("GTM_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("GTM_MYSQL_DSN is not set")
+	}
+
+	db, err := gorm.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("db open failed: %v", err)
+	}
+	defer db.Close()
+
+	if err := gtm.NewDBStorage(db).Migrate(); err != nil {
+		t.Fatalf("migrate err: %v", err)
+	}
+
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		for _, table := range []string{"gtm_transactions", "gtm_partner_result", "gtm_resolution"} {
+			if err := db.Exec("TRUNCATE TABLE " + table).Error; err != nil {
+				t.Fatalf("truncate %v err: %v", table, err)
+			}
+		}
+
+		return gtm.NewDBStorage(db)
+	})
+}
+
+// TestDBStorageSQLite runs the storage suite on SQLite, a new database for each test.
+func TestDBStorageSQLite(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		s := gtm.NewDBStorage(openGormSQLite(t))
+		if err := s.Migrate(); err != nil {
+			t.Fatalf("migrate err: %v", err)
+		}
+
+		return s
+	})
+}
+
+func openGormSQLite(t *testing.T) *gorm.DB {
+	dir, err := ioutil.TempDir("", "gtm-gorm")
+	if err != nil {
+		t.Fatalf("temp dir err: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "gtm.db"))
+	if err != nil {
+		t.Fatalf("db open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestDBStorageMigrate(t *testing.T) {
+	db := openGormSQLite(t)
+	s := gtm.NewDBStorage(db)
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(); err != nil {
+			t.Fatalf("Migrate() err = %v", err)
+		}
+	}
+
+	var count int
+	if err := db.Table("gtm_schema_version").Count(&count).Error; err != nil || count != gtm.SchemaVersion() {
+		t.Errorf("schema versions = %v, %v, want %v", count, err, gtm.SchemaVersion())
+	}
+}
+
+func TestDBStorageContext(t *testing.T) {
+	s := gtm.NewDBStorage(openGormSQLite(t))
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("migrate err: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tx := gtm.NewManager(s).New("test-db-context").AddNormal(&Payer{OrderID: "100001"})
+	if result, err := tx.ExecuteContext(ctx); result != gtm.Fail || err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("result = %v, err = %v, want fail of the canceled context", result, err)
+	}
+}
@@ -0,0 +1,478 @@
+package gtm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ ClaimStorage      = &MemoryStorage{}
+	_ QueryStorage      = &MemoryStorage{}
+	_ ResetStorage      = &MemoryStorage{}
+	_ ResolutionStorage = &MemoryStorage{}
+	_ UpgradeStorage    = &MemoryStorage{}
+	_ PurgeStorage      = &MemoryStorage{}
+	_ CountStorage      = &MemoryStorage{}
+	_ InspectStorage    = &MemoryStorage{}
+	_ SummaryStorage    = &MemoryStorage{}
+)
+
+// MemoryStorage is a GTM Storage implementation in memory.
+// It is safe for concurrent use, and suitable for tests and single-process tools.
+// The zero value is an empty storage ready to use.
+// All data is lost when the process exits.
+type MemoryStorage struct {
+	mu           sync.Mutex
+	lastID       int
+	transactions map[string]*memoryTransaction
+	partners     map[string]PartnerResultRecord
+	resolutions  map[string][]Resolution
+}
+
+type memoryTransaction struct {
+	seq       int
+	tx        *Transaction
+	owner     string
+	result    Result
+	cost      time.Duration
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// NewMemoryStorage returns an empty *MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// init makes the maps of a zero MemoryStorage, s.mu must be held.
+func (s *MemoryStorage) init() {
+	if s.transactions == nil {
+		s.transactions = make(map[string]*memoryTransaction)
+		s.partners = make(map[string]PartnerResultRecord)
+		s.resolutions = make(map[string][]Resolution)
+	}
+}
+
+// SaveTransaction saves a copy of the transaction, the ID is an auto-increment number.
+func (s *MemoryStorage) SaveTransaction(tx *Transaction) (id string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	s.lastID++
+	id = strconv.Itoa(s.lastID)
+
+	data := copyTransaction(tx)
+	data.ID = id
+
+	now := time.Now()
+	s.transactions[id] = &memoryTransaction{seq: s.lastID, tx: data, createdAt: now, updatedAt: now}
+
+	return id, nil
+}
+
+// SaveTransactionResult saves the result of the transaction.
+// A transaction with result will not be returned by GetTimeoutTransactions.
+func (s *MemoryStorage) SaveTransactionResult(tx *Transaction, cost time.Duration, result Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.transactions[tx.ID]
+	if !ok {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	row.result = result
+	row.cost = cost
+	row.updatedAt = time.Now()
+
+	return nil
+}
+
+// SavePartnerResult saves the result of a phase of partner, the previous result is replaced.
+func (s *MemoryStorage) SavePartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	s.partners[s.partnerKey(tx.ID, phase, offset)] = PartnerResultRecord{
+		Phase:     phase,
+		Offset:    offset,
+		Result:    result,
+		Cost:      cost,
+		UpdatedAt: time.Now(),
+	}
+	return nil
+}
+
+// GetPartnerResult returns the result of a phase of partner, or "" if it is not saved.
+func (s *MemoryStorage) GetPartnerResult(tx *Transaction, phase string, offset int) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.partners[s.partnerKey(tx.ID, phase, offset)].Result, nil
+}
+
+// UpdateTransactionRetryTime update transaction next retry time.
+func (s *MemoryStorage) UpdateTransactionRetryTime(tx *Transaction, times int, newRetryTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.transactions[tx.ID]
+	if !ok {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	row.tx.Times = times
+	row.tx.RetryAt = newRetryTime
+	row.updatedAt = time.Now()
+
+	return nil
+}
+
+// GetTimeoutTransactions returns at most count transactions without result
+// whose retry time has passed, in the order of retry time.
+func (s *MemoryStorage) GetTimeoutTransactions(count int) (txs []*Transaction, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, row := range s.timeoutRows(count) {
+		txs = append(txs, copyTransaction(row.tx))
+	}
+
+	return txs, nil
+}
+
+// CountTimeoutTransactions returns the number of the transactions to be retried.
+func (s *MemoryStorage) CountTimeoutTransactions() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.timeoutRows(len(s.transactions))), nil
+}
+
+// ClaimTimeoutTransactions claims at most count timeout transactions for the owner,
+// their retry time is pushed to now + lease.
+func (s *MemoryStorage) ClaimTimeoutTransactions(owner string, count int, lease time.Duration) (txs []*Transaction, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, row := range s.timeoutRows(count) {
+		row.owner = owner
+		row.tx.RetryAt = now.Add(lease)
+		row.updatedAt = now
+
+		txs = append(txs, copyTransaction(row.tx))
+	}
+
+	return txs, nil
+}
+
+// GetTransaction returns a copy of the transaction and its result.
+func (s *MemoryStorage) GetTransaction(id string) (*Transaction, Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.transactions[id]
+	if !ok {
+		return nil, "", ErrTransactionNotFound
+	}
+
+	return copyTransaction(row.tx), row.result, nil
+}
+
+// GetTransactionsByResult returns at most count transactions of the result, in the order of ID.
+func (s *MemoryStorage) GetTransactionsByResult(result Result, count int) (txs []*Transaction, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transactionsOf(count, func(row *memoryTransaction) bool {
+		return row.result == result
+	}), nil
+}
+
+// GetFinishedTransactions returns at most count transactions of the result updated before the time without the partners,
+// in the order of ID.
+func (s *MemoryStorage) GetFinishedTransactions(result Result, before time.Time, count int) (records []*TransactionRecord, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, row := range s.rowsOf(count, func(row *memoryTransaction) bool {
+		return row.result == result && row.updatedAt.Before(before)
+	}) {
+		tx := &Transaction{ID: row.tx.ID, Name: row.tx.Name, Times: row.tx.Times, RetryAt: row.tx.RetryAt, CreatedAt: row.createdAt}
+		records = append(records, &TransactionRecord{Transaction: tx, Result: row.result, Cost: row.cost, UpdatedAt: row.updatedAt})
+	}
+
+	return records, nil
+}
+
+// GetPartnerResults returns the saved results of the partners of the transaction, in the order of phase and offset.
+func (s *MemoryStorage) GetPartnerResults(id string) (records []*PartnerResultRecord, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, partner := range s.partners {
+		if strings.HasPrefix(key, id+"/") {
+			partner := partner
+			records = append(records, &partner)
+		}
+	}
+
+	SortPartnerResults(records)
+	return records, nil
+}
+
+// DeleteTransactions deletes the transactions which still have the result, and their partner results.
+func (s *MemoryStorage) DeleteTransactions(result Result, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if row, ok := s.transactions[id]; !ok || row.result != result {
+			continue
+		}
+
+		delete(s.transactions, id)
+		for key := range s.partners {
+			if strings.HasPrefix(key, id+"/") {
+				delete(s.partners, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ResetTransaction replaces the saved copy of the transaction, and clears its result.
+func (s *MemoryStorage) ResetTransaction(tx *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.transactions[tx.ID]
+	if !ok {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	row.tx = copyTransaction(tx)
+	row.owner = ""
+	row.result = ""
+	row.cost = 0
+	row.updatedAt = time.Now()
+
+	return nil
+}
+
+// UpdateTransactionContent saves a copy of the transaction, and keeps its times, retry time and result.
+func (s *MemoryStorage) UpdateTransactionContent(tx *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.transactions[tx.ID]
+	if !ok {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	data := copyTransaction(tx)
+	data.Times = row.tx.Times
+	data.RetryAt = row.tx.RetryAt
+	row.tx = data
+	row.updatedAt = time.Now()
+
+	return nil
+}
+
+// SaveResolution saves a copy of the resolution of the transaction.
+func (s *MemoryStorage) SaveResolution(tx *Transaction, resolution *Resolution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	s.resolutions[tx.ID] = append(s.resolutions[tx.ID], *resolution)
+	return nil
+}
+
+// GetResolutions returns copies of the resolutions of the transaction in the order of saving.
+func (s *MemoryStorage) GetResolutions(id string) (resolutions []*Resolution, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, resolution := range s.resolutions[id] {
+		resolution := resolution
+		resolutions = append(resolutions, &resolution)
+	}
+
+	return resolutions, nil
+}
+
+// transactionsOf returns copies of at most count transactions matching in the order of ID, s.mu must be held.
+func (s *MemoryStorage) transactionsOf(count int, match func(row *memoryTransaction) bool) (txs []*Transaction) {
+	for _, row := range s.rowsOf(count, match) {
+		txs = append(txs, copyTransaction(row.tx))
+	}
+
+	return txs
+}
+
+// rowsOf returns at most count matched rows in the order of ID, s.mu must be held.
+func (s *MemoryStorage) rowsOf(count int, match func(row *memoryTransaction) bool) []*memoryTransaction {
+	if count <= 0 {
+		return nil
+	}
+
+	var rows []*memoryTransaction
+	for _, row := range s.transactions {
+		if match(row) {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].seq < rows[j].seq
+	})
+
+	if len(rows) > count {
+		rows = rows[:count]
+	}
+
+	return rows
+}
+
+// timeoutRows returns at most count rows to retry in the order of retry time, s.mu must be held.
+func (s *MemoryStorage) timeoutRows(count int) []*memoryTransaction {
+	now := time.Now()
+	var rows []*memoryTransaction
+	for _, row := range s.transactions {
+		if row.result == "" && row.tx.RetryAt.Before(now) {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].tx.RetryAt.Equal(rows[j].tx.RetryAt) {
+			return rows[i].seq < rows[j].seq
+		}
+		return rows[i].tx.RetryAt.Before(rows[j].tx.RetryAt)
+	})
+
+	if count < 0 {
+		count = 0
+	}
+	if len(rows) > count {
+		rows = rows[:count]
+	}
+
+	return rows
+}
+
+// ListTransactions returns the transactions matching the filter, in the order of ID.
+// The creation time is the time the transaction is saved.
+func (s *MemoryStorage) ListTransactions(filter TransactionFilter) (records []*TransactionRecord, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []*memoryTransaction
+	for _, row := range s.transactions {
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].seq < rows[j].seq
+	})
+
+	for _, row := range rows {
+		if filter.Count > 0 && len(records) >= filter.Count {
+			break
+		}
+
+		tx := copyTransaction(row.tx)
+		tx.CreatedAt = row.createdAt
+		if filter.Match(tx, row.result) {
+			records = append(records, &TransactionRecord{Transaction: tx, Result: row.result, Cost: row.cost, UpdatedAt: row.updatedAt})
+		}
+	}
+
+	return records, nil
+}
+
+// ListTransactionSummaries returns the transactions matching the filter without the partners, in the order of ID.
+func (s *MemoryStorage) ListTransactionSummaries(filter TransactionFilter) (records []*TransactionRecord, err error) {
+	if records, err = s.ListTransactions(filter); err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		tx := r.Transaction
+		r.Transaction = &Transaction{ID: tx.ID, Name: tx.Name, Times: tx.Times, RetryAt: tx.RetryAt, CreatedAt: tx.CreatedAt}
+	}
+
+	return records, nil
+}
+
+// CountTransactions returns the numbers of the transactions by name and result, in the order of name and result.
+func (s *MemoryStorage) CountTransactions() (counts []*TransactionCount, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := map[TransactionCount]int{}
+	for _, row := range s.transactions {
+		key := TransactionCount{Name: row.tx.Name, Result: row.result}
+		if i, ok := indexes[key]; ok {
+			counts[i].Count++
+			continue
+		}
+
+		indexes[key] = len(counts)
+		counts = append(counts, &TransactionCount{Name: key.Name, Result: key.Result, Count: 1})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Name == counts[j].Name {
+			return counts[i].Result < counts[j].Result
+		}
+		return counts[i].Name < counts[j].Name
+	})
+
+	return counts, nil
+}
+
+func (s *MemoryStorage) partnerKey(id string, phase string, offset int) string {
+	return fmt.Sprintf("%v/%v/%v", id, phase, offset)
+}
+
+// copyTransaction returns a copy of the persistent fields of the transaction.
+// The partners are shared by the copy.
+func copyTransaction(tx *Transaction) *Transaction {
+	data := *tx
+	data.startAt = time.Time{}
+	data.retrying = false
+	data.ctx = nil
+	data.manager = nil
+	data.results = nil
+	data.listeners = nil
+
+	data.NormalPartners = append([]NormalPartner(nil), tx.NormalPartners...)
+	data.CertainPartners = append([]CertainPartner(nil), tx.CertainPartners...)
+	data.AsyncPartners = append([]CertainPartner(nil), tx.AsyncPartners...)
+
+	if tx.Versions != nil {
+		data.Versions = make(map[string]int, len(tx.Versions))
+		for k, v := range tx.Versions {
+			data.Versions[k] = v
+		}
+	}
+
+	if tx.Dependencies != nil {
+		data.Dependencies = make(map[int][]int, len(tx.Dependencies))
+		for k, v := range tx.Dependencies {
+			data.Dependencies[k] = append([]int(nil), v...)
+		}
+	}
+
+	return &data
+}
@@ -0,0 +1,43 @@
+package gtm_test
+
+import (
+	"testing"
+
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/gtmtest"
+)
+
+var (
+	_ gtm.Storage = &gtm.MemoryStorage{}
+)
+
+func TestMemoryStorage(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		return gtm.NewMemoryStorage()
+	})
+}
+
+func TestMemoryStorageZero(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		return &gtm.MemoryStorage{}
+	})
+}
+
+func TestMemoryStorageCopy(t *testing.T) {
+	s := &gtm.MemoryStorage{}
+	tx := &gtm.Transaction{Name: "test-memory-copy", Versions: map[string]int{"payer": 1}}
+
+	id, err := s.SaveTransaction(tx)
+	if err != nil {
+		t.Fatalf("SaveTransaction() err = %v", err)
+	}
+	tx.Versions["payer"] = 2
+
+	saved, _, err := s.GetTransaction(id)
+	if err != nil {
+		t.Fatalf("GetTransaction() err = %v", err)
+	}
+	if saved.Versions["payer"] != 1 {
+		t.Errorf("saved versions = %v, want payer 1", saved.Versions)
+	}
+}
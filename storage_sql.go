@@ -0,0 +1,631 @@
+package gtm
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	_ ClaimStorage      = &SQLStorage{}
+	_ QueryStorage      = &SQLStorage{}
+	_ ResetStorage      = &SQLStorage{}
+	_ ResolutionStorage = &SQLStorage{}
+	_ UpgradeStorage    = &SQLStorage{}
+	_ PurgeStorage      = &SQLStorage{}
+	_ CountStorage      = &SQLStorage{}
+	_ InspectStorage    = &SQLStorage{}
+	_ SummaryStorage    = &SQLStorage{}
+)
+
+// SQLStorage is a GTM Storage implementation using database/sql.
+// It does not depend on gorm, the SQL differences of databases are handled by the Dialect.
+// The tables are the same as DBStorage, and can be created by Migrate().
+type SQLStorage struct {
+	db      *sql.DB
+	dialect Dialect
+	codec   Codec
+	logger  Logger
+}
+
+// NewSQLStorage returns a *SQLStorage using the db of the dialect,
+// e.g. NewSQLStorage(db, MySQLDialect{}).
+func NewSQLStorage(db *sql.DB, dialect Dialect) *SQLStorage {
+	return &SQLStorage{db: db, dialect: dialect, codec: GobCodec{}}
+}
+
+// SetCodec sets the codec encoding the transactions, GobCodec by default.
+// The transactions saved by other codecs are still decoded by the registered codecs.
+func (s *SQLStorage) SetCodec(c Codec) *SQLStorage {
+	s.codec = c
+	return s
+}
+
+// SetLogger sets the logger of the migrations, the claims and the transactions skipped as they can not be decoded,
+// nil disables logging.
+func (s *SQLStorage) SetLogger(l Logger) *SQLStorage {
+	s.logger = l
+	return s
+}
+
+// Migrate creates the tables of the storage, or upgrades the tables created by the previous versions.
+// The applied versions are recorded in the table gtm_schema_version.
+// It returns an error if the live schema is incompatible, or is migrated by a newer version of GTM.
+// Run it from one process at a time, e.g. when the service is deployed.
+func (s *SQLStorage) Migrate() error {
+	return migrate(s.db, s.dialect, s.logger)
+}
+
+// CreateTables creates the tables of the storage if they do not exist.
+// The existing tables are not upgraded, use Migrate instead.
+func (s *SQLStorage) CreateTables() error {
+	for _, statement := range s.dialect.CreateTables() {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("create table err: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Register records the types of partners for decoding, as gtm.Register.
+func (s *SQLStorage) Register(values ...interface{}) {
+	Register(values...)
+}
+
+// SaveTransaction saves transaction data to db.
+func (s *SQLStorage) SaveTransaction(tx *Transaction) (id string, err error) {
+	codec, content, err := encodeTransaction(s.codec, tx)
+	if err != nil {
+		return "", fmt.Errorf("encode err: %v", err)
+	}
+
+	now := time.Now().UTC()
+	query := s.rebind("INSERT INTO {gtm_transactions} ({name}, {times}, {retry_at}, {timeout}, {result}, {cost}, {content}, {codec}, {created_at}, {updated_at}) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	args := []interface{}{tx.Name, tx.Times, tx.RetryAt.UTC(), int(tx.Timeout.Seconds()), "", 0, content, codec, now, now}
+
+	if s.dialect.InsertReturningID() {
+		var rowID int64
+		if err := s.db.QueryRowContext(tx.Context(), query+" RETURNING "+s.dialect.Quote("id"), args...).Scan(&rowID); err != nil {
+			return "", fmt.Errorf("db insert err: %v", err)
+		}
+		return strconv.FormatInt(rowID, 10), nil
+	}
+
+	result, err := s.db.ExecContext(tx.Context(), query, args...)
+	if err != nil {
+		return "", fmt.Errorf("db insert err: %v", err)
+	}
+
+	rowID, err := result.LastInsertId()
+	if err != nil {
+		return "", fmt.Errorf("last insert id err: %v", err)
+	}
+
+	return strconv.FormatInt(rowID, 10), nil
+}
+
+// SaveTransactionResult saves transaction results to db.
+func (s *SQLStorage) SaveTransactionResult(tx *Transaction, cost time.Duration, result Result) error {
+	query := s.rebind("UPDATE {gtm_transactions} SET {result}=?, {cost}=?, {updated_at}=? WHERE {id}=?")
+	if _, err := s.db.ExecContext(tx.Context(), query, string(result), int64(cost), time.Now().UTC(), tx.ID); err != nil {
+		return fmt.Errorf("db update err: %v", err)
+	}
+
+	return nil
+}
+
+// SavePartnerResult saves the result of a phase of partner to db, the previous result is replaced.
+func (s *SQLStorage) SavePartnerResult(tx *Transaction, phase string, offset int, cost time.Duration, result Result) error {
+	txID, err := strconv.ParseInt(tx.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("strconv id err: %v", err)
+	}
+
+	query := s.dialect.Upsert("gtm_partner_result",
+		[]string{"transaction_id", "phase", "offset", "result", "cost", "created_at", "updated_at"},
+		[]string{"transaction_id", "phase", "offset"},
+		[]string{"result", "cost", "updated_at"},
+	)
+
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(tx.Context(), query, txID, phase, offset, string(result), int64(cost), now, now); err != nil {
+		return fmt.Errorf("db upsert err: %v", err)
+	}
+
+	return nil
+}
+
+// GetPartnerResult returns the result of a phase of partner, or "" if it is not saved.
+func (s *SQLStorage) GetPartnerResult(tx *Transaction, phase string, offset int) (Result, error) {
+	query := s.rebind("SELECT {result} FROM {gtm_partner_result} WHERE {transaction_id}=? AND {phase}=? AND {offset}=?")
+
+	var result string
+	if err := s.db.QueryRowContext(tx.Context(), query, tx.ID, phase, offset).Scan(&result); err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("db query err: %v", err)
+	}
+
+	return Result(result), nil
+}
+
+// UpdateTransactionRetryTime update transaction next retry time.
+func (s *SQLStorage) UpdateTransactionRetryTime(tx *Transaction, times int, newRetryTime time.Time) error {
+	query := s.rebind("UPDATE {gtm_transactions} SET {times}=?, {retry_at}=?, {updated_at}=? WHERE {id}=?")
+	if _, err := s.db.ExecContext(tx.Context(), query, times, newRetryTime.UTC(), time.Now().UTC(), tx.ID); err != nil {
+		return fmt.Errorf("db update err: %v", err)
+	}
+
+	return nil
+}
+
+// GetTimeoutTransactions returns at most count transactions without result
+// whose retry time has passed, in the order of retry time.
+func (s *SQLStorage) GetTimeoutTransactions(count int) (txs []*Transaction, err error) {
+	query := s.rebind("SELECT {id}, {times}, {retry_at}, {content}, {codec} FROM {gtm_transactions} WHERE {result}=? AND {retry_at}<? ORDER BY {retry_at} LIMIT ?")
+
+	rows, err := s.db.Query(query, "", time.Now().UTC(), count)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+
+	return s.scanTransactions(rows)
+}
+
+// CountTimeoutTransactions returns the number of the transactions to be retried.
+func (s *SQLStorage) CountTimeoutTransactions() (count int, err error) {
+	query := s.rebind("SELECT COUNT(*) FROM {gtm_transactions} WHERE {result}=? AND {retry_at}<?")
+	if err := s.db.QueryRow(query, "", time.Now().UTC()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("db query err: %v", err)
+	}
+
+	return count, nil
+}
+
+// ClaimTimeoutTransactions claims at most count timeout transactions for the owner in one UPDATE,
+// their retry time is pushed to now + lease.
+func (s *SQLStorage) ClaimTimeoutTransactions(owner string, count int, lease time.Duration) (txs []*Transaction, err error) {
+	now := time.Now().UTC()
+	query := s.rebind(s.dialect.UpdateFirst("{gtm_transactions}", "{retry_at}=?, {lease_owner}=?", "{result}=? AND {retry_at}<?", "{retry_at}", "?"))
+	res, err := s.db.Exec(query, now.Add(lease), owner, "", now, count)
+	if err != nil {
+		return nil, fmt.Errorf("db claim err: %v", err)
+	}
+	if claimed, err := res.RowsAffected(); err == nil {
+		logTo(s.logger, LevelDebug, "transactions claimed", "owner", owner, "count", claimed, "lease", lease)
+	}
+
+	query = s.rebind("SELECT {id}, {times}, {retry_at}, {content}, {codec} FROM {gtm_transactions} WHERE {lease_owner}=? AND {result}=? ORDER BY {id}")
+	rows, err := s.db.Query(query, owner, "")
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+
+	return s.scanTransactions(rows)
+}
+
+// GetTransaction returns the transaction and its result.
+func (s *SQLStorage) GetTransaction(id string) (*Transaction, Result, error) {
+	query := s.rebind("SELECT {times}, {retry_at}, {content}, {codec}, {result} FROM {gtm_transactions} WHERE {id}=?")
+
+	var (
+		times   int
+		retryAt time.Time
+		content string
+		codec   string
+		result  string
+	)
+	if err := s.db.QueryRow(query, id).Scan(&times, &retryAt, &content, &codec, &result); err == sql.ErrNoRows {
+		return nil, "", ErrTransactionNotFound
+	} else if err != nil {
+		return nil, "", fmt.Errorf("db query err: %v", err)
+	}
+
+	tx, err := decodeTransaction(s.codec, codec, content)
+	if err != nil {
+		return nil, "", NewDecodeError(id, err)
+	}
+
+	tx.ID = id
+	tx.Times = times
+	tx.RetryAt = retryAt
+
+	return tx, Result(result), nil
+}
+
+// GetTransactionsByResult returns at most count transactions of the result, in the order of ID.
+func (s *SQLStorage) GetTransactionsByResult(result Result, count int) (txs []*Transaction, err error) {
+	query := s.rebind("SELECT {id}, {times}, {retry_at}, {content}, {codec} FROM {gtm_transactions} WHERE {result}=? ORDER BY {id} LIMIT ?")
+
+	rows, err := s.db.Query(query, string(result), count)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+
+	return s.scanTransactions(rows)
+}
+
+// ResetTransaction saves the transaction again and clears its result.
+func (s *SQLStorage) ResetTransaction(tx *Transaction) error {
+	codec, content, err := encodeTransaction(s.codec, tx)
+	if err != nil {
+		return fmt.Errorf("encode err: %v", err)
+	}
+
+	query := s.rebind("UPDATE {gtm_transactions} SET {times}=?, {retry_at}=?, {content}=?, {codec}=?, {result}=?, {cost}=?, {lease_owner}=?, {updated_at}=? WHERE {id}=?")
+	result, err := s.db.ExecContext(tx.Context(), query, tx.Times, tx.RetryAt.UTC(), content, codec, "", 0, "", time.Now().UTC(), tx.ID)
+	if err != nil {
+		return fmt.Errorf("db update err: %v", err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected err: %v", err)
+	} else if n == 0 {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	return nil
+}
+
+// UpdateTransactionContent saves the content of the transaction again.
+func (s *SQLStorage) UpdateTransactionContent(tx *Transaction) error {
+	codec, content, err := encodeTransaction(s.codec, tx)
+	if err != nil {
+		return fmt.Errorf("encode err: %v", err)
+	}
+
+	query := s.rebind("UPDATE {gtm_transactions} SET {content}=?, {codec}=?, {updated_at}=? WHERE {id}=?")
+	result, err := s.db.ExecContext(tx.Context(), query, content, codec, time.Now().UTC(), tx.ID)
+	if err != nil {
+		return fmt.Errorf("db update err: %v", err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("rows affected err: %v", err)
+	} else if n == 0 {
+		return fmt.Errorf("transaction not found: %v", tx.ID)
+	}
+
+	return nil
+}
+
+// GetFinishedTransactions returns at most count transactions of the result updated before the time without the partners,
+// in the order of ID. The content is not selected.
+func (s *SQLStorage) GetFinishedTransactions(result Result, before time.Time, count int) (records []*TransactionRecord, err error) {
+	query := s.rebind("SELECT {id}, {name}, {times}, {retry_at}, {result}, {cost}, {created_at}, {updated_at} FROM {gtm_transactions} " +
+		"WHERE {result}=? AND {updated_at}<? ORDER BY {id} LIMIT ?")
+
+	rows, err := s.db.Query(query, string(result), before.UTC(), count)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+
+	return s.scanSummaries(rows)
+}
+
+// GetPartnerResults returns the saved results of the partners of the transaction, in the order of phase and offset.
+func (s *SQLStorage) GetPartnerResults(id string) (records []*PartnerResultRecord, err error) {
+	query := s.rebind("SELECT {phase}, {offset}, {result}, {cost}, {updated_at} FROM {gtm_partner_result} WHERE {transaction_id}=? ORDER BY {phase}, {offset}")
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			record PartnerResultRecord
+			result string
+			cost   int64
+		)
+		if err := rows.Scan(&record.Phase, &record.Offset, &result, &cost, &record.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("db scan err: %v", err)
+		}
+
+		record.Result, record.Cost = Result(result), time.Duration(cost)
+		records = append(records, &record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows err: %v", err)
+	}
+
+	return records, nil
+}
+
+// ListTransactions returns the transactions matching the filter, in the order of ID.
+func (s *SQLStorage) ListTransactions(filter TransactionFilter) (records []*TransactionRecord, err error) {
+	where, args, err := s.where(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.rebind("SELECT {id}, {times}, {retry_at}, {content}, {codec}, {result}, {cost}, {created_at}, {updated_at} FROM {gtm_transactions} WHERE " +
+		where + " ORDER BY {id}" + limit(filter))
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id                   int64
+			times                int
+			retryAt              time.Time
+			content, codec       string
+			result               string
+			cost                 int64
+			createdAt, updatedAt time.Time
+		)
+		if err := rows.Scan(&id, &times, &retryAt, &content, &codec, &result, &cost, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("db scan err: %v", err)
+		}
+
+		tx, err := decodeTransaction(s.codec, codec, content)
+		if err != nil {
+			logTo(s.logger, LevelError, "transaction skipped", "id", id, "err", NewDecodeError(strconv.FormatInt(id, 10), err))
+			continue
+		}
+
+		tx.ID = strconv.FormatInt(id, 10)
+		tx.Times = times
+		tx.RetryAt = retryAt
+		tx.CreatedAt = createdAt
+
+		records = append(records, &TransactionRecord{Transaction: tx, Result: Result(result), Cost: time.Duration(cost), UpdatedAt: updatedAt})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows err: %v", err)
+	}
+
+	return records, nil
+}
+
+// ListTransactionSummaries returns the transactions matching the filter without the partners, in the order of ID.
+// The content is not selected.
+func (s *SQLStorage) ListTransactionSummaries(filter TransactionFilter) (records []*TransactionRecord, err error) {
+	where, args, err := s.where(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.rebind("SELECT {id}, {name}, {times}, {retry_at}, {result}, {cost}, {created_at}, {updated_at} FROM {gtm_transactions} WHERE " +
+		where + " ORDER BY {id}" + limit(filter))
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+
+	return s.scanSummaries(rows)
+}
+
+// CountTransactions returns the numbers of the transactions by name and result, in the order of name and result.
+func (s *SQLStorage) CountTransactions() (counts []*TransactionCount, err error) {
+	query := s.rebind("SELECT {name}, {result}, COUNT(*) FROM {gtm_transactions} GROUP BY {name}, {result} ORDER BY {name}, {result}")
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			count  TransactionCount
+			result string
+		)
+		if err := rows.Scan(&count.Name, &result, &count.Count); err != nil {
+			return nil, fmt.Errorf("db scan err: %v", err)
+		}
+
+		count.Result = Result(result)
+		counts = append(counts, &count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows err: %v", err)
+	}
+
+	return counts, nil
+}
+
+// limit returns the LIMIT clause of the count of the filter, "" if it lists all the transactions.
+func limit(filter TransactionFilter) string {
+	if filter.Count <= 0 {
+		return ""
+	}
+	return " LIMIT " + strconv.Itoa(filter.Count)
+}
+
+// where returns the condition of the transactions matching the filter except Count, and its arguments.
+func (s *SQLStorage) where(filter TransactionFilter) (string, []interface{}, error) {
+	where, args := []string{"1=1"}, []interface{}{}
+	if filter.Name != "" {
+		where = append(where, "{name}=?")
+		args = append(args, filter.Name)
+	}
+	if len(filter.Results) > 0 {
+		where = append(where, "{result} IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(filter.Results)), ", ")+")")
+		for _, result := range filter.Results {
+			args = append(args, string(result))
+		}
+	}
+	if !filter.CreatedAfter.IsZero() {
+		where = append(where, "{created_at}>=?")
+		args = append(args, filter.CreatedAfter.UTC())
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where = append(where, "{created_at}<?")
+		args = append(args, filter.CreatedBefore.UTC())
+	}
+	if filter.AfterID != "" {
+		afterID, err := strconv.ParseInt(filter.AfterID, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("strconv id err: %v", err)
+		}
+		where = append(where, "{id}>?")
+		args = append(args, afterID)
+	}
+
+	return strings.Join(where, " AND "), args, nil
+}
+
+// DeleteTransactions deletes the transactions which still have the result,
+// then the partner results of the transactions deleted.
+func (s *SQLStorage) DeleteTransactions(result Result, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		idArgs[i] = id
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	query := s.rebind("DELETE FROM {gtm_transactions} WHERE {result}=? AND {id} IN (" + in + ")")
+	if _, err := s.db.Exec(query, append([]interface{}{string(result)}, idArgs...)...); err != nil {
+		return fmt.Errorf("db delete transactions err: %v", err)
+	}
+
+	query = s.rebind("DELETE FROM {gtm_partner_result} WHERE {transaction_id} IN (" + in + ") AND {transaction_id} NOT IN (SELECT {id} FROM {gtm_transactions} WHERE {id} IN (" + in + "))")
+	if _, err := s.db.Exec(query, append(idArgs, idArgs...)...); err != nil {
+		return fmt.Errorf("db delete partner results err: %v", err)
+	}
+
+	return nil
+}
+
+// SaveResolution saves a resolution of the transaction.
+func (s *SQLStorage) SaveResolution(tx *Transaction, resolution *Resolution) error {
+	query := s.rebind("INSERT INTO {gtm_resolution} ({transaction_id}, {action}, {operator}, {reason}, {created_at}) VALUES (?, ?, ?, ?, ?)")
+	if _, err := s.db.ExecContext(tx.Context(), query, tx.ID, resolution.Action, resolution.Operator, resolution.Reason, resolution.CreatedAt.UTC()); err != nil {
+		return fmt.Errorf("db insert err: %v", err)
+	}
+
+	return nil
+}
+
+// GetResolutions returns the resolutions of the transaction in the order of time.
+func (s *SQLStorage) GetResolutions(id string) (resolutions []*Resolution, err error) {
+	query := s.rebind("SELECT {action}, {operator}, {reason}, {created_at} FROM {gtm_resolution} WHERE {transaction_id}=? ORDER BY {created_at}, {id}")
+
+	rows, err := s.db.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("db query err: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var resolution Resolution
+		if err := rows.Scan(&resolution.Action, &resolution.Operator, &resolution.Reason, &resolution.CreatedAt); err != nil {
+			return nil, fmt.Errorf("db scan err: %v", err)
+		}
+
+		resolutions = append(resolutions, &resolution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows err: %v", err)
+	}
+
+	return resolutions, nil
+}
+
+// scanTransactions decodes the transactions of rows selecting id, times, retry_at, content and codec, and closes rows.
+// The ones which can not be decoded are skipped and logged, so that they do not block the others.
+func (s *SQLStorage) scanTransactions(rows *sql.Rows) (txs []*Transaction, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id      int64
+			times   int
+			retryAt time.Time
+			content string
+			codec   string
+		)
+		if err := rows.Scan(&id, &times, &retryAt, &content, &codec); err != nil {
+			return nil, fmt.Errorf("db scan err: %v", err)
+		}
+
+		tx, err := decodeTransaction(s.codec, codec, content)
+		if err != nil {
+			logTo(s.logger, LevelError, "transaction skipped", "id", id, "err", NewDecodeError(strconv.FormatInt(id, 10), err))
+			continue
+		}
+
+		tx.ID = strconv.FormatInt(id, 10)
+		tx.Times = times
+		tx.RetryAt = retryAt
+
+		txs = append(txs, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows err: %v", err)
+	}
+
+	return txs, nil
+}
+
+// scanSummaries returns the transactions without the partners of rows
+// selecting id, name, times, retry_at, result, cost, created_at and updated_at, and closes rows.
+func (s *SQLStorage) scanSummaries(rows *sql.Rows) (records []*TransactionRecord, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id                   int64
+			tx                   Transaction
+			result               string
+			cost                 int64
+			createdAt, updatedAt time.Time
+		)
+		if err := rows.Scan(&id, &tx.Name, &tx.Times, &tx.RetryAt, &result, &cost, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("db scan err: %v", err)
+		}
+
+		tx.ID = strconv.FormatInt(id, 10)
+		tx.CreatedAt = createdAt
+
+		records = append(records, &TransactionRecord{Transaction: &tx, Result: Result(result), Cost: time.Duration(cost), UpdatedAt: updatedAt})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("db rows err: %v", err)
+	}
+
+	return records, nil
+}
+
+func (s *SQLStorage) rebind(query string) string {
+	return rebind(s.dialect, query)
+}
+
+// rebind replaces the ? with the placeholders of the dialect,
+// and the identifiers in braces with the quoted ones, e.g. {offset}.
+func rebind(d Dialect, query string) string {
+	var builder strings.Builder
+	n := 0
+
+	for i := 0; i < len(query); i++ {
+		switch query[i] {
+		case '?':
+			n++
+			builder.WriteString(d.Placeholder(n))
+		case '{':
+			end := strings.IndexByte(query[i:], '}')
+			builder.WriteString(d.Quote(query[i+1 : i+end]))
+			i += end
+		default:
+			builder.WriteByte(query[i])
+		}
+	}
+
+	return builder.String()
+}
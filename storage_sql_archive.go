@@ -0,0 +1,80 @@
+package gtm
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SQLArchiver writes the purged transactions to the table gtm_transactions_archive,
+// and their partner results to the table gtm_partner_result_archive, which are created by Migrate of SQLStorage and DBStorage.
+// The database can be another one than the storage, if the table is created.
+type SQLArchiver struct {
+	db      *sql.DB
+	dialect Dialect
+	codec   Codec
+}
+
+// NewSQLArchiver returns a *SQLArchiver using the db of the dialect.
+// The transactions are encoded by JSONCodec, so that the archive is readable.
+func NewSQLArchiver(db *sql.DB, dialect Dialect) *SQLArchiver {
+	return &SQLArchiver{db: db, dialect: dialect, codec: &JSONCodec{}}
+}
+
+// SetCodec sets the codec encoding the archived transactions.
+func (a *SQLArchiver) SetCodec(c Codec) *SQLArchiver {
+	a.codec = c
+	return a
+}
+
+// Archive inserts the transactions and their partner results in one database transaction.
+// A transaction archived before is replaced.
+func (a *SQLArchiver) Archive(result Result, txs []*ArchivedTransaction) error {
+	query := a.dialect.Upsert("gtm_transactions_archive",
+		[]string{"id", "name", "times", "result", "content", "codec", "created_at", "archived_at"},
+		[]string{"id"},
+		[]string{"times", "result", "content", "codec", "archived_at"},
+	)
+	partnerQuery := a.dialect.Upsert("gtm_partner_result_archive",
+		[]string{"transaction_id", "phase", "offset", "result", "cost", "updated_at", "archived_at"},
+		[]string{"transaction_id", "phase", "offset"},
+		[]string{"result", "cost", "updated_at", "archived_at"},
+	)
+
+	dbTx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("db begin err: %v", err)
+	}
+	defer dbTx.Rollback()
+
+	now := time.Now().UTC()
+	for _, archived := range txs {
+		tx := archived.Transaction
+		id, err := strconv.ParseInt(tx.ID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("strconv id err: %v", err)
+		}
+
+		codec, content, err := encodeTransaction(a.codec, tx)
+		if err != nil {
+			return fmt.Errorf("encode err: %v, %v", tx.ID, err)
+		}
+
+		if _, err := dbTx.Exec(query, id, tx.Name, tx.Times, string(result), content, codec, tx.CreatedAt.UTC(), now); err != nil {
+			return fmt.Errorf("db upsert err: %v", err)
+		}
+
+		for _, r := range archived.PartnerResults {
+			if _, err := dbTx.Exec(partnerQuery, id, r.Phase, r.Offset, string(r.Result), int64(r.Cost), r.UpdatedAt.UTC(), now); err != nil {
+				return fmt.Errorf("db upsert partner result err: %v", err)
+			}
+		}
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return fmt.Errorf("db commit err: %v", err)
+	}
+
+	return nil
+}
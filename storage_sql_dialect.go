@@ -0,0 +1,327 @@
+package gtm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect is the SQL differences of a database used by SQLStorage.
+type Dialect interface {
+	// Name returns the name of the database, e.g. "mysql".
+	Name() string
+
+	// Placeholder returns the placeholder of the nth argument, n starts from 1.
+	Placeholder(n int) string
+
+	// Quote quotes an identifier, such as a table or column name.
+	Quote(identifier string) string
+
+	// Upsert returns an INSERT statement of the columns,
+	// which updates the update columns instead if the key columns conflict.
+	Upsert(table string, columns, keys, updates []string) string
+
+	// InsertReturningID reports whether the ID of an inserted row is returned by
+	// the statement with "RETURNING id", instead of sql.Result.LastInsertId().
+	InsertReturningID() bool
+
+	// UpdateFirst returns an UPDATE statement of the first limit rows matching where in the order of orderBy.
+	// The rows must be locked or skipped so that concurrent statements never update the same row.
+	// The arguments are SQL fragments which may contain placeholders.
+	UpdateFirst(table, set, where, orderBy, limit string) string
+
+	// CreateTables returns the statements creating the tables of SQLStorage if they do not exist.
+	CreateTables() []string
+}
+
+var (
+	_ Dialect = MySQLDialect{}
+	_ Dialect = PostgreSQLDialect{}
+	_ Dialect = SQLiteDialect{}
+)
+
+// MySQLDialect is the Dialect of MySQL.
+// The DSN should set parseTime=true so that timestamps can be scanned.
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string {
+	return "mysql"
+}
+
+func (MySQLDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (MySQLDialect) Quote(identifier string) string {
+	return "`" + identifier + "`"
+}
+
+func (d MySQLDialect) Upsert(table string, columns, keys, updates []string) string {
+	var sets []string
+	for _, column := range updates {
+		sets = append(sets, fmt.Sprintf("%v=VALUES(%v)", d.Quote(column), d.Quote(column)))
+	}
+
+	return insertStatement(d, table, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (MySQLDialect) InsertReturningID() bool {
+	return false
+}
+
+func (MySQLDialect) UpdateFirst(table, set, where, orderBy, limit string) string {
+	return fmt.Sprintf("UPDATE %v SET %v WHERE %v ORDER BY %v LIMIT %v", table, set, where, orderBy, limit)
+}
+
+func (MySQLDialect) CreateTables() []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS `gtm_transactions` (" +
+			"`id` bigint UNSIGNED NOT NULL AUTO_INCREMENT, " +
+			"`name` varchar(50) NOT NULL, " +
+			"`times` int UNSIGNED NOT NULL, " +
+			"`retry_at` timestamp(6) NOT NULL, " +
+			"`timeout` int UNSIGNED NOT NULL, " +
+			"`result` varchar(20) NOT NULL, " +
+			"`cost` bigint UNSIGNED NOT NULL, " +
+			"`content` mediumtext, " +
+			"`codec` varchar(20) NOT NULL DEFAULT '', " +
+			"`lease_owner` varchar(64) NOT NULL DEFAULT '', " +
+			"`created_at` timestamp NOT NULL, " +
+			"`updated_at` timestamp NOT NULL, " +
+			"PRIMARY KEY (`id`), " +
+			"KEY `idx_retry` (`result`, `retry_at`))",
+		"CREATE TABLE IF NOT EXISTS `gtm_partner_result` (" +
+			"`id` bigint UNSIGNED NOT NULL AUTO_INCREMENT, " +
+			"`transaction_id` bigint UNSIGNED NOT NULL, " +
+			"`phase` varchar(20) NOT NULL, " +
+			"`offset` int UNSIGNED NOT NULL, " +
+			"`result` varchar(20) NOT NULL, " +
+			"`cost` bigint UNSIGNED NOT NULL, " +
+			"`created_at` timestamp NOT NULL, " +
+			"`updated_at` timestamp NOT NULL, " +
+			"PRIMARY KEY (`id`), " +
+			"UNIQUE KEY `uni_tx_id` (`transaction_id`, `phase`, `offset`))",
+		"CREATE TABLE IF NOT EXISTS `gtm_resolution` (" +
+			"`id` bigint UNSIGNED NOT NULL AUTO_INCREMENT, " +
+			"`transaction_id` bigint UNSIGNED NOT NULL, " +
+			"`action` varchar(20) NOT NULL, " +
+			"`operator` varchar(64) NOT NULL, " +
+			"`reason` text NOT NULL, " +
+			"`created_at` timestamp(6) NOT NULL, " +
+			"PRIMARY KEY (`id`), " +
+			"KEY `idx_tx_id` (`transaction_id`))",
+		"CREATE TABLE IF NOT EXISTS `gtm_transactions_archive` (" +
+			"`id` bigint UNSIGNED NOT NULL, " +
+			"`name` varchar(50) NOT NULL, " +
+			"`times` int UNSIGNED NOT NULL, " +
+			"`result` varchar(20) NOT NULL, " +
+			"`content` mediumtext, " +
+			"`codec` varchar(20) NOT NULL, " +
+			"`created_at` timestamp NOT NULL, " +
+			"`archived_at` timestamp NOT NULL, " +
+			"PRIMARY KEY (`id`))",
+		"CREATE TABLE IF NOT EXISTS `gtm_partner_result_archive` (" +
+			"`transaction_id` bigint UNSIGNED NOT NULL, " +
+			"`phase` varchar(20) NOT NULL, " +
+			"`offset` int UNSIGNED NOT NULL, " +
+			"`result` varchar(20) NOT NULL, " +
+			"`cost` bigint UNSIGNED NOT NULL, " +
+			"`updated_at` timestamp NOT NULL, " +
+			"`archived_at` timestamp NOT NULL, " +
+			"PRIMARY KEY (`transaction_id`, `phase`, `offset`))",
+	}
+}
+
+// PostgreSQLDialect is the Dialect of PostgreSQL.
+type PostgreSQLDialect struct{}
+
+func (PostgreSQLDialect) Name() string {
+	return "postgres"
+}
+
+func (PostgreSQLDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%v", n)
+}
+
+func (PostgreSQLDialect) Quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (d PostgreSQLDialect) Upsert(table string, columns, keys, updates []string) string {
+	return insertStatement(d, table, columns) + onConflictUpdate(d, keys, updates)
+}
+
+func (PostgreSQLDialect) InsertReturningID() bool {
+	return true
+}
+
+func (d PostgreSQLDialect) UpdateFirst(table, set, where, orderBy, limit string) string {
+	return fmt.Sprintf("UPDATE %v SET %v WHERE %v IN (SELECT %v FROM %v WHERE %v ORDER BY %v LIMIT %v FOR UPDATE SKIP LOCKED)",
+		table, set, d.Quote("id"), d.Quote("id"), table, where, orderBy, limit)
+}
+
+func (PostgreSQLDialect) CreateTables() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS "gtm_transactions" (` +
+			`"id" bigserial PRIMARY KEY, ` +
+			`"name" varchar(50) NOT NULL, ` +
+			`"times" integer NOT NULL, ` +
+			`"retry_at" timestamp with time zone NOT NULL, ` +
+			`"timeout" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"cost" bigint NOT NULL, ` +
+			`"content" text, ` +
+			`"codec" varchar(20) NOT NULL DEFAULT '', ` +
+			`"lease_owner" varchar(64) NOT NULL DEFAULT '', ` +
+			`"created_at" timestamp with time zone NOT NULL, ` +
+			`"updated_at" timestamp with time zone NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS "idx_retry" ON "gtm_transactions" ("result", "retry_at")`,
+		`CREATE TABLE IF NOT EXISTS "gtm_partner_result" (` +
+			`"id" bigserial PRIMARY KEY, ` +
+			`"transaction_id" bigint NOT NULL, ` +
+			`"phase" varchar(20) NOT NULL, ` +
+			`"offset" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"cost" bigint NOT NULL, ` +
+			`"created_at" timestamp with time zone NOT NULL, ` +
+			`"updated_at" timestamp with time zone NOT NULL, ` +
+			`CONSTRAINT "uni_tx_id" UNIQUE ("transaction_id", "phase", "offset"))`,
+		`CREATE TABLE IF NOT EXISTS "gtm_resolution" (` +
+			`"id" bigserial PRIMARY KEY, ` +
+			`"transaction_id" bigint NOT NULL, ` +
+			`"action" varchar(20) NOT NULL, ` +
+			`"operator" varchar(64) NOT NULL, ` +
+			`"reason" text NOT NULL, ` +
+			`"created_at" timestamp with time zone NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS "idx_tx_id" ON "gtm_resolution" ("transaction_id")`,
+		`CREATE TABLE IF NOT EXISTS "gtm_transactions_archive" (` +
+			`"id" bigint PRIMARY KEY, ` +
+			`"name" varchar(50) NOT NULL, ` +
+			`"times" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"content" text, ` +
+			`"codec" varchar(20) NOT NULL, ` +
+			`"created_at" timestamp with time zone NOT NULL, ` +
+			`"archived_at" timestamp with time zone NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS "gtm_partner_result_archive" (` +
+			`"transaction_id" bigint NOT NULL, ` +
+			`"phase" varchar(20) NOT NULL, ` +
+			`"offset" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"cost" bigint NOT NULL, ` +
+			`"updated_at" timestamp with time zone NOT NULL, ` +
+			`"archived_at" timestamp with time zone NOT NULL, ` +
+			`PRIMARY KEY ("transaction_id", "phase", "offset"))`,
+	}
+}
+
+// SQLiteDialect is the Dialect of SQLite, it requires SQLite 3.24 or later.
+// Timestamps are saved in UTC so that they are ordered as text.
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Name() string {
+	return "sqlite3"
+}
+
+func (SQLiteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (SQLiteDialect) Quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (d SQLiteDialect) Upsert(table string, columns, keys, updates []string) string {
+	return insertStatement(d, table, columns) + onConflictUpdate(d, keys, updates)
+}
+
+func (SQLiteDialect) InsertReturningID() bool {
+	return false
+}
+
+// UpdateFirst of SQLite needs no lock, because SQLite serializes all writes.
+func (d SQLiteDialect) UpdateFirst(table, set, where, orderBy, limit string) string {
+	return fmt.Sprintf("UPDATE %v SET %v WHERE %v IN (SELECT %v FROM %v WHERE %v ORDER BY %v LIMIT %v)",
+		table, set, d.Quote("id"), d.Quote("id"), table, where, orderBy, limit)
+}
+
+func (SQLiteDialect) CreateTables() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS "gtm_transactions" (` +
+			`"id" integer PRIMARY KEY AUTOINCREMENT, ` +
+			`"name" varchar(50) NOT NULL, ` +
+			`"times" integer NOT NULL, ` +
+			`"retry_at" datetime NOT NULL, ` +
+			`"timeout" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"cost" integer NOT NULL, ` +
+			`"content" text, ` +
+			`"codec" varchar(20) NOT NULL DEFAULT '', ` +
+			`"lease_owner" varchar(64) NOT NULL DEFAULT '', ` +
+			`"created_at" datetime NOT NULL, ` +
+			`"updated_at" datetime NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS "idx_retry" ON "gtm_transactions" ("result", "retry_at")`,
+		`CREATE TABLE IF NOT EXISTS "gtm_partner_result" (` +
+			`"id" integer PRIMARY KEY AUTOINCREMENT, ` +
+			`"transaction_id" integer NOT NULL, ` +
+			`"phase" varchar(20) NOT NULL, ` +
+			`"offset" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"cost" integer NOT NULL, ` +
+			`"created_at" datetime NOT NULL, ` +
+			`"updated_at" datetime NOT NULL, ` +
+			`UNIQUE ("transaction_id", "phase", "offset"))`,
+		`CREATE TABLE IF NOT EXISTS "gtm_resolution" (` +
+			`"id" integer PRIMARY KEY AUTOINCREMENT, ` +
+			`"transaction_id" integer NOT NULL, ` +
+			`"action" varchar(20) NOT NULL, ` +
+			`"operator" varchar(64) NOT NULL, ` +
+			`"reason" text NOT NULL, ` +
+			`"created_at" datetime NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS "idx_tx_id" ON "gtm_resolution" ("transaction_id")`,
+		`CREATE TABLE IF NOT EXISTS "gtm_transactions_archive" (` +
+			`"id" integer PRIMARY KEY, ` +
+			`"name" varchar(50) NOT NULL, ` +
+			`"times" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"content" text, ` +
+			`"codec" varchar(20) NOT NULL, ` +
+			`"created_at" datetime NOT NULL, ` +
+			`"archived_at" datetime NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS "gtm_partner_result_archive" (` +
+			`"transaction_id" integer NOT NULL, ` +
+			`"phase" varchar(20) NOT NULL, ` +
+			`"offset" integer NOT NULL, ` +
+			`"result" varchar(20) NOT NULL, ` +
+			`"cost" integer NOT NULL, ` +
+			`"updated_at" datetime NOT NULL, ` +
+			`"archived_at" datetime NOT NULL, ` +
+			`PRIMARY KEY ("transaction_id", "phase", "offset"))`,
+	}
+}
+
+// insertStatement returns "INSERT INTO table (columns) VALUES (placeholders)".
+func insertStatement(d Dialect, table string, columns []string) string {
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.Quote(column)
+		placeholders[i] = d.Placeholder(i + 1)
+	}
+
+	return fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)", d.Quote(table), strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+}
+
+// onConflictUpdate returns the "ON CONFLICT ... DO UPDATE" clause of PostgreSQL and SQLite.
+func onConflictUpdate(d Dialect, keys, updates []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = d.Quote(key)
+	}
+
+	var sets []string
+	for _, column := range updates {
+		sets = append(sets, fmt.Sprintf("%v=excluded.%v", d.Quote(column), d.Quote(column)))
+	}
+
+	return fmt.Sprintf(" ON CONFLICT (%v) DO UPDATE SET %v", strings.Join(quoted, ", "), strings.Join(sets, ", "))
+}
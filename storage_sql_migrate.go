@@ -0,0 +1,211 @@
+package gtm
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// schemaVersionTable records the migrations applied to the database, one row per version.
+const schemaVersionTable = "gtm_schema_version"
+
+// migration changes the schema of the tables from the previous version.
+// The migrations check the live schema, so that the tables created by hand are upgraded too.
+type migration struct {
+	version     int
+	description string
+	migrate     func(db *sql.DB, d Dialect) error
+}
+
+var migrations = []migration{
+	{1, "create tables", createTables},
+	{2, "add gtm_transactions.cost", addColumn("gtm_transactions", "cost", "bigint NOT NULL DEFAULT 0")},
+	{3, "add gtm_transactions.lease_owner", addColumn("gtm_transactions", "lease_owner", "varchar(64) NOT NULL DEFAULT ''")},
+	{4, "add gtm_transactions.codec", addColumn("gtm_transactions", "codec", "varchar(20) NOT NULL DEFAULT ''")},
+	{5, "change result to varchar", resultToVarchar},
+	{6, "create gtm_transactions_archive and gtm_partner_result_archive", createTables},
+	{7, "change gtm_transactions.retry_at to microseconds", retryAtToMicroseconds},
+}
+
+// schemaColumns are the columns used by the storages, verified after the migrations.
+var schemaColumns = map[string][]string{
+	"gtm_transactions":   {"id", "name", "times", "retry_at", "timeout", "result", "cost", "content", "codec", "lease_owner", "created_at", "updated_at"},
+	"gtm_partner_result": {"id", "transaction_id", "phase", "offset", "result", "cost", "created_at", "updated_at"},
+	"gtm_resolution":     {"id", "transaction_id", "action", "operator", "reason", "created_at"},
+
+	"gtm_transactions_archive":   {"id", "name", "times", "result", "content", "codec", "created_at", "archived_at"},
+	"gtm_partner_result_archive": {"transaction_id", "phase", "offset", "result", "cost", "updated_at", "archived_at"},
+}
+
+// SchemaVersion is the version of the tables required by this version of GTM.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate creates or upgrades the tables of the dialect to SchemaVersion, and records the versions applied.
+// It returns an error if the tables are migrated by a newer version of GTM, or the live schema is incompatible.
+func migrate(db *sql.DB, d Dialect, logger Logger) error {
+	statement := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v integer NOT NULL PRIMARY KEY, %v varchar(100) NOT NULL)",
+		d.Quote(schemaVersionTable), d.Quote("version"), d.Quote("description"))
+	if _, err := db.Exec(statement); err != nil {
+		return fmt.Errorf("create schema version table err: %v", err)
+	}
+
+	var current int
+	statement = fmt.Sprintf("SELECT COALESCE(MAX(%v), 0) FROM %v", d.Quote("version"), d.Quote(schemaVersionTable))
+	if err := db.QueryRow(statement).Scan(&current); err != nil {
+		return fmt.Errorf("query schema version err: %v", err)
+	}
+	if current > SchemaVersion() {
+		return fmt.Errorf("schema version %v is newer than %v, upgrade GTM", current, SchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := m.migrate(db, d); err != nil {
+			return fmt.Errorf("migrate to version %v err: %v, %v", m.version, m.description, err)
+		}
+
+		statement := insertStatement(d, schemaVersionTable, []string{"version", "description"})
+		if _, err := db.Exec(statement, m.version, m.description); err != nil {
+			return fmt.Errorf("save schema version err: %v, %v", m.version, err)
+		}
+
+		logTo(logger, LevelInfo, "schema migrated", "dialect", d.Name(), "version", m.version, "description", m.description)
+	}
+
+	return verifySchema(db, d)
+}
+
+// verifySchema returns an error naming the columns missing from the live schema.
+func verifySchema(db *sql.DB, d Dialect) error {
+	var missing []string
+	for _, table := range []string{"gtm_transactions", "gtm_partner_result", "gtm_resolution", "gtm_transactions_archive", "gtm_partner_result_archive"} {
+		for _, column := range schemaColumns[table] {
+			ok, err := hasColumn(db, d, table, column)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("incompatible schema, missing columns: %v", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// hasColumn reports whether the column exists, by the columns of an empty selection of the table.
+// A missing table is reported as an error too.
+func hasColumn(db *sql.DB, d Dialect, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %v WHERE 1=0", d.Quote(table)))
+	if err != nil {
+		return false, fmt.Errorf("query columns err: %v, %v", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, fmt.Errorf("query columns err: %v, %v", table, err)
+	}
+
+	for _, c := range columns {
+		if strings.EqualFold(c, column) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// mysqlColumn returns the data type and the datetime precision of the column in the current database of MySQL.
+func mysqlColumn(db *sql.DB, table, column string) (dataType string, precision int, err error) {
+	var p sql.NullInt64
+	statement := "SELECT `DATA_TYPE`, `DATETIME_PRECISION` FROM `information_schema`.`COLUMNS` " +
+		"WHERE `TABLE_SCHEMA` = DATABASE() AND `TABLE_NAME` = ? AND `COLUMN_NAME` = ?"
+	if err := db.QueryRow(statement, table, column).Scan(&dataType, &p); err != nil {
+		return "", 0, fmt.Errorf("query column err: %v.%v, %v", table, column, err)
+	}
+
+	return strings.ToLower(dataType), int(p.Int64), nil
+}
+
+// createTables creates the tables of the dialect which do not exist, with the columns of SchemaVersion.
+func createTables(db *sql.DB, d Dialect) error {
+	for _, statement := range d.CreateTables() {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("create table err: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// addColumn returns a migration adding the column to the table if it does not exist.
+func addColumn(table, column, definition string) func(db *sql.DB, d Dialect) error {
+	return func(db *sql.DB, d Dialect) error {
+		if ok, err := hasColumn(db, d, table, column); err != nil || ok {
+			return err
+		}
+
+		statement := fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", d.Quote(table), d.Quote(column), definition)
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("add column err: %v", err)
+		}
+
+		return nil
+	}
+}
+
+// resultToVarchar changes the result columns of the tables created with enum by the old documents of MySQL,
+// which can not save the results added later, such as Dead and Suspended.
+// The result columns of the other dialects are created as varchar.
+func resultToVarchar(db *sql.DB, d Dialect) error {
+	if d.Name() != (MySQLDialect{}).Name() {
+		return nil
+	}
+
+	for _, table := range []string{"gtm_transactions", "gtm_partner_result"} {
+		dataType, _, err := mysqlColumn(db, table, "result")
+		if err != nil {
+			return err
+		}
+		if dataType == "varchar" {
+			continue
+		}
+
+		statement := fmt.Sprintf("ALTER TABLE %v MODIFY %v varchar(20) NOT NULL", d.Quote(table), d.Quote("result"))
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("modify column err: %v, %v", table, err)
+		}
+	}
+
+	return nil
+}
+
+// retryAtToMicroseconds changes the retry_at column of MySQL created as timestamp by the old documents,
+// which loses the order of the retries within a second, to timestamp(6).
+// The retry_at columns of the other dialects are created with microseconds.
+func retryAtToMicroseconds(db *sql.DB, d Dialect) error {
+	if d.Name() != (MySQLDialect{}).Name() {
+		return nil
+	}
+
+	if _, precision, err := mysqlColumn(db, "gtm_transactions", "retry_at"); err != nil || precision >= 6 {
+		return err
+	}
+
+	statement := "ALTER TABLE `gtm_transactions` MODIFY `retry_at` timestamp(6) NOT NULL"
+	if _, err := db.Exec(statement); err != nil {
+		return fmt.Errorf("modify column err: %v", err)
+	}
+
+	return nil
+}
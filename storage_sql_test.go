@@ -0,0 +1,250 @@
+package gtm_test
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/quanhengzhuang/gtm"
+	"github.com/quanhengzhuang/gtm/gtmtest"
+)
+
+var (
+	_ gtm.Storage = &gtm.SQLStorage{}
+)
+
+func openSQLite(t *testing.T) *gtm.SQLStorage {
+	s := gtm.NewSQLStorage(openSQLiteDB(t), gtm.SQLiteDialect{})
+	s.Register(&Payer{})
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("migrate err: %v", err)
+	}
+
+	return s
+}
+
+func openSQLiteDB(t *testing.T) *sql.DB {
+	dir, err := ioutil.TempDir("", "gtm-sqlite")
+	if err != nil {
+		t.Fatalf("temp dir err: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "gtm.db"))
+	if err != nil {
+		t.Fatalf("open err: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestSQLStorageSuite(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		return openSQLite(t)
+	})
+}
+
+func TestSQLStorageSuiteJSON(t *testing.T) {
+	gtmtest.RunStorageSuite(t, func(t *testing.T) gtm.Storage {
+		return openSQLite(t).SetCodec(&gtm.JSONCodec{})
+	})
+}
+
+func TestSQLStorageMixedCodecs(t *testing.T) {
+	s := openSQLite(t)
+
+	for _, codec := range []gtm.Codec{gtm.GobCodec{}, &gtm.JSONCodec{}} {
+		tx := &gtm.Transaction{Name: codec.Name(), RetryAt: time.Now().Add(-time.Second)}
+		tx.AddNormal(&Payer{OrderID: codec.Name()})
+		if _, err := s.SetCodec(codec).SaveTransaction(tx); err != nil {
+			t.Fatalf("save err: %v", err)
+		}
+	}
+
+	// The content of gob is decoded by the registered codec.
+	txs, err := s.GetTimeoutTransactions(10)
+	if err != nil || len(txs) != 2 {
+		t.Fatalf("GetTimeoutTransactions() = %v, %v, want 2 transactions", len(txs), err)
+	}
+	for _, tx := range txs {
+		if tx.NormalPartners[0].(*Payer).OrderID != tx.Name {
+			t.Errorf("decoded partner = %+v, want the order id %v", tx.NormalPartners[0], tx.Name)
+		}
+	}
+}
+
+func TestSQLStorageUndecodable(t *testing.T) {
+	db := openSQLiteDB(t)
+	s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{})
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("migrate err: %v", err)
+	}
+
+	var ids []string
+	for _, orderID := range []string{"100001", "100002"} {
+		tx := &gtm.Transaction{Name: "test-undecodable", RetryAt: time.Now().Add(-time.Second)}
+		tx.AddNormal(&Payer{OrderID: orderID})
+		id, err := s.SaveTransaction(tx)
+		if err != nil {
+			t.Fatalf("save err: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := db.Exec("UPDATE gtm_transactions SET content=? WHERE id=?", "undecodable", ids[0]); err != nil {
+		t.Fatalf("update err: %v", err)
+	}
+
+	// The undecodable transaction does not block the others.
+	txs, err := s.GetTimeoutTransactions(10)
+	if err != nil || len(txs) != 1 || txs[0].ID != ids[1] {
+		t.Fatalf("GetTimeoutTransactions() = %v, %v, want only %v", txs, err, ids[1])
+	}
+
+	var decodeErr *gtm.DecodeError
+	if _, _, err := s.GetTransaction(ids[0]); !errors.As(err, &decodeErr) || decodeErr.ID != ids[0] {
+		t.Errorf("GetTransaction() err = %v, want the DecodeError of %v", err, ids[0])
+	}
+}
+
+func TestSQLStorage(t *testing.T) {
+	m := gtm.NewManager(openSQLite(t))
+
+	tx := m.New("test-sql").AddNormal(&Payer{OrderID: "100001", UserID: 20001, Amount: 99})
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tx := m.New("test-sql").AddNormal(&Payer{OrderID: "100002", UserID: 20001, Amount: 99})
+		if err := tx.ExecuteAsync(); err != nil {
+			t.Fatalf("execute async err: %v", err)
+		}
+	}
+
+	txs, results, errs, err := m.RetryTimeoutTransactions(2)
+	if err != nil || len(txs) != 2 {
+		t.Fatalf("retry = %v, err = %v, want 2 transactions", len(txs), err)
+	}
+
+	for k, tx := range txs {
+		if results[k] != gtm.Success || tx.NormalPartners[0].(*Payer).OrderID != "100002" {
+			t.Errorf("retry id = %v, result = %v, err = %v", tx.ID, results[k], errs[k])
+		}
+	}
+}
+
+func TestSQLStoragePartnerResult(t *testing.T) {
+	s := openSQLite(t)
+
+	tx := &gtm.Transaction{Name: "test-sql", RetryAt: time.Now()}
+	id, err := s.SaveTransaction(tx)
+	if err != nil {
+		t.Fatalf("save err: %v", err)
+	}
+	tx.ID = id
+
+	if result, err := s.GetPartnerResult(tx, "do-normal", 0); result != "" || err != nil {
+		t.Errorf("result = %v, err = %v, want empty", result, err)
+	}
+
+	for _, result := range []gtm.Result{gtm.Uncertain, gtm.Success} {
+		if err := s.SavePartnerResult(tx, "do-normal", 0, time.Second, result); err != nil {
+			t.Fatalf("save partner err: %v", err)
+		}
+	}
+
+	if result, _ := s.GetPartnerResult(tx, "do-normal", 0); result != gtm.Success {
+		t.Errorf("result = %v, want success", result)
+	}
+}
+
+func TestSQLStorageMigrate(t *testing.T) {
+	db := openSQLiteDB(t)
+
+	// The tables of the first version, created by hand.
+	for _, statement := range []string{
+		`CREATE TABLE gtm_transactions (id integer PRIMARY KEY AUTOINCREMENT, name varchar(50) NOT NULL, times integer NOT NULL,
+			retry_at datetime NOT NULL, timeout integer NOT NULL, result varchar(20) NOT NULL, content text,
+			created_at datetime NOT NULL, updated_at datetime NOT NULL)`,
+		`CREATE TABLE gtm_partner_result (id integer PRIMARY KEY AUTOINCREMENT, transaction_id integer NOT NULL,
+			phase varchar(20) NOT NULL, offset integer NOT NULL, result varchar(20) NOT NULL, cost integer NOT NULL,
+			created_at datetime NOT NULL, updated_at datetime NOT NULL, UNIQUE (transaction_id, phase, offset))`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("create table err: %v", err)
+		}
+	}
+
+	s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{})
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(); err != nil {
+			t.Fatalf("Migrate() err = %v", err)
+		}
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM gtm_schema_version").Scan(&version); err != nil || version != gtm.SchemaVersion() {
+		t.Errorf("schema version = %v, %v, want %v", version, err, gtm.SchemaVersion())
+	}
+
+	tx := &gtm.Transaction{Name: "test-migrate", RetryAt: time.Now().Add(-time.Second)}
+	if _, err := s.SaveTransaction(tx); err != nil {
+		t.Fatalf("save err: %v", err)
+	}
+	if txs, err := s.ClaimTimeoutTransactions("owner", 10, time.Minute); err != nil || len(txs) != 1 {
+		t.Errorf("ClaimTimeoutTransactions() after migrate = %v, %v, want 1 transaction", len(txs), err)
+	}
+	if err := s.SaveResolution(tx, &gtm.Resolution{Action: gtm.ActionSuspend, CreatedAt: time.Now()}); err != nil {
+		t.Errorf("SaveResolution() after migrate err = %v", err)
+	}
+}
+
+func TestSQLStorageMigrateIncompatible(t *testing.T) {
+	db := openSQLiteDB(t)
+	if _, err := db.Exec("CREATE TABLE gtm_transactions (id integer PRIMARY KEY, name varchar(50) NOT NULL)"); err != nil {
+		t.Fatalf("create table err: %v", err)
+	}
+
+	err := gtm.NewSQLStorage(db, gtm.SQLiteDialect{}).Migrate()
+	if err == nil || !strings.Contains(err.Error(), "gtm_transactions.content") {
+		t.Errorf("Migrate() err = %v, want the missing columns", err)
+	}
+}
+
+func TestSQLStorageMigrateNewer(t *testing.T) {
+	db := openSQLiteDB(t)
+	s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{})
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("Migrate() err = %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO gtm_schema_version (version, description) VALUES (?, ?)", gtm.SchemaVersion()+1, "newer"); err != nil {
+		t.Fatalf("insert version err: %v", err)
+	}
+
+	if err := s.Migrate(); err == nil {
+		t.Errorf("Migrate() of a newer schema returns no error")
+	}
+}
+
+func TestSQLStorageMigrateMissingTable(t *testing.T) {
+	db := openSQLiteDB(t)
+	s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{})
+	if err := s.Migrate(); err != nil {
+		t.Fatalf("Migrate() err = %v", err)
+	}
+	if _, err := db.Exec("DROP TABLE gtm_resolution"); err != nil {
+		t.Fatalf("drop table err: %v", err)
+	}
+
+	err := s.Migrate()
+	if err == nil || !strings.Contains(err.Error(), "query columns err: gtm_resolution") {
+		t.Errorf("Migrate() err = %v, want the error of the query", err)
+	}
+}
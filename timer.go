@@ -2,13 +2,31 @@ package gtm
 
 import (
 	"math"
+	"math/rand"
 	"time"
 )
 
+func init() {
+	Register(&DoubleTimer{}, &BackoffTimer{}, &FixedTimer{}, &LinearTimer{})
+}
+
+// Timer calculates the next retry time of a transaction.
+// Times is the number of executions so far, and 0 for the first execution.
+// The retry time must not be earlier than minInterval from now, which is the timeout of the transaction.
 type Timer interface {
 	CalcRetryTime(times int, minInterval time.Duration) time.Time
 }
 
+var (
+	_ Timer = &DoubleTimer{}
+	_ Timer = &BackoffTimer{}
+	_ Timer = &FixedTimer{}
+	_ Timer = &LinearTimer{}
+)
+
+// maxInterval is the largest interval a Timer returns, to avoid overflow.
+const maxInterval = time.Duration(math.MaxInt64)
+
 type DoubleTimer struct {
 }
 
@@ -20,3 +38,147 @@ func (t *DoubleTimer) CalcRetryTime(times int, minInterval time.Duration) time.T
 
 	return time.Now().Add(interval)
 }
+
+// Jitter is the strategy of randomizing the intervals of BackoffTimer,
+// so that the transactions failed at the same time are not retried in lockstep.
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.
+type Jitter string
+
+const (
+	// NoJitter uses the exponential interval as is.
+	NoJitter Jitter = ""
+	// FullJitter picks an interval between 0 and the exponential interval.
+	FullJitter Jitter = "full"
+	// EqualJitter keeps half of the exponential interval, and picks the other half randomly.
+	EqualJitter Jitter = "equal"
+	// DecorrelatedJitter picks an interval between Base and 3 times the previous exponential interval.
+	DecorrelatedJitter Jitter = "decorrelated"
+)
+
+// BackoffTimer retries with exponentially growing intervals: Base * Multiplier^times, capped by Max.
+// The fields are exported so that the timer can be saved with the transaction.
+type BackoffTimer struct {
+	// Base is the interval of times 0, 1 second if it is 0.
+	Base time.Duration
+	// Multiplier is the growth factor of the intervals, 2 if it is 0.
+	Multiplier float64
+	// Max caps the intervals, no cap if it is 0.
+	Max time.Duration
+	// Jitter randomizes the intervals.
+	Jitter Jitter
+}
+
+// NewBackoffTimer returns a *BackoffTimer doubling the interval from base up to max.
+func NewBackoffTimer(base, max time.Duration, jitter Jitter) *BackoffTimer {
+	return &BackoffTimer{Base: base, Multiplier: 2, Max: max, Jitter: jitter}
+}
+
+func (t *BackoffTimer) CalcRetryTime(times int, minInterval time.Duration) time.Time {
+	base := t.Base
+	if base <= 0 {
+		base = time.Second
+	}
+
+	var interval time.Duration
+	switch t.Jitter {
+	case FullJitter:
+		interval = randomInterval(0, t.backoff(base, times))
+	case EqualJitter:
+		half := t.backoff(base, times) / 2
+		interval = half + randomInterval(0, half)
+	case DecorrelatedJitter:
+		interval = randomInterval(base, t.cap(3*float64(t.backoff(base, times-1))))
+	default:
+		interval = t.backoff(base, times)
+	}
+
+	if interval < minInterval {
+		interval = minInterval
+	}
+
+	return time.Now().Add(interval)
+}
+
+// backoff returns the capped exponential interval of times.
+func (t *BackoffTimer) backoff(base time.Duration, times int) time.Duration {
+	multiplier := t.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	if times < 0 {
+		times = 0
+	}
+
+	return t.cap(float64(base) * math.Pow(multiplier, float64(times)))
+}
+
+func (t *BackoffTimer) cap(interval float64) time.Duration {
+	if t.Max > 0 && interval > float64(t.Max) {
+		return t.Max
+	}
+	if interval >= float64(maxInterval) {
+		return maxInterval
+	}
+
+	return time.Duration(interval)
+}
+
+// FixedTimer retries with the same interval every time.
+type FixedTimer struct {
+	Interval time.Duration
+}
+
+// NewFixedTimer returns a *FixedTimer retrying every interval.
+func NewFixedTimer(interval time.Duration) *FixedTimer {
+	return &FixedTimer{Interval: interval}
+}
+
+func (t *FixedTimer) CalcRetryTime(times int, minInterval time.Duration) time.Time {
+	interval := t.Interval
+	if interval < minInterval {
+		interval = minInterval
+	}
+
+	return time.Now().Add(interval)
+}
+
+// LinearTimer retries with linearly growing intervals: Initial + Step * times, capped by Max.
+type LinearTimer struct {
+	Initial time.Duration
+	Step    time.Duration
+	// Max caps the intervals, no cap if it is 0.
+	Max time.Duration
+}
+
+// NewLinearTimer returns a *LinearTimer growing the interval from initial by step up to max.
+func NewLinearTimer(initial, step, max time.Duration) *LinearTimer {
+	return &LinearTimer{Initial: initial, Step: step, Max: max}
+}
+
+func (t *LinearTimer) CalcRetryTime(times int, minInterval time.Duration) time.Time {
+	interval := float64(t.Initial) + float64(t.Step)*float64(times)
+	if t.Max > 0 && interval > float64(t.Max) {
+		interval = float64(t.Max)
+	}
+	if interval >= float64(maxInterval) {
+		interval = float64(maxInterval)
+	}
+
+	if time.Duration(interval) < minInterval {
+		return time.Now().Add(minInterval)
+	}
+
+	return time.Now().Add(time.Duration(interval))
+}
+
+// randomInterval returns a random interval in [min, max].
+func randomInterval(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	if max-min == maxInterval {
+		return min + time.Duration(rand.Int63())
+	}
+
+	return min + time.Duration(rand.Int63n(int64(max-min)+1))
+}
@@ -0,0 +1,108 @@
+package gtm_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// interval returns the interval to the retry time of the timer, measured after the call.
+// It may be a little less than the interval calculated, but never more.
+func interval(timer gtm.Timer, times int, minInterval time.Duration) time.Duration {
+	retryAt := timer.CalcRetryTime(times, minInterval)
+	return retryAt.Sub(time.Now())
+}
+
+func TestBackoffTimer(t *testing.T) {
+	const slack = time.Second
+
+	cases := []struct {
+		timer    gtm.Timer
+		times    int
+		min, max time.Duration
+	}{
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.NoJitter), 0, time.Second, time.Second},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.NoJitter), 3, 8 * time.Second, 8 * time.Second},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.NoJitter), 100, time.Hour, time.Hour},
+		{gtm.NewBackoffTimer(time.Second, 0, gtm.NoJitter), 10000, 100 * 365 * 24 * time.Hour, 1<<63 - 1},
+		{&gtm.BackoffTimer{Base: time.Second, Multiplier: 3, Max: time.Hour}, 2, 9 * time.Second, 9 * time.Second},
+		{&gtm.BackoffTimer{}, 1, 2 * time.Second, 2 * time.Second},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.FullJitter), 4, 0, 16 * time.Second},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.FullJitter), 100, 0, time.Hour},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.EqualJitter), 4, 8 * time.Second, 16 * time.Second},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.DecorrelatedJitter), 4, time.Second, 24 * time.Second},
+		{gtm.NewBackoffTimer(time.Second, time.Hour, gtm.DecorrelatedJitter), 100, time.Second, time.Hour},
+	}
+
+	for _, c := range cases {
+		for i := 0; i < 100; i++ {
+			if d := interval(c.timer, c.times, 0); d < c.min-slack || d > c.max {
+				t.Fatalf("%+v.CalcRetryTime(%v) interval = %v, want [%v, %v]", c.timer, c.times, d, c.min, c.max)
+			}
+		}
+	}
+}
+
+func TestTimerMinInterval(t *testing.T) {
+	timers := []gtm.Timer{
+		&gtm.DoubleTimer{},
+		gtm.NewBackoffTimer(time.Second, time.Minute, gtm.FullJitter),
+		gtm.NewFixedTimer(time.Second),
+		gtm.NewLinearTimer(time.Second, time.Second, time.Minute),
+	}
+
+	for _, timer := range timers {
+		if d := interval(timer, 1, time.Hour); d < time.Hour-time.Second {
+			t.Errorf("%T.CalcRetryTime() interval = %v, want at least the min interval", timer, d)
+		}
+	}
+}
+
+func TestFixedAndLinearTimer(t *testing.T) {
+	cases := []struct {
+		timer gtm.Timer
+		times int
+		want  time.Duration
+	}{
+		{gtm.NewFixedTimer(time.Minute), 0, time.Minute},
+		{gtm.NewFixedTimer(time.Minute), 100, time.Minute},
+		{gtm.NewLinearTimer(time.Second, 10*time.Second, time.Hour), 0, time.Second},
+		{gtm.NewLinearTimer(time.Second, 10*time.Second, time.Hour), 3, 31 * time.Second},
+		{gtm.NewLinearTimer(time.Second, 10*time.Second, time.Hour), 1000, time.Hour},
+	}
+
+	for _, c := range cases {
+		if d := interval(c.timer, c.times, 0); d < c.want-time.Second || d > c.want {
+			t.Errorf("%+v.CalcRetryTime(%v) interval = %v, want %v", c.timer, c.times, d, c.want)
+		}
+	}
+}
+
+func TestTransactionTimer(t *testing.T) {
+	tx := gtm.New("test-tx-timer").SetTimer(gtm.NewFixedTimer(2 * time.Hour))
+	tx.AddNormal(&Payer{OrderID: "100003", UserID: 20001, Amount: 99})
+
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if d := time.Until(tx.RetryAt); d < time.Hour || d > 2*time.Hour {
+		t.Errorf("retry at %v later, want by the timer of the transaction", d)
+	}
+
+	// The timer is saved with the transaction.
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(tx); err != nil {
+		t.Fatalf("gob encode err: %v", err)
+	}
+
+	var decoded gtm.Transaction
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatalf("gob decode err: %v", err)
+	}
+	if timer, ok := decoded.Timer.(*gtm.FixedTimer); !ok || timer.Interval != 2*time.Hour {
+		t.Errorf("decoded timer = %#v, want the fixed timer", decoded.Timer)
+	}
+}
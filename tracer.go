@@ -0,0 +1,107 @@
+package gtm
+
+import "context"
+
+// Tracer starts the spans of the executions of the transactions.
+// An execution has a span named gtm.Execute, gtm.ExecuteRetry or gtm.ExecuteAsync,
+// with a child span for each partner call named by the phase, such as gtm.do-normal,
+// and for each storage call named by the method of Storage, such as gtm.storage.SavePartnerResult.
+// The context of the partner span is passed to the context-aware partners.
+// See the package extra/gtmotel for OpenTelemetry.
+type Tracer interface {
+	// Start a span as a child of the span in the context, and return the context with the new span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+
+	// End the span, with the error of the operation if it failed.
+	End(err error)
+}
+
+// Attribute is a key-value pair of a span, the value is a string, an int or a bool.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Keys of the attributes of the spans.
+const (
+	AttrTransactionID    = "gtm.transaction.id"
+	AttrTransactionName  = "gtm.transaction.name"
+	AttrTransactionTimes = "gtm.transaction.times"
+	AttrResult           = "gtm.result"
+	AttrPhase            = "gtm.phase"
+	AttrOffset           = "gtm.offset"
+	AttrPartner          = "gtm.partner"
+)
+
+// SetTracer sets the tracer of the transactions of the default manager.
+func SetTracer(t Tracer) {
+	defaultManager.SetTracer(t)
+}
+
+// SetTracer sets the tracer of the transactions of the manager, nil disables tracing.
+func (m *Manager) SetTracer(t Tracer) *Manager {
+	m.tracer = t
+	return m
+}
+
+// nopSpan is the span used when there is no tracer.
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs ...Attribute) {}
+func (nopSpan) End(err error)                    {}
+
+// startSpan starts a child span of the context of the transaction.
+func (tx *Transaction) startSpan(name string, attrs ...Attribute) (context.Context, Span) {
+	t := tx.Manager().tracer
+	if t == nil {
+		return tx.Context(), nopSpan{}
+	}
+
+	attrs = append([]Attribute{{AttrTransactionID, tx.ID}, {AttrTransactionName, tx.Name}}, attrs...)
+	return t.Start(tx.Context(), name, attrs...)
+}
+
+// traceExecution starts the span of an execution, and sets its context to the transaction,
+// so that the spans of the partners and the storage calls are its children.
+// The returned function ends the span with the result.
+func (tx *Transaction) traceExecution(ctx context.Context, name string) (end func(result Result, err error)) {
+	tx.ctx = ctx
+
+	ctx, span := tx.startSpan(name)
+	tx.ctx = ctx
+
+	return func(result Result, err error) {
+		span.SetAttributes(Attribute{AttrTransactionID, tx.ID}, Attribute{AttrTransactionTimes, tx.Times})
+		if result != "" {
+			span.SetAttributes(Attribute{AttrResult, string(result)})
+		}
+		span.End(err)
+	}
+}
+
+// callStorage calls the operation of the storage in a span, and notifies the listeners of the error.
+// With a tracer, fn is called with a copy of the transaction whose context has the span,
+// so that the spans of the storage instrumentation are its children.
+func (tx *Transaction) callStorage(operation string, fn func(tx *Transaction) error) error {
+	ctx, span := tx.startSpan("gtm.storage." + operation)
+
+	call := tx
+	if tx.Manager().tracer != nil {
+		data := *tx
+		data.ctx = ctx
+		call = &data
+	}
+
+	err := fn(call)
+	if err != nil {
+		tx.storageFailed(operation, err)
+	}
+
+	span.End(err)
+	return err
+}
@@ -0,0 +1,175 @@
+package gtm_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+type spanKey struct{}
+
+// RecordedSpan is a span recorded by the SpanRecorder.
+type RecordedSpan struct {
+	Name   string
+	Parent string
+	Attrs  map[string]interface{}
+	Err    error
+	Ended  bool
+
+	tracer *SpanRecorder
+}
+
+func (s *RecordedSpan) SetAttributes(attrs ...gtm.Attribute) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for _, a := range attrs {
+		s.Attrs[a.Key] = a.Value
+	}
+}
+
+func (s *RecordedSpan) End(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Err, s.Ended = err, true
+}
+
+// SpanRecorder is a tracer recording the spans.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+func (r *SpanRecorder) Start(ctx context.Context, name string, attrs ...gtm.Attribute) (context.Context, gtm.Span) {
+	span := &RecordedSpan{Name: name, Attrs: map[string]interface{}{}, tracer: r}
+	if parent, ok := ctx.Value(spanKey{}).(*RecordedSpan); ok {
+		span.Parent = parent.Name
+	}
+	span.SetAttributes(attrs...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Find returns the spans of the name.
+func (r *SpanRecorder) Find(name string) (spans []*RecordedSpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.spans {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// SpanPartner is a context-aware certain partner recording the span it is called in.
+type SpanPartner struct {
+	span string
+}
+
+func (p *SpanPartner) DoNext() error {
+	return errors.New("DoNextContext is not called")
+}
+
+func (p *SpanPartner) DoNextContext(ctx context.Context) error {
+	if span, ok := ctx.Value(spanKey{}).(*RecordedSpan); ok {
+		p.span = span.Name
+	}
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &SpanRecorder{}
+	m := gtm.NewManager(gtm.DefaultManager().Storage()).SetTracer(tracer)
+
+	partner := &SpanPartner{}
+	tx := m.New("test-tracer").AddNormal(&Counter{Result: gtm.Success}).AddCertain(partner)
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+
+	if partner.span != "gtm.doNext" {
+		t.Errorf("partner called in span %q, want gtm.doNext", partner.span)
+	}
+
+	executions := tracer.Find("gtm.Execute")
+	if len(executions) != 1 || !executions[0].Ended || executions[0].Attrs[gtm.AttrTransactionID] != tx.ID ||
+		executions[0].Attrs[gtm.AttrResult] != "success" || executions[0].Attrs[gtm.AttrTransactionTimes] != 1 {
+		t.Fatalf("execute spans = %+v, want 1 ended span with the id and the result", executions)
+	}
+
+	var children []string
+	for _, s := range tracer.spans {
+		if s.Parent == "gtm.Execute" {
+			children = append(children, s.Name)
+		}
+	}
+	sort.Strings(children)
+	want := []string{
+		"gtm.do-normal", "gtm.doNext", "gtm.doNext",
+		"gtm.storage.SavePartnerResult", "gtm.storage.SavePartnerResult", "gtm.storage.SavePartnerResult",
+		"gtm.storage.SaveTransaction",
+	}
+	if !reflect.DeepEqual(children, want) {
+		t.Errorf("children of gtm.Execute = %q, want %q", children, want)
+	}
+
+	normal := tracer.Find("gtm.do-normal")[0]
+	if normal.Attrs[gtm.AttrPhase] != gtm.PhaseDoNormal || normal.Attrs[gtm.AttrOffset] != 0 ||
+		normal.Attrs[gtm.AttrResult] != "success" || normal.Attrs[gtm.AttrTransactionID] != tx.ID {
+		t.Errorf("do-normal span attributes = %v", normal.Attrs)
+	}
+}
+
+// SpanStorage is a storage recording the spans in the contexts of the transactions it is called with.
+type SpanStorage struct {
+	*gtm.MemoryStorage
+
+	mu    sync.Mutex
+	spans []string
+}
+
+func (s *SpanStorage) record(tx *gtm.Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if span, ok := tx.Context().Value(spanKey{}).(*RecordedSpan); ok {
+		s.spans = append(s.spans, span.Name)
+	}
+}
+
+func (s *SpanStorage) SaveTransaction(tx *gtm.Transaction) (string, error) {
+	s.record(tx)
+	return s.MemoryStorage.SaveTransaction(tx)
+}
+
+func (s *SpanStorage) SavePartnerResult(tx *gtm.Transaction, phase string, offset int, cost time.Duration, result gtm.Result) error {
+	s.record(tx)
+	return s.MemoryStorage.SavePartnerResult(tx, phase, offset, cost, result)
+}
+
+func TestTracerStorageContext(t *testing.T) {
+	s := &SpanStorage{MemoryStorage: gtm.NewMemoryStorage()}
+	m := gtm.NewManager(s).SetTracer(&SpanRecorder{})
+
+	tx := m.New("test-tracer-storage").AddNormal(&Counter{Result: gtm.Success})
+	if result, err := tx.Execute(); result != gtm.Success {
+		t.Fatalf("result = %v, err = %v, want success", result, err)
+	}
+	if tx.ID == "" {
+		t.Errorf("ID is empty after Execute()")
+	}
+
+	want := []string{"gtm.storage.SaveTransaction", "gtm.storage.SavePartnerResult", "gtm.storage.SavePartnerResult"}
+	if !reflect.DeepEqual(s.spans, want) {
+		t.Errorf("storage called in spans %q, want %q", s.spans, want)
+	}
+}
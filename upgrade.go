@@ -0,0 +1,278 @@
+package gtm
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// UpgradeFunc upgrades a partner decoded from the content saved by the previous version of the partner,
+// and returns the partner of the next version, which may be the same value changed in place.
+//
+// The partner is of the type of the previous version registered by RegisterUpgrade,
+// or of the current type if it is nil, whose new fields are zero and the removed fields are dropped.
+type UpgradeFunc func(partner interface{}) (interface{}, error)
+
+type upgrade struct {
+	previous reflect.Type
+	fn       UpgradeFunc
+}
+
+// previousVersion is the name and the version of a type registered as the previous version of a partner.
+type previousVersion struct {
+	name    string
+	version int
+}
+
+// upgrades records the upgrade functions by the partner names and versions.
+var upgrades = struct {
+	sync.RWMutex
+	m        map[string]map[int]upgrade
+	previous map[reflect.Type]previousVersion
+}{
+	m:        make(map[string]map[int]upgrade),
+	previous: make(map[reflect.Type]previousVersion),
+}
+
+// RegisterUpgrade records the function upgrading the partner of the name from the version to version+1.
+// The name is the one registered by RegisterPartner or Register.
+// The previous is a value of the type of the version, e.g. &PayerV1{}, the content saved by the version is decoded into it,
+// so that the renamed and removed fields are passed to fn. The content is decoded into the current type if it is nil.
+//
+// A partner is of version 1 until an upgrade is registered, and its current version is the last version upgraded to.
+// The upgrades must be registered for every version before it, otherwise the transactions of the versions fail to retry.
+// The versions of the partners are saved with each transaction,
+// and the partners are upgraded to the current versions before they are retried,
+// and saved again if the storage implements UpgradeStorage.
+//
+// The content encoded by GobCodec of the versions before the partners are named is always decoded into the current type.
+func RegisterUpgrade(name string, version int, previous interface{}, fn UpgradeFunc) {
+	if version < 1 {
+		panic("gtm: RegisterUpgrade with a version less than 1")
+	}
+	if fn == nil {
+		panic("gtm: RegisterUpgrade with a nil function")
+	}
+
+	u := upgrade{fn: fn}
+	if previous != nil {
+		u.previous = reflect.TypeOf(previous)
+
+		// Only decoded, the values of the type are not encoded by the name.
+		types.Lock()
+		types.types[previousName(name, version)] = u.previous
+		types.Unlock()
+	}
+
+	upgrades.Lock()
+	defer upgrades.Unlock()
+
+	if upgrades.m[name] == nil {
+		upgrades.m[name] = make(map[int]upgrade)
+	}
+	upgrades.m[name][version] = u
+	if u.previous != nil {
+		upgrades.previous[u.previous] = previousVersion{name: name, version: version}
+	}
+}
+
+// PartnerVersion returns the current version of the partner of the name.
+func PartnerVersion(name string) int {
+	upgrades.RLock()
+	defer upgrades.RUnlock()
+
+	version := 1
+	for v := range upgrades.m[name] {
+		if v+1 > version {
+			version = v + 1
+		}
+	}
+
+	return version
+}
+
+func getUpgrade(name string, version int) (upgrade, bool) {
+	upgrades.RLock()
+	defer upgrades.RUnlock()
+
+	u, ok := upgrades.m[name][version]
+	return u, ok
+}
+
+// previousName is the name of the type of a previous version in the types registry, which is only decoded.
+func previousName(name string, version int) string {
+	return fmt.Sprintf("%v@%v", name, version)
+}
+
+// decodeName returns the name to decode the value of the type name saved with the versions,
+// which is the type of the saved version registered by RegisterUpgrade, or the type name itself.
+func decodeName(typeName string, versions map[string]int) string {
+	types.RLock()
+	t, ok := types.types[typeName]
+	name := types.names[t]
+	types.RUnlock()
+	if !ok || name == "" {
+		return typeName
+	}
+
+	version := versions[name]
+	if version == 0 {
+		version = 1
+	}
+	if u, ok := getUpgrade(name, version); ok && u.previous != nil {
+		return previousName(name, version)
+	}
+
+	return typeName
+}
+
+// setVersions records the current versions of the partners to be saved with the transaction.
+// The partners of version 1 are omitted.
+func (tx *Transaction) setVersions() {
+	tx.Versions = nil
+	tx.eachPartner(func(partner interface{}) {
+		name, err := registeredName(partner)
+		if err != nil {
+			return
+		}
+		if version := PartnerVersion(name); version > 1 {
+			if tx.Versions == nil {
+				tx.Versions = make(map[string]int)
+			}
+			tx.Versions[name] = version
+		}
+	})
+}
+
+// upgrade upgrades the partners from the saved versions to the current versions,
+// and saves them if the storage implements UpgradeStorage.
+// It is a no-op for the transaction already upgraded.
+func (tx *Transaction) upgrade() error {
+	changed := false
+	upgradePartner := func(p interface{}) (interface{}, error) {
+		partner, ok, err := tx.upgradePartner(p)
+		changed = changed || ok
+		return partner, err
+	}
+
+	for i, p := range tx.NormalPartners {
+		upgraded, err := upgradePartner(p)
+		if err != nil {
+			return err
+		}
+		partner, ok := upgraded.(NormalPartner)
+		if !ok {
+			return fmt.Errorf("upgraded partner is not a NormalPartner: %T", upgraded)
+		}
+		tx.NormalPartners[i] = partner
+	}
+
+	if tx.UncertainPartner != nil {
+		upgraded, err := upgradePartner(tx.UncertainPartner)
+		if err != nil {
+			return err
+		}
+		partner, ok := upgraded.(UncertainPartner)
+		if !ok {
+			return fmt.Errorf("upgraded partner is not an UncertainPartner: %T", upgraded)
+		}
+		tx.UncertainPartner = partner
+	}
+
+	for _, partners := range [][]CertainPartner{tx.CertainPartners, tx.AsyncPartners} {
+		for i, p := range partners {
+			upgraded, err := upgradePartner(p)
+			if err != nil {
+				return err
+			}
+			partner, ok := upgraded.(CertainPartner)
+			if !ok {
+				return fmt.Errorf("upgraded partner is not a CertainPartner: %T", upgraded)
+			}
+			partners[i] = partner
+		}
+	}
+
+	tx.setVersions()
+	if !changed {
+		return nil
+	}
+
+	s, ok := tx.storage().(UpgradeStorage)
+	if !ok {
+		return nil
+	}
+	if err := tx.callStorage("UpdateTransactionContent", func(tx *Transaction) error {
+		return s.UpdateTransactionContent(tx)
+	}); err != nil {
+		return fmt.Errorf("save upgraded transaction err: %v", err)
+	}
+
+	return nil
+}
+
+// upgradePartner upgrades the partner to the current version, and reports whether it is upgraded.
+func (tx *Transaction) upgradePartner(partner interface{}) (interface{}, bool, error) {
+	name, saved, ok := tx.partnerVersion(partner)
+	if !ok {
+		return partner, false, nil
+	}
+
+	current := PartnerVersion(name)
+	if saved > current {
+		return nil, false, fmt.Errorf("partner is saved by a newer version: %v, version %v > %v", name, saved, current)
+	}
+
+	for version := saved; version < current; version++ {
+		u, ok := getUpgrade(name, version)
+		if !ok {
+			return nil, false, fmt.Errorf("upgrade not registered: %v, version %v", name, version)
+		}
+		if u.previous != nil && reflect.TypeOf(partner) != u.previous {
+			return nil, false, fmt.Errorf("partner of version %v is decoded as %T, not %v: %v", version, partner, u.previous, name)
+		}
+
+		var err error
+		if partner, err = u.fn(partner); err != nil {
+			return nil, false, fmt.Errorf("upgrade partner err: %v, version %v, %v", name, version, err)
+		}
+	}
+
+	return partner, saved < current, nil
+}
+
+// partnerVersion returns the registered name and the saved version of the partner.
+func (tx *Transaction) partnerVersion(partner interface{}) (name string, version int, ok bool) {
+	upgrades.RLock()
+	previous, ok := upgrades.previous[reflect.TypeOf(partner)]
+	upgrades.RUnlock()
+	if ok {
+		return previous.name, previous.version, true
+	}
+
+	name, err := registeredName(partner)
+	if err != nil {
+		return "", 0, false
+	}
+
+	if version = tx.Versions[name]; version == 0 {
+		version = 1
+	}
+	return name, version, true
+}
+
+// eachPartner calls fn with each partner of the transaction.
+func (tx *Transaction) eachPartner(fn func(partner interface{})) {
+	for _, partner := range tx.NormalPartners {
+		fn(partner)
+	}
+	if tx.UncertainPartner != nil {
+		fn(tx.UncertainPartner)
+	}
+	for _, partner := range tx.CertainPartners {
+		fn(partner)
+	}
+	for _, partner := range tx.AsyncPartners {
+		fn(partner)
+	}
+}
@@ -0,0 +1,168 @@
+package gtm_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quanhengzhuang/gtm"
+)
+
+// ShipperV1 is the version 1 of Shipper, whose Location is renamed to Address and split to City since version 2.
+type ShipperV1 struct {
+	Location string
+}
+
+func (s *ShipperV1) DoNext() error {
+	return nil
+}
+
+type Shipper struct {
+	Address string
+	City    string
+}
+
+func (s *Shipper) DoNext() error {
+	if s.City == "" {
+		return fmt.Errorf("city is empty")
+	}
+	return nil
+}
+
+// Mover is of version 3, the upgrade from version 1 is missing.
+type Mover struct {
+	OrderID string
+}
+
+func (m *Mover) DoNext() error {
+	return nil
+}
+
+func init() {
+	gtm.RegisterPartner("test.shipper", &Shipper{})
+	gtm.RegisterUpgrade("test.shipper", 1, &ShipperV1{}, func(partner interface{}) (interface{}, error) {
+		s := partner.(*ShipperV1)
+		return &Shipper{Address: s.Location, City: strings.TrimSpace(strings.Split(s.Location, ",")[0])}, nil
+	})
+
+	// The content of version 1 is saved with this name, which has the same length for the gob content.
+	gtm.RegisterPartner("test.shippe1", &ShipperV1{})
+
+	gtm.RegisterPartner("test.mover", &Mover{})
+	gtm.RegisterUpgrade("test.mover", 2, nil, func(partner interface{}) (interface{}, error) {
+		return partner, nil
+	})
+}
+
+func TestUpgrade(t *testing.T) {
+	if v := gtm.PartnerVersion("test.shipper"); v != 2 {
+		t.Fatalf("PartnerVersion() = %v, want 2", v)
+	}
+
+	for _, codec := range []gtm.Codec{gtm.GobCodec{}, &gtm.JSONCodec{}} {
+		db := openSQLiteDB(t)
+		s := gtm.NewSQLStorage(db, gtm.SQLiteDialect{}).SetCodec(codec)
+		if err := s.Migrate(); err != nil {
+			t.Fatalf("migrate err: %v", err)
+		}
+		m := gtm.NewManager(s)
+
+		// Saved by the previous version, without the versions.
+		tx := m.New("test-upgrade").AddCertain(&ShipperV1{Location: "Beijing, Haidian"})
+		tx.RetryAt = time.Now().Add(-time.Second)
+		id, err := s.SaveTransaction(tx)
+		if err != nil {
+			t.Fatalf("save err: %v", err)
+		}
+		var content string
+		if err := db.QueryRow("SELECT content FROM gtm_transactions WHERE id=?", id).Scan(&content); err != nil {
+			t.Fatalf("query content err: %v", err)
+		}
+		content = replaceContent(t, codec, content, "test.shippe1", "test.shipper")
+		if _, err := db.Exec("UPDATE gtm_transactions SET content=? WHERE id=?", content, id); err != nil {
+			t.Fatalf("update content err: %v", err)
+		}
+
+		transactions, results, errs, err := m.RetryTimeoutTransactions(10)
+		if err != nil || len(transactions) != 1 {
+			t.Fatalf("%v RetryTimeoutTransactions() = %v, %v, want 1 transaction", codec.Name(), transactions, err)
+		}
+		if results[0] != gtm.Success {
+			t.Errorf("%v retry result = %v, err = %v, want success", codec.Name(), results[0], errs[0])
+		}
+		if s := transactions[0].CertainPartners[0].(*Shipper); s.Address != "Beijing, Haidian" || s.City != "Beijing" {
+			t.Errorf("%v upgraded partner = %+v, want the address and the city Beijing", codec.Name(), s)
+		}
+
+		// The upgraded partner is saved.
+		saved, _, err := s.GetTransaction(id)
+		if err != nil {
+			t.Fatalf("%v GetTransaction() err = %v", codec.Name(), err)
+		}
+		if v := saved.Versions["test.shipper"]; v != 2 {
+			t.Errorf("%v saved versions = %v, want 2", codec.Name(), saved.Versions)
+		}
+		if s, ok := saved.CertainPartners[0].(*Shipper); !ok || s.City != "Beijing" {
+			t.Errorf("%v saved partner = %+v, want the upgraded one", codec.Name(), saved.CertainPartners[0])
+		}
+	}
+}
+
+func TestUpgradeNewerVersion(t *testing.T) {
+	tx := gtm.NewManager(gtm.NewMemoryStorage()).New("test-upgrade-newer").AddCertain(&Shipper{City: "Beijing"})
+	if err := tx.ExecuteAsync(); err != nil {
+		t.Fatalf("ExecuteAsync() err = %v", err)
+	}
+	if v := tx.Versions["test.shipper"]; v != 2 {
+		t.Errorf("saved versions = %v, want 2", tx.Versions)
+	}
+
+	tx.Versions["test.shipper"] = 3
+	if result, err := tx.ExecuteRetry(); result != gtm.Uncertain || err == nil {
+		t.Errorf("ExecuteRetry() of a newer version = %v, %v, want uncertain with an error", result, err)
+	}
+}
+
+func TestUpgradeFailedRetryLimit(t *testing.T) {
+	s := gtm.NewMemoryStorage()
+	tx := gtm.NewManager(s).New("test-upgrade-limit").SetRetryLimit(2, 0).AddCertain(&Shipper{City: "Beijing"})
+	if err := tx.ExecuteAsync(); err != nil {
+		t.Fatalf("ExecuteAsync() err = %v", err)
+	}
+
+	// The failed upgrade counts as an attempt, and delays the next one.
+	tx.Versions["test.shipper"] = 3
+	for i := 1; i <= 2; i++ {
+		if result, err := tx.ExecuteRetry(); result != gtm.Uncertain || err == nil {
+			t.Fatalf("ExecuteRetry() of a newer version = %v, %v, want uncertain with an error", result, err)
+		}
+		if txs, _ := s.GetTimeoutTransactions(10); len(txs) != 0 {
+			t.Errorf("GetTimeoutTransactions() after %v attempts = %v, want none before the retry time", i, len(txs))
+		}
+	}
+	if tx.Times != 2 {
+		t.Errorf("times = %v, want 2", tx.Times)
+	}
+
+	if result, err := tx.ExecuteRetry(); result != gtm.Dead {
+		t.Errorf("ExecuteRetry() after the limit = %v, %v, want dead", result, err)
+	}
+}
+
+func TestUpgradeMissing(t *testing.T) {
+	tx := gtm.NewManager(gtm.NewMemoryStorage()).New("test-upgrade-missing").AddCertain(&Mover{OrderID: "100001"})
+	if err := tx.ExecuteAsync(); err != nil {
+		t.Fatalf("ExecuteAsync() err = %v", err)
+	}
+
+	tx.Versions = nil
+	if result, err := tx.ExecuteRetry(); result != gtm.Uncertain || err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("ExecuteRetry() of version 1 = %v, %v, want uncertain with the missing version", result, err)
+	}
+
+	tx.Versions = map[string]int{"test.mover": 2}
+	if result, err := tx.ExecuteRetry(); result != gtm.Success {
+		t.Errorf("ExecuteRetry() of version 2 = %v, %v, want success", result, err)
+	}
+}